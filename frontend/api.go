@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
 )
 
 const maxRequestBodySize = 100 * 1024 // 100KB
@@ -17,12 +24,51 @@ type apiRequest struct {
 type apiGenericResultPair struct {
 	Server string `json:"server"`
 	Data   string `json:"data"`
+	// Seconds since the result was cached, omitted if fresh
+	CacheAge float64 `json:"cache_age,omitempty"`
 }
 
 type apiSummaryResultPair struct {
-	Server string           `json:"server"`
-	Data   []SummaryRowData `json:"data"`
-	Error  string           `json:"error,omitempty"`
+	Server   string           `json:"server"`
+	Data     []SummaryRowData `json:"data"`
+	Error    string           `json:"error,omitempty"`
+	CacheAge float64          `json:"cache_age,omitempty"`
+	// Output exceeded max_response_size, so the last protocols are missing
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type apiDetailResultPair struct {
+	Server string `json:"server"`
+	// Null if the protocol is not found on the server
+	Data     *birdparser.Protocol `json:"data"`
+	Error    string               `json:"error,omitempty"`
+	CacheAge float64              `json:"cache_age,omitempty"`
+}
+
+type apiRouteResultPair struct {
+	Server   string             `json:"server"`
+	Data     []birdparser.Route `json:"data"`
+	Error    string             `json:"error,omitempty"`
+	CacheAge float64            `json:"cache_age,omitempty"`
+}
+
+type apiCompareResultPair struct {
+	Server string `json:"server"`
+	// Null if the server has no route to the prefix
+	Best       *birdparser.Route  `json:"best"`
+	Alternates []birdparser.Route `json:"alternates"`
+	// Fields of the best route that don't match most other servers
+	Differs  []string `json:"differs,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	CacheAge float64  `json:"cache_age,omitempty"`
+}
+
+type apiTracerouteHopsResultPair struct {
+	Server   string                 `json:"server"`
+	Format   string                 `json:"format,omitempty"`
+	Data     []tracerouteparser.Hop `json:"data"`
+	Error    string                 `json:"error,omitempty"`
+	CacheAge float64                `json:"cache_age,omitempty"`
 }
 
 type apiResponse struct {
@@ -30,23 +76,45 @@ type apiResponse struct {
 	Result []interface{} `json:"result"`
 }
 
-var apiHandlerMap = map[string](func(request apiRequest) apiResponse){
-	"summary":     apiSummaryHandler,
-	"bird":        apiGenericHandlerFactory("bird"),
-	"traceroute":  apiGenericHandlerFactory("traceroute"),
-	"whois":       apiWhoisHandler,
-	"server_list": apiServerListHandler,
+var apiHandlerMap = map[string](func(ctx context.Context, request apiRequest) apiResponse){
+	"summary":         apiSummaryHandler,
+	"detail":          apiDetailHandler,
+	"route":           apiRouteHandler,
+	"compare":         apiCompareHandler,
+	"bird":            apiGenericHandlerFactory("bird"),
+	"traceroute":      apiGenericHandlerFactory("traceroute"),
+	"traceroute_hops": apiTracerouteHopsHandler,
+	"ping":            apiGenericHandlerFactory("ping"),
+	"whois":           apiWhoisHandler,
+	"server_list":     apiServerListHandler,
+	"status":          apiStatusHandler,
 }
 
-func apiGenericHandlerFactory(endpoint string) func(request apiRequest) apiResponse {
-	return func(request apiRequest) apiResponse {
-		results := batchRequest(request.Servers, endpoint, request.Args)
+// Type of the items in Result of each request type, for the OpenAPI document
+var apiResultTypes = map[string]interface{}{
+	"summary":         apiSummaryResultPair{},
+	"detail":          apiDetailResultPair{},
+	"route":           apiRouteResultPair{},
+	"compare":         apiCompareResultPair{},
+	"bird":            apiGenericResultPair{},
+	"traceroute":      apiGenericResultPair{},
+	"traceroute_hops": apiTracerouteHopsResultPair{},
+	"ping":            apiGenericResultPair{},
+	"whois":           apiGenericResultPair{},
+	"server_list":     apiGenericResultPair{},
+	"status":          serverStatus{},
+}
+
+func apiGenericHandlerFactory(endpoint string) func(ctx context.Context, request apiRequest) apiResponse {
+	return func(ctx context.Context, request apiRequest) apiResponse {
+		results, cachedAt, _ := batchRequestCached(ctx, request.Servers, endpoint, request.Args, "")
 		var response apiResponse
 
 		for i, result := range results {
 			response.Result = append(response.Result, &apiGenericResultPair{
-				Server: request.Servers[i],
-				Data:   result,
+				Server:   request.Servers[i],
+				Data:     result,
+				CacheAge: cacheAgeSeconds(cachedAt[i]),
 			})
 		}
 
@@ -54,7 +122,7 @@ func apiGenericHandlerFactory(endpoint string) func(request apiRequest) apiRespo
 	}
 }
 
-func apiServerListHandler(request apiRequest) apiResponse {
+func apiServerListHandler(ctx context.Context, request apiRequest) apiResponse {
 	var response apiResponse
 
 	for _, server := range setting.servers {
@@ -66,8 +134,25 @@ func apiServerListHandler(request apiRequest) apiResponse {
 	return response
 }
 
-func apiSummaryHandler(request apiRequest) apiResponse {
-	results := batchRequest(request.Servers, "bird", "show protocols")
+// Status of the servers in the request, or all servers if none is given
+func apiStatusHandler(ctx context.Context, request apiRequest) apiResponse {
+	servers := request.Servers
+	if len(servers) == 0 {
+		servers = setting.servers
+	}
+	if len(servers) > len(setting.servers) {
+		return apiErrorHandler(errors.New("too many servers specified"))
+	}
+
+	var response apiResponse
+	for _, status := range serverStatuses.get(ctx, servers) {
+		response.Result = append(response.Result, status)
+	}
+	return response
+}
+
+func apiSummaryHandler(ctx context.Context, request apiRequest) apiResponse {
+	results, cachedAt, _ := batchRequestCached(ctx, request.Servers, "bird", summaryCommand(), "")
 	var response apiResponse
 
 	for i, result := range results {
@@ -82,21 +167,160 @@ func apiSummaryHandler(request apiRequest) apiResponse {
 		}
 
 		response.Result = append(response.Result, &apiSummaryResultPair{
-			Server: request.Servers[i],
-			Data:   parsedSummary.Rows,
+			Server:    request.Servers[i],
+			Data:      parsedSummary.Rows,
+			CacheAge:  cacheAgeSeconds(cachedAt[i]),
+			Truncated: parsedSummary.Truncated,
 		})
 	}
 
 	return response
 }
 
-func apiWhoisHandler(request apiRequest) apiResponse {
+// Check if a protocol name can be quoted in "show protocols all '%s'"
+func detailNameValid(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "' \t\r\n")
+}
+
+// Check if the target of "show route for %s" is an IP address or prefix
+func routeTargetValid(target string) bool {
+	if _, err := netip.ParsePrefix(target); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(target)
+	return err == nil
+}
+
+// The protocol is not in the output of "show protocols all"
+var errProtocolNotFound = errors.New("not found")
+
+// Find a protocol in the output of "show protocols all", unless it's hidden
+// by name_filter or protocol_filter. Returns the output as error if BIRD
+// returned an error instead.
+func detailParse(result string, name string) (*birdparser.Protocol, error) {
+	protocols := birdparser.ParseProtocols(result)
+	filtered := summaryFilter()
+	for _, protocol := range protocols {
+		if protocol.Name == name && !filtered(SummaryRowData{Name: protocol.Name, Proto: protocol.Proto}) {
+			return &protocol, nil
+		}
+	}
+	if message := strings.TrimSpace(result); len(protocols) == 0 && message != "" {
+		return nil, errors.New(message)
+	}
+	return nil, fmt.Errorf("protocol %s %w", name, errProtocolNotFound)
+}
+
+// Parsed "show protocols all" of the protocol in args
+func apiDetailHandler(ctx context.Context, request apiRequest) apiResponse {
+	name := strings.TrimSpace(request.Args)
+	if !detailNameValid(name) {
+		return apiErrorHandler(errors.New("invalid protocol name"))
+	}
+
+	results, cachedAt, _ := batchRequestCached(ctx, request.Servers, "bird", fmt.Sprintf(primitiveMap["detail"], name), "")
+	var response apiResponse
+
+	for i, result := range results {
+		protocol, err := detailParse(result, name)
+		if err != nil {
+			response.Result = append(response.Result, &apiDetailResultPair{
+				Server: request.Servers[i],
+				Error:  err.Error(),
+			})
+			continue
+		}
+
+		response.Result = append(response.Result, &apiDetailResultPair{
+			Server:   request.Servers[i],
+			Data:     protocol,
+			CacheAge: cacheAgeSeconds(cachedAt[i]),
+		})
+	}
+
+	return response
+}
+
+func apiRouteHandler(ctx context.Context, request apiRequest) apiResponse {
+	prefix := strings.TrimSpace(request.Args)
+	if !routeTargetValid(prefix) {
+		return apiErrorHandler(errors.New("prefix must be an IP address or prefix"))
+	}
+
+	results, cachedAt, _ := batchRequestCached(ctx, request.Servers, "bird", fmt.Sprintf(primitiveMap["route_all"], prefix), "")
+	var response apiResponse
+
+	for i, result := range results {
+		routes := birdparser.ParseRoutes(result)
+		if len(routes) == 0 {
+			// Likely backend returned an error message, or no route exists
+			response.Result = append(response.Result, &apiRouteResultPair{
+				Server: request.Servers[i],
+				Data:   routes,
+				Error:  strings.TrimSpace(result),
+			})
+			continue
+		}
+
+		response.Result = append(response.Result, &apiRouteResultPair{
+			Server:   request.Servers[i],
+			Data:     routes,
+			CacheAge: cacheAgeSeconds(cachedAt[i]),
+		})
+	}
+
+	return response
+}
+
+// Best and alternate routes to the prefix in args on each server, with
+// fields that don't match other servers
+func apiCompareHandler(ctx context.Context, request apiRequest) apiResponse {
+	prefix := strings.TrimSpace(request.Args)
+	if !routeTargetValid(prefix) {
+		return apiErrorHandler(errors.New("prefix must be an IP address or prefix"))
+	}
+
+	var response apiResponse
+	for i, result := range compareRequest(ctx, request.Servers, prefix) {
+		response.Result = append(response.Result, &apiCompareResultPair{
+			Server:     request.Servers[i],
+			Best:       result.Best,
+			Alternates: result.Alternates,
+			Differs:    result.Differs,
+			Error:      result.Error,
+			CacheAge:   cacheAgeSeconds(result.cachedAt),
+		})
+	}
+
+	return response
+}
+
+func apiTracerouteHopsHandler(ctx context.Context, request apiRequest) apiResponse {
+	results := tracerouteHopsRequest(ctx, request.Servers, request.Args)
+	var response apiResponse
+
+	for i, result := range results {
+		response.Result = append(response.Result, &apiTracerouteHopsResultPair{
+			Server:   request.Servers[i],
+			Format:   result.Format,
+			Data:     result.Hops,
+			Error:    result.Error,
+			CacheAge: cacheAgeSeconds(result.cachedAt),
+		})
+	}
+
+	return response
+}
+
+func apiWhoisHandler(ctx context.Context, request apiRequest) apiResponse {
+	result, cachedAt := whoisCached(request.Args)
 	return apiResponse{
 		Error: "",
 		Result: []interface{}{
 			apiGenericResultPair{
-				Server: "",
-				Data:   whois(request.Args),
+				Server:   "",
+				Data:     result,
+				CacheAge: cacheAgeSeconds(cachedAt),
 			},
 		},
 	}
@@ -112,6 +336,7 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 	var request apiRequest
 	var response apiResponse
+	status := http.StatusOK
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
 		if err.Error() == "http: request body too large" {
@@ -126,10 +351,24 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		if handler == nil {
 			response = apiErrorHandler(errors.New("invalid request type"))
 		} else {
-			response = handler(request)
+			request.Servers = expandServers(request.Servers)
+			if primitive, ok := apiAuthPrimitives[request.Type]; ok && !authAllowed(r, primitive) {
+				status = http.StatusForbidden
+				response = apiErrorHandler(errors.New("request type " + request.Type + " is not allowed"))
+			} else if ok, retryAfter := rateLimitAllow(r, apiRateLimitCategories[request.Type], len(request.Servers)); !ok {
+				w.Header().Set("Retry-After", retryAfter)
+				status = http.StatusTooManyRequests
+				response = apiErrorHandler(errors.New("too many requests, please try again in " + retryAfter + " seconds"))
+			} else {
+				response = handler(r.Context(), request)
+			}
 		}
 	}
 
+	apiWriteResponse(w, status, response)
+}
+
+func apiWriteResponse(w http.ResponseWriter, status int, response apiResponse) {
 	w.Header().Add("Content-Type", "application/json")
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	bytes, err := json.Marshal(response)
@@ -137,5 +376,6 @@ func apiHandler(w http.ResponseWriter, r *http.Request) {
 		println(err.Error())
 		return
 	}
+	w.WriteHeader(status)
 	w.Write(bytes)
 }
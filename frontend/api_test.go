@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
@@ -16,7 +17,7 @@ import (
 
 func TestApiServerListHandler(t *testing.T) {
 	setting.servers = []string{"alpha", "beta", "gamma"}
-	response := apiServerListHandler(apiRequest{})
+	response := apiServerListHandler(context.Background(), apiRequest{})
 
 	assert.Equal(t, len(response.Result), 3)
 	assert.Equal(t, response.Result[0].(apiGenericResultPair).Server, "alpha")
@@ -42,7 +43,7 @@ func TestApiGenericHandlerFactory(t *testing.T) {
 	}
 
 	handler := apiGenericHandlerFactory("bird")
-	response := handler(request)
+	response := handler(context.Background(), request)
 
 	assert.Equal(t, response.Error, "")
 
@@ -51,6 +52,30 @@ func TestApiGenericHandlerFactory(t *testing.T) {
 	assert.Equal(t, result.Data, BirdSummaryData)
 }
 
+func TestApiPingHandler(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Response")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "ping",
+		Args:    "1.1.1.1",
+	}
+
+	response := apiHandlerMap["ping"](context.Background(), request)
+
+	assert.Equal(t, response.Error, "")
+	result := response.Result[0].(*apiGenericResultPair)
+	assert.Equal(t, result.Data, "Mock Response")
+}
+
 func TestApiSummaryHandler(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -67,7 +92,7 @@ func TestApiSummaryHandler(t *testing.T) {
 		Type:    "summary",
 		Args:    "",
 	}
-	response := apiSummaryHandler(request)
+	response := apiSummaryHandler(context.Background(), request)
 
 	assert.Equal(t, response.Error, "")
 
@@ -83,6 +108,37 @@ func TestApiSummaryHandler(t *testing.T) {
 	assert.Equal(t, summary.Data[0].Info, "")
 }
 
+func TestApiSummaryHandlerRouteCounts(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt"))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all"), httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	setting.summaryRouteCounts = true
+	defer func() { setting.summaryRouteCounts = false }()
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "summary",
+		Args:    "",
+	}
+	response := apiSummaryHandler(context.Background(), request)
+
+	assert.Equal(t, response.Error, "")
+
+	summary := response.Result[0].(*apiSummaryResultPair)
+	for _, row := range summary.Data {
+		if row.Name == "dn42_alpha" {
+			assert.Equal(t, row.Routes["ipv4"].Imported, uint64(680))
+			assert.Equal(t, row.Routes["ipv4"].Exported, uint64(512))
+		}
+	}
+}
+
 func TestApiSummaryHandlerError(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -99,7 +155,7 @@ func TestApiSummaryHandlerError(t *testing.T) {
 		Type:    "summary",
 		Args:    "",
 	}
-	response := apiSummaryHandler(request)
+	response := apiSummaryHandler(context.Background(), request)
 
 	assert.Equal(t, response.Error, "")
 
@@ -107,6 +163,128 @@ func TestApiSummaryHandlerError(t *testing.T) {
 	assert.Equal(t, summary.Error, "Mock backend error")
 }
 
+func TestApiDetailHandler(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt"))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all 'dn42_alpha'"), httpResponse)
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+url.QueryEscape("show protocols all 'dn42_alpha'"),
+		httpmock.NewStringResponder(200, "syntax error, unexpected CF_SYM_UNDEFINED\n"))
+
+	setting.servers = []string{"alpha", "beta"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "detail",
+		Args:    "dn42_alpha",
+	}
+	response := apiDetailHandler(context.Background(), request)
+
+	assert.Equal(t, response.Error, "")
+
+	detail := response.Result[0].(*apiDetailResultPair)
+	assert.Equal(t, detail.Error, "")
+	assert.Equal(t, detail.Data.Name, "dn42_alpha")
+	assert.Equal(t, detail.Data.BGP.State, "Established")
+	assert.Equal(t, detail.Data.BGP.NeighborAddress, "fe80::1234%dn42-alpha")
+	assert.Equal(t, detail.Data.BGP.LocalAS, uint32(4242420001))
+	assert.Equal(t, detail.Data.BGP.LocalCapabilities[0].Name, "Multiprotocol")
+	assert.Equal(t, detail.Data.Channels[0].Routes.Filtered, uint64(3))
+	assert.Equal(t, detail.Data.Channels[0].ImportLimit.Limit, uint64(10000))
+
+	detail = response.Result[1].(*apiDetailResultPair)
+	assert.Equal(t, detail.Data == nil, true)
+	assert.Equal(t, detail.Error, "syntax error, unexpected CF_SYM_UNDEFINED")
+}
+
+func TestApiDetailHandlerInvalidName(t *testing.T) {
+	setting.servers = []string{"alpha"}
+	for _, name := range []string{"", "bgp1' all", "bgp1 all"} {
+		response := apiDetailHandler(context.Background(), apiRequest{
+			Servers: setting.servers,
+			Type:    "detail",
+			Args:    name,
+		})
+		assert.Equal(t, response.Error, "invalid protocol name")
+	}
+}
+
+func TestDetailParseFiltered(t *testing.T) {
+	output := readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt")
+	setting.protocolFilter = []string{"Kernel"}
+	defer func() { setting.protocolFilter = nil }()
+
+	_, err := detailParse(output, "dn42_alpha")
+	assert.Equal(t, err.Error(), "protocol dn42_alpha not found")
+	protocol, err := detailParse(output, "kernel1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, protocol.Proto, "Kernel")
+}
+
+func TestApiRouteHandler(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	input := readDataFile(t, "frontend/test_data/bgpmap_case1.txt")
+	httpResponse := httpmock.NewStringResponder(200, input)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 172.20.0.53 all"), httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "route",
+		Args:    "172.20.0.53",
+	}
+	response := apiRouteHandler(context.Background(), request)
+
+	assert.Equal(t, response.Error, "")
+
+	routes := response.Result[0].(*apiRouteResultPair)
+	assert.Equal(t, routes.Server, "alpha")
+	assert.Equal(t, routes.Error, "")
+	assert.Equal(t, routes.Data[0].Prefix, "172.20.0.53/32")
+	assert.Equal(t, routes.Data[0].Preferred, true)
+	assert.Equal(t, routes.Data[0].BGP.ASPath, []uint32{4242423914})
+}
+
+func TestApiRouteHandlerError(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Network not found")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 192.0.2.1 all"), httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "route",
+		Args:    "192.0.2.1",
+	}
+	response := apiRouteHandler(context.Background(), request)
+
+	routes := response.Result[0].(*apiRouteResultPair)
+	assert.Equal(t, len(routes.Data), 0)
+	assert.Equal(t, routes.Error, "Network not found")
+}
+
+func TestApiRouteHandlerInvalid(t *testing.T) {
+	response := apiRouteHandler(context.Background(), apiRequest{
+		Servers: []string{"alpha"},
+		Type:    "route",
+		Args:    "172.20.0.53 protocol static1",
+	})
+	assert.Equal(t, response.Error, "prefix must be an IP address or prefix")
+}
+
 func TestApiWhoisHandler(t *testing.T) {
 	expectedData := "Mock Data"
 	server := WhoisServer{
@@ -126,7 +304,7 @@ func TestApiWhoisHandler(t *testing.T) {
 		Type:    "",
 		Args:    "AS6939",
 	}
-	response := apiWhoisHandler(request)
+	response := apiWhoisHandler(context.Background(), request)
 
 	assert.Equal(t, response.Error, "")
 
@@ -230,3 +408,31 @@ func TestApiHandlerInvalidType(t *testing.T) {
 
 	assert.Equal(t, len(response.Result), 0)
 }
+
+func TestApiTracerouteHopsHandler(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, tracerouteJSONResult)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/traceroute?q=1.1.1.1&format=json", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "traceroute_hops",
+		Args:    "1.1.1.1",
+	}
+	response := apiTracerouteHopsHandler(context.Background(), request)
+
+	assert.Equal(t, response.Error, "")
+
+	hops := response.Result[0].(*apiTracerouteHopsResultPair)
+	assert.Equal(t, hops.Server, "alpha")
+	assert.Equal(t, hops.Format, "traceroute")
+	assert.Equal(t, hops.Error, "")
+	assert.Equal(t, len(hops.Data), 3)
+	assert.Equal(t, hops.Data[0].Address, "192.168.1.1")
+}
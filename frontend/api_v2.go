@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
+)
+
+// Prefix of the REST API, served alongside the POST API on /api/
+const apiV2Prefix = "/api/v2/"
+
+// Reply of BIRD to commands on protocols that don't exist
+const birdNoProtocolsMatch = "No protocols match"
+
+// Error codes of the REST API, in apiV2Error.Code
+const (
+	// Invalid parameters of the request
+	apiV2ErrorInvalid = "invalid_request"
+	// Unknown server, protocol or path
+	apiV2ErrorNotFound = "not_found"
+	// Not allowed for the role of the client
+	apiV2ErrorForbidden = "forbidden"
+	// Over the rate limit
+	apiV2ErrorRateLimited = "rate_limited"
+	// lgproxy of the server could not be reached, or returned nothing
+	apiV2ErrorUnavailable = "unavailable"
+	// The endpoint is disabled on the server
+	apiV2ErrorDisabled = "disabled"
+	// BIRD or the command on the server returned an error
+	apiV2ErrorBackend = "backend_error"
+)
+
+type apiV2Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Response of requests that failed as a whole, e.g. with invalid parameters
+type apiV2ErrorResponse struct {
+	Error apiV2Error `json:"error"`
+}
+
+// Fields common to the result of each server. Error is set if the request
+// failed on the server, in which case Data is empty.
+type apiV2ServerResult struct {
+	Server string      `json:"server"`
+	Error  *apiV2Error `json:"error,omitempty"`
+	// Seconds since the result was cached, omitted if fresh
+	CacheAge float64 `json:"cache_age,omitempty"`
+}
+
+type apiV2Server struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Group       string `json:"group,omitempty"`
+	Location    string `json:"location,omitempty"`
+}
+
+type apiV2ServerGroup struct {
+	Name    string   `json:"name"`
+	Servers []string `json:"servers"`
+}
+
+type apiV2ServersResponse struct {
+	Servers []apiV2Server      `json:"servers"`
+	Groups  []apiV2ServerGroup `json:"groups"`
+}
+
+type apiV2ProtocolsResult struct {
+	apiV2ServerResult
+	Data []birdparser.Protocol `json:"data"`
+	// Output exceeded max_response_size, so the last protocols are missing
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type apiV2ProtocolsResponse struct {
+	Results []apiV2ProtocolsResult `json:"results"`
+}
+
+type apiV2ProtocolResult struct {
+	apiV2ServerResult
+	Data *birdparser.Protocol `json:"data"`
+}
+
+type apiV2ProtocolResponse struct {
+	Results []apiV2ProtocolResult `json:"results"`
+}
+
+type apiV2RoutesResult struct {
+	apiV2ServerResult
+	Data []birdparser.Route `json:"data"`
+}
+
+type apiV2RoutesResponse struct {
+	Results []apiV2RoutesResult `json:"results"`
+}
+
+type apiV2TracerouteResult struct {
+	apiV2ServerResult
+	// Format of the output parsed by lgproxy, e.g. mtr or traceroute
+	Format string                 `json:"format,omitempty"`
+	Data   []tracerouteparser.Hop `json:"data"`
+}
+
+type apiV2TracerouteResponse struct {
+	Results []apiV2TracerouteResult `json:"results"`
+}
+
+type apiV2WhoisResponse struct {
+	Data     string  `json:"data"`
+	CacheAge float64 `json:"cache_age,omitempty"`
+}
+
+// Endpoint of the REST API, also described in the OpenAPI document
+type apiV2Endpoint struct {
+	// Go 1.22 pattern, e.g. "GET /api/v2/servers/{servers}/protocols"
+	Pattern     string
+	Handler     http.HandlerFunc
+	OperationID string
+	Summary     string
+	// Required query parameters, with their descriptions
+	Query map[string]string
+	// Response on success
+	Response interface{}
+}
+
+// Endpoints of the REST API. {servers} has the same form as in URLs of the
+// web UI, e.g. alpha+beta or @eu.
+var apiV2Endpoints = []apiV2Endpoint{
+	{
+		Pattern:     "GET /api/v2/servers",
+		Handler:     apiV2ServersHandler,
+		OperationID: "listServers",
+		Summary:     "List servers and server groups",
+		Response:    apiV2ServersResponse{},
+	},
+	{
+		Pattern:     "GET /api/v2/servers/{servers}/protocols",
+		Handler:     apiV2ProtocolsHandler,
+		OperationID: "listProtocols",
+		Summary:     "Protocols of servers, from show protocols",
+		Response:    apiV2ProtocolsResponse{},
+	},
+	{
+		Pattern:     "GET /api/v2/servers/{servers}/protocols/{name}",
+		Handler:     apiV2ProtocolHandler,
+		OperationID: "getProtocol",
+		Summary:     "Details of a protocol on servers, from show protocols all",
+		Response:    apiV2ProtocolResponse{},
+	},
+	{
+		Pattern:     "GET /api/v2/servers/{servers}/routes",
+		Handler:     apiV2RoutesHandler,
+		OperationID: "listRoutes",
+		Summary:     "Routes to an IP address or prefix on servers, from show route for ... all",
+		Query:       map[string]string{"prefix": "IP address or prefix, e.g. 192.0.2.0/24"},
+		Response:    apiV2RoutesResponse{},
+	},
+	{
+		Pattern:     "GET /api/v2/servers/{servers}/traceroute",
+		Handler:     apiV2TracerouteHandler,
+		OperationID: "traceroute",
+		Summary:     "Traceroute from servers to a target",
+		Query:       map[string]string{"target": "Host name or IP address"},
+		Response:    apiV2TracerouteResponse{},
+	},
+	{
+		Pattern:     "GET /api/v2/whois",
+		Handler:     apiV2WhoisHandler,
+		OperationID: "whois",
+		Summary:     "Whois of a query",
+		Query:       map[string]string{"q": "Query, e.g. AS4242420000 or 172.20.0.53"},
+		Response:    apiV2WhoisResponse{},
+	},
+}
+
+// Router of the REST API
+func apiV2Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, endpoint := range apiV2Endpoints {
+		mux.HandleFunc(endpoint.Pattern, endpoint.Handler)
+	}
+	mux.HandleFunc(apiV2Prefix, func(w http.ResponseWriter, r *http.Request) {
+		// All endpoints only accept GET
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			apiV2WriteError(w, http.StatusMethodNotAllowed, apiV2ErrorInvalid, "method "+r.Method+" is not allowed")
+			return
+		}
+		apiV2WriteError(w, http.StatusNotFound, apiV2ErrorNotFound, "unknown endpoint "+r.URL.Path)
+	})
+	return mux
+}
+
+func apiV2Write(w http.ResponseWriter, status int, response interface{}) {
+	bytes, err := json.Marshal(response)
+	if err != nil {
+		println(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
+
+func apiV2WriteError(w http.ResponseWriter, status int, code string, message string) {
+	apiV2Write(w, status, apiV2ErrorResponse{
+		Error: apiV2Error{Code: code, Message: message},
+	})
+}
+
+// Check if the client may use the primitive and is within the rate limit of
+// the category, or respond with an error. Returns false if the request
+// should not be handled.
+func apiV2Allowed(w http.ResponseWriter, r *http.Request, primitive string, category string, servers int) bool {
+	if !authAllowed(r, primitive) {
+		apiV2WriteError(w, http.StatusForbidden, apiV2ErrorForbidden, primitive+" is not allowed")
+		return false
+	}
+	if ok, retryAfter := rateLimitAllow(r, category, servers); !ok {
+		w.Header().Set("Retry-After", retryAfter)
+		apiV2WriteError(w, http.StatusTooManyRequests, apiV2ErrorRateLimited, "too many requests, please try again in "+retryAfter+" seconds")
+		return false
+	}
+	return true
+}
+
+// Servers of the {servers} path parameter, or respond with 404 if any of
+// them is unknown
+func apiV2ParseServers(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	servers := parseServersParam(r.PathValue("servers"))
+	for _, server := range servers {
+		if !isValidServer(server) {
+			apiV2WriteError(w, http.StatusNotFound, apiV2ErrorNotFound, "unknown server "+server)
+			return nil, false
+		}
+	}
+	return servers, true
+}
+
+// Required query parameter, or respond with 400 if it's missing
+func apiV2Query(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	value := strings.TrimSpace(r.URL.Query().Get(name))
+	if value == "" {
+		apiV2WriteError(w, http.StatusBadRequest, apiV2ErrorInvalid, "missing query parameter "+name)
+		return "", false
+	}
+	return value, true
+}
+
+// Error of a result from lgproxy that has no parsed data, classified by the
+// error of the request. nil if the request succeeded with an empty result.
+func apiV2BackendError(result string, err error) *apiV2Error {
+	message := strings.TrimSpace(result)
+	switch {
+	case message == "" && err == nil:
+		return nil
+	case message == "":
+		message = err.Error()
+	}
+	switch {
+	case errors.Is(err, errEndpointDisabled):
+		return &apiV2Error{Code: apiV2ErrorDisabled, Message: message}
+	case errors.Is(err, errProxyUnavailable) || errors.Is(err, errInvalidServer):
+		return &apiV2Error{Code: apiV2ErrorUnavailable, Message: message}
+	}
+	return &apiV2Error{Code: apiV2ErrorBackend, Message: message}
+}
+
+// Status of a response with results of each server: 200 if any server
+// succeeded, 404 if all failed for not finding something, or 502 otherwise
+func apiV2Status(errs []*apiV2Error) int {
+	notFound := true
+	for _, err := range errs {
+		if err == nil {
+			return http.StatusOK
+		}
+		if err.Code != apiV2ErrorNotFound {
+			notFound = false
+		}
+	}
+	if notFound && len(errs) > 0 {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// Protocols not hidden by name_filter or protocol_filter
+func apiV2FilterProtocols(protocols []birdparser.Protocol) []birdparser.Protocol {
+	filtered := summaryFilter()
+	result := []birdparser.Protocol{}
+	for _, protocol := range protocols {
+		if !filtered(SummaryRowData{Name: protocol.Name, Proto: protocol.Proto}) {
+			result = append(result, protocol)
+		}
+	}
+	return result
+}
+
+func apiV2ServersHandler(w http.ResponseWriter, r *http.Request) {
+	response := apiV2ServersResponse{
+		Servers: []apiV2Server{},
+		Groups:  []apiV2ServerGroup{},
+	}
+	for i, server := range setting.servers {
+		result := apiV2Server{
+			Name:        server,
+			DisplayName: serverDisplayName(setting.servers, i),
+		}
+		if config := serverConfigOf(server); config != nil {
+			result.Group = config.Group
+			result.Location = config.Location
+		}
+		response.Servers = append(response.Servers, result)
+	}
+	for _, group := range setting.serverGroups {
+		response.Groups = append(response.Groups, apiV2ServerGroup{
+			Name:    group.name,
+			Servers: group.servers,
+		})
+	}
+	apiV2Write(w, http.StatusOK, response)
+}
+
+// Parsed "show protocols" of servers
+func apiV2ProtocolsHandler(w http.ResponseWriter, r *http.Request) {
+	servers, ok := apiV2ParseServers(w, r)
+	if !ok || !apiV2Allowed(w, r, "summary", "bird", len(servers)) {
+		return
+	}
+
+	results, cachedAt, requestErrs := batchRequestCached(r.Context(), servers, "bird", summaryCommand(), "")
+	response := apiV2ProtocolsResponse{Results: []apiV2ProtocolsResult{}}
+	errs := make([]*apiV2Error, len(results))
+	for i, result := range results {
+		protocols := birdparser.ParseProtocols(result)
+		item := apiV2ProtocolsResult{
+			apiV2ServerResult: apiV2ServerResult{Server: servers[i]},
+			Data:              apiV2FilterProtocols(protocols),
+			Truncated:         outputTruncated(result),
+		}
+		// Protocols all hidden by filters are not an error
+		if len(protocols) == 0 {
+			item.Error = apiV2BackendError(result, requestErrs[i])
+		}
+		if item.Error == nil {
+			item.CacheAge = cacheAgeSeconds(cachedAt[i])
+		}
+		errs[i] = item.Error
+		response.Results = append(response.Results, item)
+	}
+	apiV2Write(w, apiV2Status(errs), response)
+}
+
+// Parsed "show protocols all" of a protocol on servers
+func apiV2ProtocolHandler(w http.ResponseWriter, r *http.Request) {
+	servers, ok := apiV2ParseServers(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	if !detailNameValid(name) {
+		apiV2WriteError(w, http.StatusBadRequest, apiV2ErrorInvalid, "invalid protocol name "+name)
+		return
+	}
+	if !apiV2Allowed(w, r, "detail", "bird", len(servers)) {
+		return
+	}
+
+	results, cachedAt, requestErrs := batchRequestCached(r.Context(), servers, "bird", fmt.Sprintf(primitiveMap["detail"], name), "")
+	response := apiV2ProtocolResponse{Results: []apiV2ProtocolResult{}}
+	errs := make([]*apiV2Error, len(results))
+	for i, result := range results {
+		item := apiV2ProtocolResult{
+			apiV2ServerResult: apiV2ServerResult{Server: servers[i]},
+		}
+		protocol, err := detailParse(result, name)
+		switch {
+		case err == nil:
+			item.Data = protocol
+			item.CacheAge = cacheAgeSeconds(cachedAt[i])
+		case errors.Is(err, errProtocolNotFound) || strings.Contains(result, birdNoProtocolsMatch):
+			item.Error = &apiV2Error{Code: apiV2ErrorNotFound, Message: "protocol " + name + " not found"}
+		default:
+			item.Error = apiV2BackendError(result, requestErrs[i])
+		}
+		errs[i] = item.Error
+		response.Results = append(response.Results, item)
+	}
+	apiV2Write(w, apiV2Status(errs), response)
+}
+
+// Parsed "show route for ... all" of a prefix or address on servers
+func apiV2RoutesHandler(w http.ResponseWriter, r *http.Request) {
+	servers, ok := apiV2ParseServers(w, r)
+	if !ok {
+		return
+	}
+	prefix, ok := apiV2Query(w, r, "prefix")
+	if !ok {
+		return
+	}
+	if !routeTargetValid(prefix) {
+		apiV2WriteError(w, http.StatusBadRequest, apiV2ErrorInvalid, "prefix must be an IP address or prefix, e.g. 192.0.2.0/24")
+		return
+	}
+	if !apiV2Allowed(w, r, "route_all", "bird", len(servers)) {
+		return
+	}
+
+	results, cachedAt, requestErrs := batchRequestCached(r.Context(), servers, "bird", fmt.Sprintf(primitiveMap["route_all"], prefix), "")
+	response := apiV2RoutesResponse{Results: []apiV2RoutesResult{}}
+	errs := make([]*apiV2Error, len(results))
+	for i, result := range results {
+		item := apiV2RoutesResult{
+			apiV2ServerResult: apiV2ServerResult{Server: servers[i]},
+			Data:              birdparser.ParseRoutes(result),
+		}
+		// No routes to the prefix is not an error
+		if len(item.Data) == 0 && !strings.Contains(result, "Network not found") {
+			item.Error = apiV2BackendError(result, requestErrs[i])
+		}
+		if item.Error == nil {
+			item.CacheAge = cacheAgeSeconds(cachedAt[i])
+		}
+		errs[i] = item.Error
+		response.Results = append(response.Results, item)
+	}
+	apiV2Write(w, apiV2Status(errs), response)
+}
+
+// Parsed traceroute hops to a target from servers
+func apiV2TracerouteHandler(w http.ResponseWriter, r *http.Request) {
+	servers, ok := apiV2ParseServers(w, r)
+	if !ok {
+		return
+	}
+	target, ok := apiV2Query(w, r, "target")
+	if !ok {
+		return
+	}
+	// Only a target, options of traceroute are not passed through
+	if strings.ContainsAny(target, " \t\r\n") || strings.HasPrefix(target, "-") {
+		apiV2WriteError(w, http.StatusBadRequest, apiV2ErrorInvalid, "target must be a host name or IP address")
+		return
+	}
+	if !apiV2Allowed(w, r, "traceroute", "traceroute", len(servers)) {
+		return
+	}
+
+	response := apiV2TracerouteResponse{Results: []apiV2TracerouteResult{}}
+	results := tracerouteHopsRequest(r.Context(), servers, target)
+	errs := make([]*apiV2Error, len(results))
+	for i, result := range results {
+		item := apiV2TracerouteResult{
+			apiV2ServerResult: apiV2ServerResult{Server: servers[i]},
+			Format:            result.Format,
+			Data:              result.Hops,
+		}
+		if result.Error != "" {
+			item.Error = apiV2BackendError(result.Error, result.err)
+		} else {
+			item.CacheAge = cacheAgeSeconds(result.cachedAt)
+		}
+		errs[i] = item.Error
+		response.Results = append(response.Results, item)
+	}
+	apiV2Write(w, apiV2Status(errs), response)
+}
+
+// Whois of a target, queried by the frontend
+func apiV2WhoisHandler(w http.ResponseWriter, r *http.Request) {
+	query, ok := apiV2Query(w, r, "q")
+	if !ok || !apiV2Allowed(w, r, "whois", "whois", 1) {
+		return
+	}
+
+	result, cachedAt := whoisCached(query)
+	apiV2Write(w, http.StatusOK, apiV2WhoisResponse{
+		Data:     result,
+		CacheAge: cacheAgeSeconds(cachedAt),
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+)
+
+func apiV2Request(t *testing.T, method string, path string, response interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	apiV2Handler().ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	if response != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s: %v: %s", path, err, w.Body.String())
+		}
+	}
+	return w
+}
+
+func apiV2MockServers(t *testing.T) {
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	setting.servers = []string{"alpha", "beta"}
+	setting.serversDisplay = []string{"Alpha", "Beta"}
+	setting.serverGroups = []serverGroup{{name: "eu", servers: []string{"alpha", "beta"}}}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	t.Cleanup(func() { setting.serverGroups = nil })
+}
+
+func TestApiV2Servers(t *testing.T) {
+	apiV2MockServers(t)
+
+	var response apiV2ServersResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Equal(t, response.Servers, []apiV2Server{
+		{Name: "alpha", DisplayName: "Alpha"},
+		{Name: "beta", DisplayName: "Beta"},
+	})
+	assert.Equal(t, response.Groups, []apiV2ServerGroup{{Name: "eu", Servers: []string{"alpha", "beta"}}})
+}
+
+func TestApiV2Protocols(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"),
+		httpmock.NewStringResponder(200, BirdSummaryData))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+url.QueryEscape("show protocols"),
+		httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	var response apiV2ProtocolsResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/@eu/protocols", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, len(response.Results), 2)
+
+	alpha := response.Results[0]
+	assert.Equal(t, alpha.Server, "alpha")
+	assert.Equal(t, alpha.Error == nil, true)
+	assert.Equal(t, len(alpha.Data), 7)
+	assert.Equal(t, alpha.Data[0].Name, "static1")
+	assert.Equal(t, alpha.Data[0].State, "up")
+
+	// Errors are reported for each server
+	beta := response.Results[1]
+	assert.Equal(t, beta.Server, "beta")
+	assert.Equal(t, len(beta.Data), 0)
+	assert.Equal(t, beta.Error.Code, apiV2ErrorUnavailable)
+}
+
+func TestApiV2ProtocolsFiltered(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"),
+		httpmock.NewStringResponder(200, BirdSummaryData))
+	setting.protocolFilter = []string{"Static"}
+	defer func() { setting.protocolFilter = nil }()
+
+	var response apiV2ProtocolsResponse
+	apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols", &response)
+	assert.Equal(t, len(response.Results[0].Data), 2)
+
+	// Protocols all hidden by filters are an empty result, not an error
+	setting.protocolFilter = []string{"OSPF"}
+	response = apiV2ProtocolsResponse{}
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, len(response.Results[0].Data), 0)
+	assert.Equal(t, response.Results[0].Error == nil, true)
+}
+
+func TestApiV2ProtocolsAllFailed(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"),
+		httpmock.NewStringResponder(200, "Mock backend error\n"))
+
+	var response apiV2ProtocolsResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols", &response)
+	assert.Equal(t, w.Code, http.StatusBadGateway)
+	assert.Equal(t, *response.Results[0].Error, apiV2Error{Code: apiV2ErrorBackend, Message: "Mock backend error"})
+}
+
+func TestApiV2Protocol(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all 'dn42_alpha'"),
+		httpmock.NewStringResponder(200, readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt")))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all 'missing'"),
+		httpmock.NewStringResponder(404, "No protocols match\n"))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all 'broken'"),
+		httpmock.NewStringResponder(500, "Mock backend error\n"))
+
+	var response apiV2ProtocolResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols/dn42_alpha", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	protocol := response.Results[0].Data
+	assert.Equal(t, protocol.Name, "dn42_alpha")
+	assert.Equal(t, protocol.BGP.State, "Established")
+	assert.Equal(t, protocol.BGP.NeighborAS, uint32(4242421080))
+
+	response = apiV2ProtocolResponse{}
+	w = apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols/missing", &response)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+	assert.Equal(t, response.Results[0].Data == nil, true)
+	assert.Equal(t, response.Results[0].Error.Code, apiV2ErrorNotFound)
+
+	// Other errors of BIRD are not hidden
+	response = apiV2ProtocolResponse{}
+	w = apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols/broken", &response)
+	assert.Equal(t, w.Code, http.StatusBadGateway)
+	assert.Equal(t, *response.Results[0].Error, apiV2Error{Code: apiV2ErrorBackend, Message: "Mock backend error"})
+
+	var errorResponse apiV2ErrorResponse
+	w = apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/protocols/a'b", &errorResponse)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, errorResponse.Error.Code, apiV2ErrorInvalid)
+}
+
+func TestApiV2Routes(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 172.20.0.53 all"),
+		httpmock.NewStringResponder(200, readDataFile(t, "frontend/test_data/bgpmap_case1.txt")))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+url.QueryEscape("show route for 172.20.0.53 all"),
+		httpmock.NewStringResponder(200, "Network not found\n"))
+
+	var response apiV2RoutesResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha+beta/routes?prefix=172.20.0.53", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, response.Results[0].Data[0].Prefix, "172.20.0.53/32")
+
+	// No routes is not an error
+	assert.Equal(t, response.Results[1].Error == nil, true)
+	assert.Equal(t, len(response.Results[1].Data), 0)
+}
+
+func TestApiV2RoutesInvalid(t *testing.T) {
+	apiV2MockServers(t)
+
+	for _, path := range []string{
+		"/api/v2/servers/alpha/routes",
+		"/api/v2/servers/alpha/routes?prefix=" + url.QueryEscape("1.1.1.1 protocol static1"),
+	} {
+		var response apiV2ErrorResponse
+		w := apiV2Request(t, http.MethodGet, path, &response)
+		assert.Equal(t, w.Code, http.StatusBadRequest)
+		assert.Equal(t, response.Error.Code, apiV2ErrorInvalid)
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 0)
+}
+
+func TestApiV2Traceroute(t *testing.T) {
+	apiV2MockServers(t)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/traceroute?q=1.1.1.1&format=json",
+		httpmock.NewStringResponder(200, `{"format":"traceroute","hops":[{"hop":1,"address":"192.0.2.1","rtt":[1.5],"loss":0}],"raw":"","error":""}`))
+
+	var response apiV2TracerouteResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/traceroute?target=1.1.1.1", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, response.Results[0].Format, "traceroute")
+	assert.Equal(t, len(response.Results[0].Data), 1)
+
+	var errorResponse apiV2ErrorResponse
+	w = apiV2Request(t, http.MethodGet, "/api/v2/servers/alpha/traceroute?target=-I", &errorResponse)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func TestApiV2Whois(t *testing.T) {
+	server := WhoisServer{
+		t:             t,
+		expectedQuery: "AS6939",
+		response:      "Mock Data",
+	}
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.whoisServer = server.server.Addr().String()
+	defer func() { setting.whoisServer = "" }()
+
+	var response apiV2WhoisResponse
+	w := apiV2Request(t, http.MethodGet, "/api/v2/whois?q=AS6939", &response)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, response.Data, "Mock Data")
+}
+
+func TestApiV2Errors(t *testing.T) {
+	apiV2MockServers(t)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		code   string
+	}{
+		{http.MethodGet, "/api/v2/servers/gamma/protocols", http.StatusNotFound, apiV2ErrorNotFound},
+		{http.MethodGet, "/api/v2/servers/@asia/protocols", http.StatusNotFound, apiV2ErrorNotFound},
+		{http.MethodGet, "/api/v2/unknown", http.StatusNotFound, apiV2ErrorNotFound},
+		{http.MethodPost, "/api/v2/servers", http.StatusMethodNotAllowed, apiV2ErrorInvalid},
+		{http.MethodGet, "/api/v2/whois", http.StatusBadRequest, apiV2ErrorInvalid},
+	}
+	for _, test := range tests {
+		var response apiV2ErrorResponse
+		w := apiV2Request(t, test.method, test.path, &response)
+		assert.Equal(t, w.Code, test.status, test.path)
+		assert.Equal(t, response.Error.Code, test.code, test.path)
+	}
+}
+
+func TestApiV2Forbidden(t *testing.T) {
+	apiV2MockServers(t)
+	useAuth(t, testAuthConfig())
+
+	handler := authHandler(apiV2Handler())
+	r := httptest.NewRequest(http.MethodGet, "/api/v2/servers/alpha/routes?prefix=192.0.2.1", nil)
+	r.Header.Set("Authorization", "Bearer secret-token")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	var response apiV2ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, w.Code, http.StatusForbidden)
+	assert.Equal(t, response.Error.Code, apiV2ErrorForbidden)
+}
@@ -4,11 +4,18 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 )
 
 type ASNCache map[string]string
 
 func (cache ASNCache) _lookup(asn string) string {
+	source := "none"
+	start := time.Now()
+	defer func() {
+		metricASNLookupDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	}()
+
 	// Try to get ASN representation using DNS
 	if setting.dnsInterface != "" {
 		records, err := net.LookupTXT(fmt.Sprintf("AS%s.%s", asn, setting.dnsInterface))
@@ -17,6 +24,7 @@ func (cache ASNCache) _lookup(asn string) string {
 			if resultSplit := strings.Split(result, " | "); len(resultSplit) > 1 {
 				result = strings.Join(resultSplit[1:], "\n")
 			}
+			source = "dns"
 			return fmt.Sprintf("AS%s\n%s", asn, result)
 		}
 	}
@@ -59,6 +67,7 @@ func (cache ASNCache) _lookup(asn string) string {
 				}
 			}
 			if len(result) > 0 {
+				source = "whois"
 				return strings.Join(result, "\n")
 			}
 		}
@@ -70,8 +79,10 @@ func (cache ASNCache) _lookup(asn string) string {
 func (cache ASNCache) Lookup(asn string) string {
 	cachedValue, cacheOk := cache[asn]
 	if cacheOk {
+		metricASNCacheRequests.WithLabelValues("hit").Inc()
 		return cachedValue
 	}
+	metricASNCacheRequests.WithLabelValues("miss").Inc()
 
 	result := cache._lookup(asn)
 	if len(result) == 0 {
@@ -29,13 +29,13 @@ function sortTable(table, priCol, secCol, priDir, secDir) {
     const tableBody = table.querySelector('tbody');
     const tableData = table2data(tableBody);
     tableData.sort((a, b) => {
-        if(a[priCol] === b[priCol]) {
-            if(a[secCol] > b[secCol]) {
+        if(sortKey(a[priCol]) === sortKey(b[priCol])) {
+            if(sortKey(a[secCol]) > sortKey(b[secCol])) {
                 return secDir;
             } else {
                 return -secDir;
             }
-        } else if(a[priCol] > b[priCol]) {
+        } else if(sortKey(a[priCol]) > sortKey(b[priCol])) {
             return priDir;
         } else {
             return -priDir;
@@ -44,6 +44,14 @@ function sortTable(table, priCol, secCol, priDir, secDir) {
     data2table(tableBody, tableData);
 }
 
+// cells with a data-sort attribute, e.g. route counts, are sorted as numbers
+function sortKey(cell) {
+    if(cell.sort !== undefined) {
+        return Number(cell.sort);
+    }
+    return cell.html;
+}
+
 function table2data(tableBody) {
     const tableData = [];
     tableBody.querySelectorAll('tr')
@@ -51,7 +59,7 @@ function table2data(tableBody) {
             const rowData = [];
             row.querySelectorAll('td')
                 .forEach(cell => {
-                    rowData.push(cell.innerHTML);
+                    rowData.push({html: cell.innerHTML, sort: cell.dataset.sort});
                 });
             rowData.classList = row.classList.toString();
             tableData.push(rowData);
@@ -66,7 +74,10 @@ function data2table(tableBody, tableData) {
             row.classList = rowData.classList;
             row.querySelectorAll('td')
                 .forEach((cell, j) => {
-                    cell.innerHTML = rowData[j];
+                    cell.innerHTML = rowData[j].html;
+                    if(rowData[j].sort !== undefined) {
+                        cell.dataset.sort = rowData[j].sort;
+                    }
                 });
             tableData.push(rowData);
         });
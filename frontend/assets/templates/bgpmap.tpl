@@ -1,4 +1,5 @@
-<h2>BGPmap: {{ html .Target }}</h2>
+<h2>BGPmap: {{ html .Target }}</h2>{{ if .CacheAge }}
+<p class="text-muted"><small>Cached {{ html .CacheAge }} ago</small></p>{{ end }}
 <div id="bgpmap">
 </div>
 
@@ -1,2 +1,3 @@
-<h2>{{ html .ServerName }}: {{ html .Target }}</h2>
+<h2>{{ html .ServerName }}: {{ html .Target }}</h2>{{ if .CacheAge }}
+<p class="text-muted"><small>Cached {{ html .CacheAge }} ago</small></p>{{ end }}
 {{ .Result }}
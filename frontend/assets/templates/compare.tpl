@@ -0,0 +1,43 @@
+<h2>Compare routes to {{ html .Target }}</h2>{{ if .CacheAge }}
+<p class="text-muted"><small>Cached {{ html .CacheAge }} ago</small></p>{{ end }}
+{{ if .Error }}
+<pre>{{ html .Error }}</pre>
+{{ end }}
+{{ if .Rows }}
+<p class="text-muted"><small>Best routes of each server. Highlighted values don't match most other servers.</small></p>
+<table class="table table-bordered table-sm">
+  <thead>
+    <th scope="col">Server</th>
+    <th scope="col">Prefix</th>
+    <th scope="col">Protocol</th>
+    <th scope="col">Nexthop</th>
+    <th scope="col">AS path</th>
+    <th scope="col">Local pref</th>
+    <th scope="col">Communities</th>
+    <th scope="col">Alternates</th>
+  </thead>
+  <tbody>
+{{ range .Rows }}
+    <tr>
+      <td><a href="/route_all/{{ pathescape .Server }}/{{ pathescape $.Target }}">{{ html .ServerName }}</a></td>
+{{ if .Values }}
+{{ $Differs := .Differs }}
+      <td{{ if index $Differs "prefix" }} class="table-warning"{{ end }}>{{ html (index .Values "prefix") }}</td>
+      <td>{{ html (index .Values "protocol") }}</td>
+      <td>{{ html (index .Values "nexthop") }}</td>
+      <td{{ if index $Differs "as_path" }} class="table-warning"{{ end }}>{{ html (index .Values "as_path") }}</td>
+      <td{{ if index $Differs "local_pref" }} class="table-warning"{{ end }}>{{ html (index .Values "local_pref") }}</td>
+      <td{{ if index $Differs "communities" }} class="table-warning"{{ end }}><small>{{ html (index .Values "communities") }}</small></td>
+      <td>
+{{ range .Alternates }}
+        <div><small>{{ html (index . "protocol") }}{{ if index . "as_path" }}: {{ html (index . "as_path") }}{{ end }}</small></div>
+{{ end }}
+      </td>
+{{ else }}
+      <td colspan="7" class="table-secondary">{{ if .Error }}{{ html .Error }}{{ else }}No route{{ end }}</td>
+{{ end }}
+    </tr>
+{{ end }}
+  </tbody>
+</table>
+{{ end }}
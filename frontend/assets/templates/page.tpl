@@ -52,6 +52,7 @@
 			</li>
 			{{ $length := len .Servers }} 
 			{{ range $k, $v := .Servers }}
+			{{ if not (index $.GroupedServers $v) }}
 			<li class="nav-item">
 				{{ if gt $length 1 }}
 				<a class="nav-link{{ if eq $server $v }} active{{ end }}"
@@ -62,6 +63,22 @@
 				{{ end }}
 			</li>
 			{{ end }}
+			{{ end }}
+			{{ range $group := .ServerGroups }}
+			<li class="nav-item dropdown">
+				<a class="nav-link dropdown-toggle{{ if $group.Active }} active{{ end }}" href="#" role="button"
+					data-toggle="dropdown" aria-haspopup="true" aria-expanded="false">{{ html $group.Name }}</a>
+				<div class="dropdown-menu">
+					<a class="dropdown-item{{ if eq $server $group.URL }} active{{ end }}"
+						href="/{{ $option }}/{{ $group.URL }}/{{ $target }}">All in {{ html $group.Name }}</a>
+					<div class="dropdown-divider"></div>
+					{{ range $k, $v := $group.Servers }}
+					<a class="dropdown-item{{ if eq $server $v }} active{{ end }}"
+						href="/{{ $option }}/{{ $v }}/{{ $target }}">{{ html (index $group.ServersDisplay $k) }}</a>
+					{{ end }}
+				</div>
+			</li>
+			{{ end }}
 		</ul>
 		{{ if .IsWhois }}
 			{{ $target = .WhoisTarget }}
@@ -100,7 +117,7 @@ function goto() {
 
 	if (action == "whois") {
 		url = "/" + action + "/" + target;
-	} else if (action == "summary") {
+	} else if (action == "summary" || action == "status") {
 		url = "/" + action + "/" + server + "/";
 	} else {
 		url = "/" + action + "/" + server + "/" + target;
@@ -0,0 +1,29 @@
+<h2>Status</h2>
+<table class="table table-striped table-bordered table-sm sortable">
+  <thead>
+    <th scope="col">Server</th>
+    <th scope="col">Status</th>
+    <th scope="col">Latency (ms)</th>
+    <th scope="col">BIRD version</th>
+    <th scope="col">Router ID</th>
+    <th scope="col">Traceroute</th>
+    <th scope="col">Ping</th>
+    <th scope="col">Last check</th>
+    <th scope="col">Last error</th>
+  </thead>
+  <tbody>
+{{ range .Rows }}
+    <tr class="table-{{ .MappedState }}">
+      <td><a href="/summary/{{ pathescape .Status.Server }}/">{{ html .ServerName }}</a></td>
+      <td>{{ if not .Status.Reachable }}unreachable{{ else if not .Status.Healthy }}bird down{{ else }}up{{ end }}</td>
+      <td>{{ html .Latency }}</td>
+      <td>{{ html .Status.BirdVersion }}</td>
+      <td>{{ html .Status.RouterID }}</td>
+      <td>{{ if .Status.Traceroute }}yes{{ else if .Status.Reachable }}no{{ end }}</td>
+      <td>{{ if .Status.Ping }}yes{{ else if .Status.Reachable }}no{{ end }}</td>
+      <td>{{ html .CheckedAgo }} ago</td>
+      <td>{{ html .Status.Error }}</td>
+    </tr>
+{{ end }}
+  </tbody>
+</table>
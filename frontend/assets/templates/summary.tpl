@@ -1,9 +1,17 @@
 {{ $ServerName := urlquery .ServerName }}
+{{ $Channels := .Channels }}
+{{ if .Truncated }}
+<div class="alert alert-warning">Output exceeded <code>max_response_size</code>, the last protocols are missing.</div>
+{{ end }}
 
 <table class="table table-striped table-bordered table-sm sortable">
   <thead>
 {{ range .Header }}
     <th scope="col">{{ html . }}</th>
+{{ end }}
+{{ range $Channels }}
+    <th scope="col">{{ if . }}{{ html . }} {{ end }}Imported</th>
+    <th scope="col">{{ if . }}{{ html . }} {{ end }}Exported</th>
 {{ end }}
   </thead>
   <tbody>
@@ -15,6 +23,16 @@
       <td>{{ html .State }}</td>
       <td>{{ html .Since }}</td>
       <td>{{ html .Info  }}</td>
+{{ $Routes := .Routes }}
+{{ range $Channels }}
+{{ with index $Routes . }}
+      <td data-sort="{{ .Imported }}">{{ .Imported }}</td>
+      <td data-sort="{{ .Exported }}">{{ .Exported }}</td>
+{{ else }}
+      <td data-sort="-1"></td>
+      <td data-sort="-1"></td>
+{{ end }}
+{{ end }}
     </tr>
 {{ end }}
   </tbody>
@@ -0,0 +1,35 @@
+<h2>{{ html .ServerName }}: traceroute {{ html .Target }}</h2>{{ if .CacheAge }}
+<p class="text-muted"><small>Cached {{ html .CacheAge }} ago</small></p>{{ end }}
+{{ if .Error }}
+<pre>{{ html .Error }}</pre>
+{{ end }}
+{{ if .Hops }}
+<table class="table table-striped table-bordered table-sm">
+  <thead>
+    <th scope="col">Hop</th>
+    <th scope="col">Host</th>
+    <th scope="col">ASN</th>
+    <th scope="col">Loss</th>
+    <th scope="col">RTT (ms)</th>
+  </thead>
+  <tbody>
+{{ range .Hops }}
+    <tr{{ if not .Responding }} class="table-secondary"{{ end }}>
+      <td>{{ .Hop }}</td>
+      <td>
+      {{ if .Address }}
+        <a href="/whois/{{ pathescape .Address }}" class="whois">{{ html .Address }}</a>{{ if .Hostname }} ({{ html .Hostname }}){{ end }}
+      {{ else if .Hostname }}
+        {{ html .Hostname }}
+      {{ else }}
+        *
+      {{ end }}
+      </td>
+      <td>{{ if .ASN }}<a href="/whois/AS{{ .ASN }}" class="whois">AS{{ .ASN }}</a>{{ end }}</td>
+      <td>{{ printf "%.1f%%" .Loss }}</td>
+      <td>{{ hoprtt . }}</td>
+    </tr>
+{{ end }}
+  </tbody>
+</table>
+{{ end }}
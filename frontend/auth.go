@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Structure of the auth setting in the config file
+type authConfig struct {
+	// Users of HTTP basic auth
+	Users []authUserConfig `mapstructure:"users"`
+	// Static tokens, sent as "Authorization: Bearer <token>"
+	Tokens []authTokenConfig `mapstructure:"tokens"`
+	// Header with the name of the user, set by an authenticating reverse
+	// proxy, e.g. X-Forwarded-User of oauth2-proxy
+	TrustedHeader string `mapstructure:"trusted_header"`
+	// Header with the comma separated groups of the user, the first group
+	// with a role is used, e.g. X-Forwarded-Groups
+	TrustedRoleHeader string `mapstructure:"trusted_role_header"`
+	// Role of users from TrustedHeader without a matching group
+	TrustedRole string `mapstructure:"trusted_role"`
+	// Role of requests without credentials, rejected if empty
+	AnonymousRole string `mapstructure:"anonymous_role"`
+	// Primitives allowed for each role, e.g. summary and traceroute, or "*"
+	// for all
+	Roles map[string][]string `mapstructure:"roles"`
+}
+
+type authUserConfig struct {
+	Name string `mapstructure:"name"`
+	// Plain text, or "sha256:" followed by the hex encoded SHA-256 digest
+	Password string `mapstructure:"password"`
+	Role     string `mapstructure:"role"`
+}
+
+type authTokenConfig struct {
+	// Name of the token in error messages
+	Name string `mapstructure:"name"`
+	// Plain text, or "sha256:" followed by the hex encoded SHA-256 digest
+	Token string `mapstructure:"token"`
+	Role  string `mapstructure:"role"`
+}
+
+// Parsed auth setting, nil if auth is disabled
+type authSettings struct {
+	users             map[string]authCredential
+	tokens            map[[sha256.Size]byte]authIdentity
+	trustedHeader     string
+	trustedRoleHeader string
+	trustedRole       string
+	anonymousRole     string
+	roles             map[string]map[string]bool
+}
+
+type authCredential struct {
+	digest [sha256.Size]byte
+	role   string
+}
+
+// Authenticated client of a request
+type authIdentity struct {
+	Name string
+	Role string
+}
+
+type authContextKey struct{}
+
+// Wildcard of all primitives in roles
+const authAllPrimitives = "*"
+
+// Primitive of each API request type, always allowed if absent
+var apiAuthPrimitives = map[string]string{
+	"summary":         "summary",
+	"detail":          "detail",
+	"route":           "route_all",
+	"compare":         "compare",
+	"bird":            "generic",
+	"traceroute":      "traceroute",
+	"traceroute_hops": "traceroute",
+	"ping":            "ping",
+	"whois":           "whois",
+	"status":          "status",
+}
+
+// Primitives that can be allowed for roles: pages of the frontend, each
+// with the first part of its URL
+func authPrimitives() []string {
+	seen := make(map[string]bool)
+	for primitive := range primitiveMap {
+		seen[primitive] = true
+	}
+	for primitive := range optionsMap {
+		seen[primitive] = true
+	}
+
+	result := make([]string, 0, len(seen))
+	for primitive := range seen {
+		result = append(result, primitive)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Digest of a password or token, from plain text or "sha256:<hex>"
+func parseAuthSecret(secret string) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	if secret == "" {
+		return digest, errors.New("is empty")
+	}
+	hexDigest, hashed := strings.CutPrefix(secret, "sha256:")
+	if !hashed {
+		return sha256.Sum256([]byte(secret)), nil
+	}
+	decoded, err := hex.DecodeString(hexDigest)
+	if err != nil || len(decoded) != sha256.Size {
+		return digest, errors.New("is not a valid SHA-256 digest")
+	}
+	copy(digest[:], decoded)
+	return digest, nil
+}
+
+// Validate the auth setting. Returns nil if no way to authenticate is set,
+// i.e. auth is disabled.
+func parseAuthConfig(config authConfig) (*authSettings, error) {
+	if len(config.Users) == 0 && len(config.Tokens) == 0 && config.TrustedHeader == "" && config.AnonymousRole == "" {
+		if len(config.Roles) > 0 {
+			return nil, errors.New("auth: roles are set, but no users, tokens, trusted_header or anonymous_role")
+		}
+		return nil, nil
+	}
+
+	result := &authSettings{
+		users:             make(map[string]authCredential),
+		tokens:            make(map[[sha256.Size]byte]authIdentity),
+		trustedHeader:     config.TrustedHeader,
+		trustedRoleHeader: config.TrustedRoleHeader,
+		trustedRole:       config.TrustedRole,
+		anonymousRole:     config.AnonymousRole,
+		roles:             make(map[string]map[string]bool),
+	}
+
+	known := make(map[string]bool)
+	for _, primitive := range authPrimitives() {
+		known[primitive] = true
+	}
+	for role, primitives := range config.Roles {
+		result.roles[role] = make(map[string]bool)
+		for _, primitive := range primitives {
+			if primitive != authAllPrimitives && !known[primitive] {
+				return nil, fmt.Errorf("auth: role %s: unknown primitive %s, must be one of %s or %s", role, primitive, strings.Join(authPrimitives(), ", "), authAllPrimitives)
+			}
+			result.roles[role][primitive] = true
+		}
+	}
+
+	// Catch typos in role names, which would deny everything
+	checkRole := func(what string, role string) error {
+		if _, ok := result.roles[role]; !ok {
+			return fmt.Errorf("auth: %s: role %q is not defined in roles", what, role)
+		}
+		return nil
+	}
+
+	for i, user := range config.Users {
+		if user.Name == "" {
+			return nil, fmt.Errorf("auth: user #%d has no name", i+1)
+		}
+		if _, ok := result.users[user.Name]; ok {
+			return nil, fmt.Errorf("auth: user %s is defined more than once", user.Name)
+		}
+		digest, err := parseAuthSecret(user.Password)
+		if err != nil {
+			return nil, fmt.Errorf("auth: password of user %s %w", user.Name, err)
+		}
+		if err := checkRole("user "+user.Name, user.Role); err != nil {
+			return nil, err
+		}
+		result.users[user.Name] = authCredential{digest: digest, role: user.Role}
+	}
+
+	for i, token := range config.Tokens {
+		name := token.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		digest, err := parseAuthSecret(token.Token)
+		if err != nil {
+			return nil, fmt.Errorf("auth: token %s %w", name, err)
+		}
+		if _, ok := result.tokens[digest]; ok {
+			return nil, fmt.Errorf("auth: token %s is defined more than once", name)
+		}
+		if err := checkRole("token "+name, token.Role); err != nil {
+			return nil, err
+		}
+		result.tokens[digest] = authIdentity{Name: name, Role: token.Role}
+	}
+
+	if config.TrustedHeader != "" && config.TrustedRole != "" {
+		if err := checkRole("trusted_role", config.TrustedRole); err != nil {
+			return nil, err
+		}
+	}
+	if config.TrustedHeader == "" && (config.TrustedRoleHeader != "" || config.TrustedRole != "") {
+		return nil, errors.New("auth: trusted_role_header and trusted_role require trusted_header")
+	}
+	if config.AnonymousRole != "" {
+		if err := checkRole("anonymous_role", config.AnonymousRole); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+var errAuthInvalidCredentials = errors.New("invalid credentials")
+
+// Find the identity of the client of a request. Returns false without error
+// if the request has no credentials and anonymous access is disabled.
+func (a *authSettings) authenticate(r *http.Request) (authIdentity, bool, error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		identity, ok := a.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+		if !ok {
+			return authIdentity{}, false, errAuthInvalidCredentials
+		}
+		return identity, true, nil
+	}
+
+	if name, password, ok := r.BasicAuth(); ok {
+		user, found := a.users[name]
+		digest := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(digest[:], user.digest[:]) != 1 || !found {
+			return authIdentity{}, false, errAuthInvalidCredentials
+		}
+		return authIdentity{Name: name, Role: user.role}, true, nil
+	}
+
+	if a.trustedHeader != "" {
+		if name := r.Header.Get(a.trustedHeader); name != "" {
+			identity := authIdentity{Name: name, Role: a.trustedRole}
+			if a.trustedRoleHeader != "" {
+				for _, group := range strings.Split(r.Header.Get(a.trustedRoleHeader), ",") {
+					if _, ok := a.roles[strings.TrimSpace(group)]; ok {
+						identity.Role = strings.TrimSpace(group)
+						break
+					}
+				}
+			}
+			if identity.Role == "" {
+				return authIdentity{}, false, errors.New("user " + name + " has no role")
+			}
+			return identity, true, nil
+		}
+	}
+
+	if a.anonymousRole != "" {
+		return authIdentity{Role: a.anonymousRole}, true, nil
+	}
+	return authIdentity{}, false, nil
+}
+
+// Check if the role may use a primitive
+func (a *authSettings) allowed(role string, primitive string) bool {
+	primitives := a.roles[role]
+	return primitives[authAllPrimitives] || primitives[primitive]
+}
+
+// Check if the client of the request may use a primitive. Everything is
+// allowed if auth is disabled.
+func authAllowed(r *http.Request, primitive string) bool {
+	if setting.auth == nil {
+		return true
+	}
+	identity, ok := r.Context().Value(authContextKey{}).(authIdentity)
+	return ok && setting.auth.allowed(identity.Role, primitive)
+}
+
+// Paths accessible without authentication
+func authExempt(path string) bool {
+	return strings.HasPrefix(path, "/static/") || path == "/robots.txt" || path == "/favicon.ico" ||
+		// Requests of the webhook are checked against the secret token
+		// of Telegram instead
+		(strings.HasPrefix(path, "/telegram/") && setting.telegramSecret != "")
+}
+
+// Auth handler, rejects requests without valid credentials, and requests
+// of primitives not allowed for the role of the client. Checks of API
+// request types are done by the API handler.
+func authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if setting.auth == nil || authExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity, ok, err := setting.auth.authenticate(r)
+		if !ok {
+			if len(setting.auth.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bird-lg", charset="UTF-8"`)
+			}
+			message := "Authentication required"
+			if err != nil {
+				message += ": " + err.Error()
+			}
+			http.Error(w, message, http.StatusUnauthorized)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), authContextKey{}, identity))
+
+		primitive, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		primitive = strings.ToLower(primitive)
+		if _, isPrimitive := optionsMap[primitive]; !isPrimitive {
+			if _, isPrimitive = primitiveMap[primitive]; !isPrimitive {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if !setting.auth.allowed(identity.Role, primitive) {
+			http.Error(w, "Forbidden: "+primitive+" is not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
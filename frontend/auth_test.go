@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func testAuthConfig() authConfig {
+	digest := sha256.Sum256([]byte("secret-token"))
+	return authConfig{
+		Users: []authUserConfig{
+			{Name: "alice", Password: "alice-password", Role: "admin"},
+			{Name: "bob", Password: "bob-password", Role: "viewer"},
+		},
+		Tokens: []authTokenConfig{
+			{Name: "monitoring", Token: "sha256:" + hex.EncodeToString(digest[:]), Role: "viewer"},
+		},
+		TrustedHeader:     "X-Forwarded-User",
+		TrustedRoleHeader: "X-Forwarded-Groups",
+		TrustedRole:       "viewer",
+		Roles: map[string][]string{
+			"admin":  {"*"},
+			"viewer": {"summary", "detail", "traceroute", "whois"},
+		},
+	}
+}
+
+func useAuth(t *testing.T, config authConfig) {
+	auth, err := parseAuthConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting.auth = auth
+	t.Cleanup(func() { setting.auth = nil })
+}
+
+func TestParseAuthConfigDisabled(t *testing.T) {
+	auth, err := parseAuthConfig(authConfig{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, auth == nil, true)
+}
+
+func TestParseAuthConfigErrors(t *testing.T) {
+	tests := map[string]func(c *authConfig){
+		"unknown primitive":   func(c *authConfig) { c.Roles["viewer"] = []string{"rm -rf"} },
+		"undefined role":      func(c *authConfig) { c.Users[0].Role = "admn" },
+		"duplicate user":      func(c *authConfig) { c.Users[1].Name = "alice" },
+		"empty password":      func(c *authConfig) { c.Users[0].Password = "" },
+		"invalid digest":      func(c *authConfig) { c.Tokens[0].Token = "sha256:1234" },
+		"undefined anonymous": func(c *authConfig) { c.AnonymousRole = "guest" },
+		"role header only":    func(c *authConfig) { c.TrustedHeader = "" },
+		"roles only": func(c *authConfig) {
+			*c = authConfig{Roles: c.Roles}
+		},
+	}
+	for name, modify := range tests {
+		config := testAuthConfig()
+		modify(&config)
+		if _, err := parseAuthConfig(config); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	auth, err := parseAuthConfig(testAuthConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authenticate := func(modify func(r *http.Request)) (authIdentity, bool, error) {
+		r := httptest.NewRequest(http.MethodGet, "/summary/alpha", nil)
+		modify(r)
+		return auth.authenticate(r)
+	}
+
+	identity, ok, err := authenticate(func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") })
+	assert.Equal(t, ok, true)
+	assert.Equal(t, identity, authIdentity{Name: "monitoring", Role: "viewer"})
+
+	_, ok, err = authenticate(func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong-token") })
+	assert.Equal(t, ok, false)
+	assert.Equal(t, err, errAuthInvalidCredentials)
+
+	identity, ok, _ = authenticate(func(r *http.Request) { r.SetBasicAuth("alice", "alice-password") })
+	assert.Equal(t, ok, true)
+	assert.Equal(t, identity, authIdentity{Name: "alice", Role: "admin"})
+
+	_, ok, err = authenticate(func(r *http.Request) { r.SetBasicAuth("alice", "bob-password") })
+	assert.Equal(t, ok, false)
+	assert.Equal(t, err, errAuthInvalidCredentials)
+
+	_, ok, err = authenticate(func(r *http.Request) { r.SetBasicAuth("mallory", "") })
+	assert.Equal(t, ok, false)
+	assert.Equal(t, err, errAuthInvalidCredentials)
+
+	// Role from the first group with a role, or trusted_role
+	identity, ok, _ = authenticate(func(r *http.Request) {
+		r.Header.Set("X-Forwarded-User", "carol")
+		r.Header.Set("X-Forwarded-Groups", "staff, admin")
+	})
+	assert.Equal(t, ok, true)
+	assert.Equal(t, identity, authIdentity{Name: "carol", Role: "admin"})
+
+	identity, ok, _ = authenticate(func(r *http.Request) { r.Header.Set("X-Forwarded-User", "dave") })
+	assert.Equal(t, ok, true)
+	assert.Equal(t, identity, authIdentity{Name: "dave", Role: "viewer"})
+
+	// No credentials and no anonymous role
+	_, ok, err = authenticate(func(r *http.Request) {})
+	assert.Equal(t, ok, false)
+	assert.Equal(t, err, nil)
+}
+
+func TestAuthenticateAnonymous(t *testing.T) {
+	config := testAuthConfig()
+	config.AnonymousRole = "viewer"
+	auth, err := parseAuthConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, ok, _ := auth.authenticate(httptest.NewRequest(http.MethodGet, "/summary/alpha", nil))
+	assert.Equal(t, ok, true)
+	assert.Equal(t, identity.Role, "viewer")
+}
+
+func TestAuthHandler(t *testing.T) {
+	useAuth(t, testAuthConfig())
+
+	handler := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	request := func(path string, user string, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("/summary/alpha", "", "")
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+	if !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic") {
+		t.Error("Basic auth should be requested")
+	}
+	assert.Equal(t, request("/summary/alpha", "bob", "wrong").Code, http.StatusUnauthorized)
+
+	assert.Equal(t, request("/summary/alpha", "bob", "bob-password").Code, http.StatusOK)
+	assert.Equal(t, request("/traceroute_table/alpha/1.1.1.1", "bob", "bob-password").Code, http.StatusForbidden)
+	assert.Equal(t, request("/generic/alpha/status", "bob", "bob-password").Code, http.StatusForbidden)
+	assert.Equal(t, request("/generic/alpha/status", "alice", "alice-password").Code, http.StatusOK)
+
+	// Pages without primitives only require authentication
+	assert.Equal(t, request("/", "bob", "bob-password").Code, http.StatusOK)
+	assert.Equal(t, request("/api/", "bob", "bob-password").Code, http.StatusOK)
+
+	// Assets are not protected
+	assert.Equal(t, request("/static/sortTable.js", "", "").Code, http.StatusOK)
+
+	// The Telegram webhook is only exempt if it checks the secret token
+	assert.Equal(t, request("/telegram/", "", "").Code, http.StatusUnauthorized)
+	setting.telegramSecret = "telegram-secret"
+	defer func() { setting.telegramSecret = "" }()
+	assert.Equal(t, request("/telegram/", "", "").Code, http.StatusOK)
+}
+
+func TestAuthHandlerDisabled(t *testing.T) {
+	setting.auth = nil
+	handler := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, authAllowed(r, "generic"), true)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/generic/alpha/status", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+}
+
+func TestAuthAPI(t *testing.T) {
+	setting.servers = []string{"alpha"}
+	useAuth(t, testAuthConfig())
+	handler := authHandler(http.HandlerFunc(apiHandler))
+
+	request := func(body string) (int, apiResponse) {
+		r := httptest.NewRequest(http.MethodPost, "/api/", bytes.NewBufferString(body))
+		r.Header.Set("Authorization", "Bearer secret-token")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var response apiResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, response
+	}
+
+	code, response := request(`{"servers": ["alpha"], "type": "bird", "args": "show status"}`)
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.Equal(t, response.Error, "request type bird is not allowed")
+
+	// Types without primitives are always allowed
+	code, response = request(`{"servers": [], "type": "server_list", "args": ""}`)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, len(response.Result), 1)
+}
+
+func TestAuthPageOptions(t *testing.T) {
+	initSettings()
+	useAuth(t, testAuthConfig())
+
+	var args TemplatePage
+	handler := authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args = pageTemplateArgs(r, "", "")
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/summary/alpha/", nil)
+	r.SetBasicAuth("bob", "bob-password")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.Equal(t, len(args.Options), 4)
+	if _, ok := args.Options["generic"]; ok {
+		t.Error("Options not allowed for the role should be hidden")
+	}
+}
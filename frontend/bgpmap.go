@@ -1,21 +1,10 @@
 package main
 
 import (
-	"regexp"
 	"strings"
-)
-
-// The protocol name for each route (e.g. "ibgp_sea02") is encoded in the form:
-//
-//	unicast [ibgp_sea02 2021-08-27 from fd86:bad:11b7:1::1] * (100/1015) [i]
-var protocolNameRe = regexp.MustCompile(`\[(.*?) .*\]`)
-
-// Try to split the output into one chunk for each route.
-// Possible values are defined at https://gitlab.nic.cz/labs/bird/-/blob/v2.0.8/nest/rt-attr.c#L81-87
-var routeSplitRe = regexp.MustCompile("(unicast|blackhole|unreachable|prohibited)")
 
-var routeViaRe = regexp.MustCompile(`(?m)^\t(via .*?)$`)
-var routeASPathRe = regexp.MustCompile(`(?mi)^\tBGP(?:\.as)?_path: (.*?)$`)
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
 
 func makeEdgeAttrs(preferred bool) RouteAttrs {
 	result := RouteAttrs{
@@ -46,40 +35,26 @@ func birdRouteToGraph(servers []string, responses []string, target string) Route
 			continue
 		}
 		graph.AddPoint(server, false, RouteAttrs{"color": "blue", "shape": "box"})
-		routes := routeSplitRe.Split(response, -1)
-
-		for routeIndex, route := range routes {
-			if routeIndex == 0 {
-				continue
-			}
+		routes := birdparser.ParseRoutes(response)
 
+		for _, route := range routes {
 			var via string
 			var paths []string
-			var routePreferred bool = strings.Contains(route, "*")
+			var routePreferred bool = route.Preferred
 			// Track non-BGP routes in the output by their protocol name, but draw them altogether in one line
 			// so that there are no conflicts in the edge label
-			var protocolName string
+			var protocolName string = route.Protocol
 
-			if match := routeViaRe.FindStringSubmatch(route); len(match) >= 2 {
-				via = strings.TrimSpace(match[1])
+			if len(route.Nexthops) > 0 {
+				via = route.Nexthops[0].String()
 			}
 
-			if match := routeASPathRe.FindStringSubmatch(route); len(match) >= 2 {
-				pathString := strings.TrimSpace(match[1])
-				if len(pathString) > 0 {
-					paths = strings.Split(strings.TrimSpace(match[1]), " ")
-					for i := range paths {
-						paths[i] = strings.TrimPrefix(paths[i], "(")
-						paths[i] = strings.TrimSuffix(paths[i], ")")
-					}
-				}
+			if route.BGP != nil {
+				paths = route.BGP.ASPathStrings()
 			}
 
-			if match := protocolNameRe.FindStringSubmatch(route); len(match) >= 2 {
-				protocolName = strings.TrimSpace(match[1])
-				if routePreferred {
-					protocolName = protocolName + "*"
-				}
+			if routePreferred {
+				protocolName = protocolName + "*"
 			}
 
 			if len(paths) == 0 {
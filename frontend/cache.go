@@ -0,0 +1,407 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Categories of requests with separate cache TTLs
+var cacheCategories = []string{"summary", "route", "bird", "traceroute", "ping", "whois"}
+
+// Category of a request to lgproxy, for looking up its cache TTL
+func cacheCategory(endpoint string, command string) string {
+	if endpoint == "bird" {
+		if command == "show protocols" || command == "show protocols all" {
+			return "summary"
+		}
+		if strings.HasPrefix(command, "show route") {
+			return "route"
+		}
+	}
+	return endpoint
+}
+
+// How long results of the request are cached, 0 if not cached
+func cacheTTL(endpoint string, command string) time.Duration {
+	return setting.cacheTTL[cacheCategory(endpoint, command)]
+}
+
+// Parse cache TTLs of each category, in the form of Go durations, e.g. 10s
+func parseCacheTTL(ttls map[string]string) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration)
+	for category, ttl := range ttls {
+		valid := false
+		for _, known := range cacheCategories {
+			if category == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown cache category %s, must be one of %s", category, strings.Join(cacheCategories, ", "))
+		}
+
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache TTL of %s: %w", category, err)
+		}
+		result[category] = duration
+	}
+	return result, nil
+}
+
+// Age of a cached result shown on pages, empty for fresh results
+func cacheAgeText(cachedAt time.Time) string {
+	if cachedAt.IsZero() {
+		return ""
+	}
+	return time.Since(cachedAt).Round(time.Second).String()
+}
+
+// Age of a cached result in seconds for API responses, 0 for fresh results
+func cacheAgeSeconds(cachedAt time.Time) float64 {
+	if cachedAt.IsZero() {
+		return 0
+	}
+	return time.Since(cachedAt).Round(time.Millisecond).Seconds()
+}
+
+// The earliest time in cachedAt, ignoring fresh results
+func oldestCachedAt(cachedAt []time.Time) time.Time {
+	var oldest time.Time
+	for _, t := range cachedAt {
+		if !t.IsZero() && (oldest.IsZero() || t.Before(oldest)) {
+			oldest = t
+		}
+	}
+	return oldest
+}
+
+// Key of a request to lgproxy in the cache
+func cacheKey(server string, endpoint string, command string, format string) string {
+	return strings.Join([]string{server, endpoint, format, command}, "\x00")
+}
+
+type cacheEntry struct {
+	key      string
+	value    string
+	storedAt time.Time
+	expires  time.Time
+}
+
+// An in-memory cache of results, evicting least recently used entries when
+// full. Identical requests in flight are coalesced into one.
+type resultCache struct {
+	lock sync.Mutex
+	// Max number of entries, unlimited if 0 or less
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+	inflight   map[string]*inflightRequest
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+func newResultCache(maxEntries int) *resultCache {
+	return &resultCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		inflight:   make(map[string]*inflightRequest),
+		now:        time.Now,
+	}
+}
+
+var requestCache = newResultCache(0)
+
+// Get a result and the time it was stored, if it exists and hasn't expired.
+// Must be called with lock held.
+func (c *resultCache) getLocked(key string) (string, time.Time, bool) {
+	element, ok := c.entries[key]
+	if !ok {
+		return "", time.Time{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return "", time.Time{}, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.value, entry.storedAt, true
+}
+
+// Store a result, evicting the least recently used entry if the cache is full.
+// Must be called with lock held.
+func (c *resultCache) setLocked(key string, value string, ttl time.Duration) {
+	now := c.now()
+	if element, ok := c.entries[key]; ok {
+		c.lru.Remove(element)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:      key,
+		value:    value,
+		storedAt: now,
+		expires:  now.Add(ttl),
+	})
+
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *resultCache) get(key string) (string, time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.getLocked(key)
+}
+
+func (c *resultCache) set(key string, value string, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setLocked(key, value, ttl)
+}
+
+// Number of results in the cache, including expired ones not yet removed
+func (c *resultCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.lru.Len()
+}
+
+// Stream the result of key to ch line by line, and close ch afterwards.
+// category is only used to label metrics.
+// The result is served from the cache if present, or else shared with an
+// identical request in flight, or else fetched with fetch and cached for ttl
+// if fetch succeeds. Returns the time the result was cached, or zero time if
+// the result is fresh. If err is not nil, it's set to the error of fetch
+// before ch is closed, or of ctx if cancelled while waiting for fetch.
+//
+// fetch must close its channel when done. It runs with its own context, so a
+// result requested by multiple clients is complete even if the first one
+// disconnects, and is cancelled once all of them have disconnected. Timeouts
+// of the request still apply.
+func (c *resultCache) stream(ctx context.Context, category string, key string, ttl time.Duration, ch chan<- string, err *error, fetch func(ctx context.Context, ch chan<- string) error) time.Time {
+	c.lock.Lock()
+	if value, storedAt, ok := c.getLocked(key); ok {
+		c.lock.Unlock()
+		metricCacheRequests.WithLabelValues(category, "hit").Inc()
+		go sendLines(ctx, strings.SplitAfter(value, "\n"), ch)
+		return storedAt
+	}
+	if request, ok := c.inflight[key]; ok {
+		// Joined with the lock held, so the request can't be abandoned
+		// before the client subscribes
+		request.join()
+		c.lock.Unlock()
+		metricCacheRequests.WithLabelValues(category, "coalesced").Inc()
+		go request.subscribe(ctx, ch, err)
+		return time.Time{}
+	}
+	fetchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	request := newInflightRequest()
+	request.abandon = func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		// Another client may have joined in the meantime
+		if !request.abandoned() {
+			return
+		}
+		// Later requests start over instead of joining the cancelled one
+		if c.inflight[key] == request {
+			delete(c.inflight, key)
+		}
+		cancel()
+	}
+	request.join()
+	c.inflight[key] = request
+	c.lock.Unlock()
+	metricCacheRequests.WithLabelValues(category, "miss").Inc()
+
+	go func() {
+		defer cancel()
+		lines := make(chan string, streamBufferLines)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- fetch(fetchCtx, lines)
+		}()
+
+		var builder strings.Builder
+		for line := range lines {
+			builder.WriteString(line)
+			request.append(line)
+		}
+		fetchErr := <-errCh
+
+		// Store the result before completing the request, so later requests
+		// either join this one or hit the cache
+		c.lock.Lock()
+		if c.inflight[key] == request {
+			delete(c.inflight, key)
+		}
+		if fetchErr == nil && fetchCtx.Err() == nil {
+			c.setLocked(key, builder.String(), ttl)
+		}
+		c.lock.Unlock()
+		request.finish(fetchErr)
+	}()
+
+	go request.subscribe(ctx, ch, err)
+	return time.Time{}
+}
+
+// Get the result of key from the cache, or else call fetch and cache its
+// result for ttl if it succeeds. Identical calls in flight are coalesced.
+// Returns the time the result was cached, or zero time if it is fresh.
+func (c *resultCache) getOrFetch(category string, key string, ttl time.Duration, fetch func() (string, error)) (string, time.Time) {
+	ch := make(chan string, streamBufferLines)
+	cachedAt := c.stream(context.Background(), category, key, ttl, ch, nil, func(ctx context.Context, ch chan<- string) error {
+		defer close(ch)
+		result, err := fetch()
+		ch <- result
+		return err
+	})
+	var builder strings.Builder
+	for line := range ch {
+		builder.WriteString(line)
+	}
+	return builder.String(), cachedAt
+}
+
+// Send lines to ch until done or ctx is cancelled, and close ch
+func sendLines(ctx context.Context, lines []string, ch chan<- string) {
+	defer close(ch)
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		select {
+		case ch <- line:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// A request shared by all clients asking for the same result. Lines are
+// kept so clients joining later also get the complete result.
+type inflightRequest struct {
+	lock  sync.Mutex
+	lines []string
+	done  bool
+	// Error of the request once done
+	err error
+	// Closed and replaced whenever lines or done changes
+	updated chan struct{}
+	// Number of clients waiting for the result
+	subscribers int
+	// Cancels the request, called when all clients have left before the
+	// request is complete
+	abandon func()
+}
+
+func newInflightRequest() *inflightRequest {
+	return &inflightRequest{updated: make(chan struct{})}
+}
+
+func (r *inflightRequest) notifyLocked() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+func (r *inflightRequest) append(line string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lines = append(r.lines, line)
+	r.notifyLocked()
+}
+
+// Add a client waiting for the result
+func (r *inflightRequest) join() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.subscribers++
+}
+
+// Remove a client, returns true if it was the last one and the request is
+// not complete yet
+func (r *inflightRequest) leave() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.subscribers--
+	return r.subscribers == 0 && !r.done
+}
+
+// Check if all clients have left before the request is complete
+func (r *inflightRequest) abandoned() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.subscribers == 0 && !r.done
+}
+
+func (r *inflightRequest) finish(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.done = true
+	r.err = err
+	r.notifyLocked()
+}
+
+// Send lines of the request to ch as they arrive, until the request is
+// complete or ctx is cancelled, and close ch. If err is not nil, it's set to
+// the error of the request, or of ctx, before ch is closed. The client must
+// have joined the request before.
+func (r *inflightRequest) subscribe(ctx context.Context, ch chan<- string, err *error) {
+	defer close(ch)
+	defer func() {
+		if r.leave() && r.abandon != nil {
+			r.abandon()
+		}
+	}()
+	setErr := func(e error) {
+		if err != nil {
+			*err = e
+		}
+	}
+	sent := 0
+	for {
+		r.lock.Lock()
+		lines := r.lines[sent:]
+		done := r.done
+		requestErr := r.err
+		updated := r.updated
+		r.lock.Unlock()
+
+		for _, line := range lines {
+			select {
+			case ch <- line:
+			case <-ctx.Done():
+				setErr(ctx.Err())
+				return
+			}
+		}
+		sent += len(lines)
+		// All lines are sent if the request was complete
+		if done {
+			setErr(requestErr)
+			return
+		}
+		if len(lines) > 0 {
+			continue
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+			return
+		}
+	}
+}
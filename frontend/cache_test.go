@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+)
+
+// Enable caching with the TTLs and an empty cache, until the test ends
+func useCache(t *testing.T, ttls map[string]time.Duration) {
+	setting.cacheTTL = ttls
+	requestCache = newResultCache(0)
+	t.Cleanup(func() {
+		setting.cacheTTL = nil
+		requestCache = newResultCache(0)
+	})
+}
+
+func TestCacheCategory(t *testing.T) {
+	assert.Equal(t, cacheCategory("bird", "show protocols"), "summary")
+	assert.Equal(t, cacheCategory("bird", "show route for 1.1.1.1"), "route")
+	assert.Equal(t, cacheCategory("bird", "show status"), "bird")
+	assert.Equal(t, cacheCategory("traceroute", "1.1.1.1"), "traceroute")
+}
+
+func TestParseCacheTTL(t *testing.T) {
+	ttls, err := parseCacheTTL(map[string]string{"summary": "10s", "whois": "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ttls["summary"], 10*time.Second)
+	assert.Equal(t, ttls["whois"], time.Hour)
+	assert.Equal(t, ttls["route"], time.Duration(0))
+}
+
+func TestParseCacheTTLInvalid(t *testing.T) {
+	for _, ttls := range []map[string]string{
+		{"unknown": "10s"},
+		{"summary": "10"},
+		{"summary": "abc"},
+	} {
+		if _, err := parseCacheTTL(ttls); err == nil {
+			t.Errorf("Should fail to parse %v", ttls)
+		}
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := newResultCache(0)
+	cache.now = func() time.Time { return now }
+
+	cache.set("key", "value", 10*time.Second)
+	value, storedAt, ok := cache.get("key")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, value, "value")
+	assert.Equal(t, storedAt, now)
+
+	now = now.Add(10 * time.Second)
+	_, _, ok = cache.get("key")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, cache.len(), 0)
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	cache := newResultCache(2)
+	cache.set("a", "1", time.Minute)
+	cache.set("b", "2", time.Minute)
+	// "a" becomes the most recently used
+	cache.get("a")
+	cache.set("c", "3", time.Minute)
+
+	assert.Equal(t, cache.len(), 2)
+	_, _, ok := cache.get("a")
+	assert.Equal(t, ok, true)
+	_, _, ok = cache.get("b")
+	assert.Equal(t, ok, false)
+	_, _, ok = cache.get("c")
+	assert.Equal(t, ok, true)
+}
+
+func collectLines(ch <-chan string) string {
+	var builder strings.Builder
+	for line := range ch {
+		builder.WriteString(line)
+	}
+	return builder.String()
+}
+
+func TestResultCacheStreamAbandoned(t *testing.T) {
+	c := newResultCache(0)
+	cancelled := make(chan struct{})
+	fetch := func(ctx context.Context, ch chan<- string) error {
+		defer close(ch)
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan string, streamBufferLines)
+	c.stream(ctx, "route", "key", time.Minute, ch, nil, fetch)
+	cancel()
+	collectLines(ch)
+
+	// The fetch is cancelled once the only client has left
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("Fetch not cancelled after all clients left")
+	}
+
+	// Later requests start over
+	ch = make(chan string, streamBufferLines)
+	c.stream(context.Background(), "route", "key", time.Minute, ch, nil, func(ctx context.Context, ch chan<- string) error {
+		defer close(ch)
+		ch <- "Mock Response\n"
+		return nil
+	})
+	assert.Equal(t, collectLines(ch), "Mock Response\n")
+	assert.Equal(t, c.len(), 1)
+}
+
+func TestResultCacheStreamClientLeaves(t *testing.T) {
+	c := newResultCache(0)
+	release := make(chan struct{})
+	fetch := func(ctx context.Context, ch chan<- string) error {
+		defer close(ch)
+		select {
+		case <-release:
+			ch <- "Mock Response\n"
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan string, streamBufferLines)
+	c.stream(ctx, "route", "key", time.Minute, first, nil, fetch)
+	second := make(chan string, streamBufferLines)
+	c.stream(context.Background(), "route", "key", time.Minute, second, nil, fetch)
+
+	// The fetch continues for the remaining client
+	cancel()
+	collectLines(first)
+	close(release)
+	assert.Equal(t, collectLines(second), "Mock Response\n")
+	assert.Equal(t, c.len(), 1)
+}
+
+func TestBatchRequestCached(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Response\n")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"), httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"summary": time.Minute})
+
+	results, cachedAt, _ := batchRequestCached(context.Background(), setting.servers, "bird", "show protocols", "")
+	assert.Equal(t, results[0], "Mock Response\n")
+	assert.Equal(t, cachedAt[0].IsZero(), true)
+
+	results, cachedAt, _ = batchRequestCached(context.Background(), setting.servers, "bird", "show protocols", "")
+	assert.Equal(t, results[0], "Mock Response\n")
+	assert.Equal(t, cachedAt[0].IsZero(), false)
+	assert.Equal(t, httpmock.GetTotalCallCount(), 1)
+}
+
+func TestBatchRequestCachedErrors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"), httpmock.NewErrorResponder(errors.New("connection refused")))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+url.QueryEscape("show protocols"), httpmock.NewStringResponder(500, "Mock backend error\n"))
+	httpmock.RegisterResponder("GET", "http://gamma:8000/bird?q="+url.QueryEscape("show protocols"), httpmock.NewStringResponder(200, "Mock Response\n"))
+
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	// Errors are the same whether served through the cache or not
+	for _, ttl := range []time.Duration{0, time.Minute} {
+		useCache(t, map[string]time.Duration{"summary": ttl})
+		_, _, errs := batchRequestCached(context.Background(), setting.servers, "bird", "show protocols", "")
+		assert.Equal(t, errors.Is(errs[0], errProxyUnavailable), true)
+		assert.Equal(t, errs[1] != nil && !errors.Is(errs[1], errProxyUnavailable), true)
+		assert.Equal(t, errs[2], nil)
+	}
+}
+
+func TestBatchRequestNotCached(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Response\n")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show status"), httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"summary": time.Minute})
+
+	for i := 0; i < 2; i++ {
+		_, cachedAt, _ := batchRequestCached(context.Background(), setting.servers, "bird", "show status", "")
+		assert.Equal(t, cachedAt[0].IsZero(), true)
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 2)
+}
+
+func TestBatchRequestCachedFailure(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"), httpmock.NewErrorResponder(errors.New("connection refused")))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route"), httpmock.NewStringResponder(500, "error\n"))
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"summary": time.Minute, "route": time.Minute})
+
+	for _, command := range []string{"show protocols", "show route"} {
+		for i := 0; i < 2; i++ {
+			_, cachedAt, _ := batchRequestCached(context.Background(), setting.servers, "bird", command, "")
+			assert.Equal(t, cachedAt[0].IsZero(), true)
+		}
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 4)
+	assert.Equal(t, requestCache.len(), 0)
+}
+
+func TestBatchRequestCoalesced(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	release := make(chan struct{})
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"), func(r *http.Request) (*http.Response, error) {
+		<-release
+		return httpmock.NewStringResponse(200, "Mock Response\n"), nil
+	})
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"summary": time.Minute})
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = batchRequest(context.Background(), setting.servers, "bird", "show protocols")[0]
+		}(i)
+	}
+	// Let all requests start before the response arrives
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for _, result := range results {
+		assert.Equal(t, result, "Mock Response\n")
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 1)
+}
+
+func TestWebBackendCommunicatorCacheAge(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Table master4:\n")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 1.1.1.1"), httpResponse)
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"route": time.Hour})
+	requestCache.now = func() time.Time { return time.Now().Add(-time.Minute) }
+
+	handler := webBackendCommunicator("bird", "route")
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/route/alpha/1.1.1.1", nil)
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		cached := strings.Contains(w.Body.String(), "Cached 1m0s ago")
+		assert.Equal(t, cached, i == 1)
+		if !strings.Contains(w.Body.String(), "<pre>Table master4:\n") {
+			t.Error("Result not rendered")
+		}
+	}
+}
+
+func TestApiGenericHandlerCacheAge(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Response")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"ping": time.Hour})
+	requestCache.now = func() time.Time { return time.Now().Add(-time.Minute) }
+
+	request := apiRequest{
+		Servers: setting.servers,
+		Type:    "ping",
+		Args:    "1.1.1.1",
+	}
+
+	response := apiHandlerMap["ping"](context.Background(), request)
+	assert.Equal(t, response.Result[0].(*apiGenericResultPair).CacheAge, float64(0))
+
+	response = apiHandlerMap["ping"](context.Background(), request)
+	result := response.Result[0].(*apiGenericResultPair)
+	assert.Equal(t, result.Data, "Mock Response")
+	if result.CacheAge < 59 || result.CacheAge > 61 {
+		t.Errorf("Cache age should be about 60s, got %f", result.CacheAge)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
+
+// Fields of the best routes compared between servers, in the order they're
+// shown. Nexthops and protocols are local to each server, so they're shown
+// but never compared.
+var compareFields = []string{"prefix", "as_path", "local_pref", "communities"}
+
+// Routes of a server to the compared prefix
+type compareResult struct {
+	// Nil if the server has no route to the prefix
+	Best       *birdparser.Route
+	Alternates []birdparser.Route
+	// Compared fields of the best route that don't match the majority
+	Differs []string
+	Error   string
+
+	// Time the result was cached, zero if fresh
+	cachedAt time.Time
+}
+
+// Values of the fields of a route, as shown in the comparison
+func compareValues(route *birdparser.Route) map[string]string {
+	var nexthops []string
+	for _, nexthop := range route.Nexthops {
+		nexthops = append(nexthops, nexthop.String())
+	}
+	if len(nexthops) == 0 && route.Interface != "" {
+		nexthops = append(nexthops, "dev "+route.Interface)
+	}
+
+	values := map[string]string{
+		"prefix":   route.Prefix,
+		"protocol": route.Protocol,
+		"nexthop":  strings.Join(nexthops, ", "),
+	}
+	if route.BGP != nil {
+		values["as_path"] = strings.Join(route.BGP.ASPathStrings(), " ")
+		if route.BGP.LocalPref != nil {
+			values["local_pref"] = strconv.FormatUint(uint64(*route.BGP.LocalPref), 10)
+		}
+
+		var communities []string
+		for _, community := range route.BGP.Communities {
+			communities = append(communities, community.String())
+		}
+		for _, community := range route.BGP.LargeCommunities {
+			communities = append(communities, community.String())
+		}
+		values["communities"] = strings.Join(communities, " ")
+	}
+	return values
+}
+
+// Split routes into the preferred route, or the first one if none is
+// preferred, and the alternates
+func compareBestRoute(routes []birdparser.Route) (*birdparser.Route, []birdparser.Route) {
+	if len(routes) == 0 {
+		return nil, []birdparser.Route{}
+	}
+	best := 0
+	for i, route := range routes {
+		if route.Preferred {
+			best = i
+			break
+		}
+	}
+
+	alternates := make([]birdparser.Route, 0, len(routes)-1)
+	alternates = append(alternates, routes[:best]...)
+	alternates = append(alternates, routes[best+1:]...)
+	return &routes[best], alternates
+}
+
+// Mark fields of the best routes that differ from the value shared by most
+// servers. If no value is shared by most servers, all of them differ.
+func compareDiffers(results []compareResult) {
+	values := make([]map[string]string, len(results))
+	for i, result := range results {
+		if result.Best != nil {
+			values[i] = compareValues(result.Best)
+		}
+	}
+
+	for _, field := range compareFields {
+		counts := make(map[string]int)
+		for _, value := range values {
+			if value != nil {
+				counts[value[field]]++
+			}
+		}
+
+		majority, majorityCount, tied := "", 0, false
+		for value, count := range counts {
+			if count > majorityCount {
+				majority, majorityCount, tied = value, count, false
+			} else if count == majorityCount {
+				tied = true
+			}
+		}
+
+		for i, value := range values {
+			if value != nil && (tied || value[field] != majority) {
+				results[i].Differs = append(results[i].Differs, field)
+			}
+		}
+	}
+}
+
+// Query routes to the prefix on servers, and compare the best routes
+func compareRequest(ctx context.Context, servers []string, prefix string) []compareResult {
+	responses, cachedAt, _ := batchRequestCached(ctx, servers, "bird", fmt.Sprintf(primitiveMap["route_all"], prefix), "")
+
+	results := make([]compareResult, len(responses))
+	for i, response := range responses {
+		routes := birdparser.ParseRoutes(response)
+		results[i].Best, results[i].Alternates = compareBestRoute(routes)
+		results[i].cachedAt = cachedAt[i]
+		if len(routes) == 0 {
+			// Likely backend returned an error message, or no route exists
+			results[i].Error = strings.TrimSpace(response)
+		}
+	}
+	compareDiffers(results)
+	return results
+}
+
+// Comparison of routes to a prefix on servers, as a table
+func webHandlerCompare(w http.ResponseWriter, r *http.Request) {
+	split := strings.SplitN(r.URL.Path[1:], "/", 3)
+	var target string
+	if len(split) >= 3 {
+		target = strings.TrimSpace(split[2])
+	}
+
+	servers := parseServersParam(split[1])
+	if rateLimited(w, r, "bird", len(servers)) {
+		return
+	}
+
+	args := TemplateCompare{Target: target}
+	if !routeTargetValid(target) {
+		args.Error = "Target must be an IP address or prefix, e.g. 192.0.2.0/24"
+	} else {
+		results := compareRequest(r.Context(), servers, target)
+		cachedAt := make([]time.Time, len(results))
+		for i, result := range results {
+			row := TemplateCompareRow{
+				ServerName: serverDisplayName(servers, i),
+				Server:     servers[i],
+				Differs:    make(map[string]bool),
+				Error:      result.Error,
+			}
+			if result.Best != nil {
+				row.Values = compareValues(result.Best)
+			}
+			for j := range result.Alternates {
+				row.Alternates = append(row.Alternates, compareValues(&result.Alternates[j]))
+			}
+			for _, field := range result.Differs {
+				row.Differs[field] = true
+			}
+			args.Rows = append(args.Rows, row)
+			cachedAt[i] = result.cachedAt
+		}
+		args.CacheAge = cacheAgeText(oldestCachedAt(cachedAt))
+	}
+
+	tmpl := TemplateLibrary["compare"]
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, args)
+	if err != nil {
+		fmt.Println("Error rendering compare template:", err.Error())
+	}
+
+	renderPageTemplate(
+		w, r,
+		" - compare "+target,
+		template.HTML(buffer.String()),
+	)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
+
+// Same best route as route_bgp_bird2.txt, except for the local_pref
+const compareRouteLocalPref = `Table master4:
+172.20.0.53/32       unicast [ibgp_sjc2 2023-04-29 from fd86:bad:11b7:22::1] * (100/38) [AS4242423914i]
+	via 169.254.108.123 on igp-sjc2
+	Type: BGP univ
+	BGP.origin: IGP
+	BGP.as_path: 4242423914
+	BGP.next_hop: 172.20.229.122
+	BGP.local_pref: 200
+	BGP.community: (64511,1) (64511,24) (64511,34)
+	BGP.large_community: (4242421080, 101, 44) (4242421080, 103, 122) (4242421080, 104, 1)
+`
+
+func compareMockServers(t *testing.T) {
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	command := url.QueryEscape("show route for 172.20.0.53 all")
+	bird2 := readDataFile(t, "lib/birdparser/testdata/route_bgp_bird2.txt")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+command, httpmock.NewStringResponder(200, bird2))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+command, httpmock.NewStringResponder(200, bird2))
+	httpmock.RegisterResponder("GET", "http://gamma:8000/bird?q="+command, httpmock.NewStringResponder(200, compareRouteLocalPref))
+	httpmock.RegisterResponder("GET", "http://delta:8000/bird?q="+command, httpmock.NewStringResponder(200, "Network not found\n"))
+
+	initSettings()
+	setting.servers = []string{"alpha", "beta", "gamma", "delta"}
+	setting.serversDisplay = setting.servers
+	setting.domain = ""
+	setting.proxyPort = 8000
+}
+
+func TestCompareBestRoute(t *testing.T) {
+	routes := []birdparser.Route{{Protocol: "a"}, {Protocol: "b", Preferred: true}, {Protocol: "c"}}
+	best, alternates := compareBestRoute(routes)
+	assert.Equal(t, best.Protocol, "b")
+	assert.Equal(t, alternates, []birdparser.Route{{Protocol: "a"}, {Protocol: "c"}})
+
+	// The first route is used if none is preferred
+	best, alternates = compareBestRoute(routes[2:])
+	assert.Equal(t, best.Protocol, "c")
+	assert.Equal(t, len(alternates), 0)
+
+	best, alternates = compareBestRoute(nil)
+	assert.Equal(t, best == nil, true)
+	assert.Equal(t, len(alternates), 0)
+}
+
+func TestCompareDiffers(t *testing.T) {
+	route := func(prefix string) *birdparser.Route {
+		return &birdparser.Route{Prefix: prefix}
+	}
+
+	results := []compareResult{
+		{Best: route("192.0.2.0/24")},
+		{Best: route("192.0.2.0/24")},
+		{Best: route("192.0.2.0/25")},
+		{},
+	}
+	compareDiffers(results)
+	assert.Equal(t, len(results[0].Differs), 0)
+	assert.Equal(t, len(results[1].Differs), 0)
+	assert.Equal(t, results[2].Differs, []string{"prefix"})
+	// Servers without routes are not compared
+	assert.Equal(t, len(results[3].Differs), 0)
+
+	// Without a majority, all values differ
+	results = []compareResult{
+		{Best: route("192.0.2.0/24")},
+		{Best: route("192.0.2.0/25")},
+	}
+	compareDiffers(results)
+	assert.Equal(t, results[0].Differs, []string{"prefix"})
+	assert.Equal(t, results[1].Differs, []string{"prefix"})
+}
+
+func TestCompareValues(t *testing.T) {
+	routes := birdparser.ParseRoutes(readDataFile(t, "lib/birdparser/testdata/route_bgp_bird2.txt"))
+	values := compareValues(&routes[0])
+	assert.Equal(t, values["prefix"], "172.20.0.53/32")
+	assert.Equal(t, values["protocol"], "ibgp_sjc2")
+	assert.Equal(t, values["nexthop"], "via 169.254.108.122 on igp-sjc2")
+	assert.Equal(t, values["as_path"], "4242423914")
+	assert.Equal(t, values["local_pref"], "100")
+	assert.Equal(t, values["communities"], "(64511,1) (64511,24) (64511,34) (4242421080, 101, 44) (4242421080, 103, 122) (4242421080, 104, 1)")
+}
+
+func TestApiCompareHandler(t *testing.T) {
+	compareMockServers(t)
+
+	response := apiCompareHandler(context.Background(), apiRequest{
+		Servers: setting.servers,
+		Type:    "compare",
+		Args:    "172.20.0.53",
+	})
+	assert.Equal(t, response.Error, "")
+	assert.Equal(t, len(response.Result), 4)
+
+	alpha := response.Result[0].(*apiCompareResultPair)
+	assert.Equal(t, alpha.Server, "alpha")
+	assert.Equal(t, alpha.Best.Protocol, "ibgp_sjc2")
+	assert.Equal(t, len(alpha.Alternates), 2)
+	assert.Equal(t, len(alpha.Differs), 0)
+
+	gamma := response.Result[2].(*apiCompareResultPair)
+	assert.Equal(t, gamma.Differs, []string{"local_pref"})
+
+	delta := response.Result[3].(*apiCompareResultPair)
+	assert.Equal(t, delta.Best == nil, true)
+	assert.Equal(t, delta.Error, "Network not found")
+}
+
+func TestApiCompareHandlerInvalid(t *testing.T) {
+	response := apiCompareHandler(context.Background(), apiRequest{
+		Servers: []string{"alpha"},
+		Type:    "compare",
+		Args:    "172.20.0.53 protocol static1",
+	})
+	assert.Equal(t, response.Error, "prefix must be an IP address or prefix")
+}
+
+func TestWebHandlerCompare(t *testing.T) {
+	compareMockServers(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/compare/alpha+beta+gamma+delta/172.20.0.53", nil)
+	w := httptest.NewRecorder()
+	webHandlerCompare(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	body := w.Body.String()
+	if !strings.Contains(body, "<h2>Compare routes to 172.20.0.53</h2>") {
+		t.Error("Compare header not rendered")
+	}
+	if !strings.Contains(body, `<td class="table-warning">200</td>`) {
+		t.Error("Different local_pref not highlighted")
+	}
+	if strings.Count(body, "table-warning") != 1 {
+		t.Error("Only the different local_pref should be highlighted")
+	}
+	if !strings.Contains(body, "miaotony_2688: 4242422688 4242423914") {
+		t.Error("Alternate routes not rendered")
+	}
+	if !strings.Contains(body, "Network not found") {
+		t.Error("Servers without routes not rendered")
+	}
+}
+
+func TestWebHandlerCompareInvalid(t *testing.T) {
+	compareMockServers(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/compare/alpha/"+url.PathEscape("172.20.0.53 protocol static1"), nil)
+	w := httptest.NewRecorder()
+	webHandlerCompare(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "Target must be an IP address or prefix") {
+		t.Error("Invalid target not reported")
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 0)
+}
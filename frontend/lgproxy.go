@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -10,15 +14,13 @@ import (
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
 )
 
-type channelData struct {
-	id   int
-	data string
-}
-
-func createConnectionTimeoutRoundTripper(timeout int) http.RoundTripper {
-	context := net.Dialer{
+// Create a transport with connection timeout. If socketPath is set, all
+// connections are made to the unix socket instead.
+func createConnectionTimeoutRoundTripper(timeout int, socketPath string) http.RoundTripper {
+	dialer := net.Dialer{
 		Timeout: time.Duration(timeout) * time.Second,
 		Control: vrfControl(setting.vrf),
 	}
@@ -28,8 +30,16 @@ func createConnectionTimeoutRoundTripper(timeout int) http.RoundTripper {
 		return httpmock.DefaultTransport
 	}
 
+	dialContext := dialer.DialContext
+	if socketPath != "" {
+		dialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+
 	return &http.Transport{
-		DialContext: context.DialContext,
+		DialContext:     dialContext,
+		TLSClientConfig: setting.proxyTLSConfig,
 
 		// Default options from transport.go
 		Proxy:                 http.ProxyFromEnvironment,
@@ -41,72 +51,258 @@ func createConnectionTimeoutRoundTripper(timeout int) http.RoundTripper {
 	}
 }
 
+// Default limit of output size from each backend
+const defaultMaxResponseSize = 65536
+
+// Size of the buffer for lines received from a backend but not yet consumed
+const streamBufferLines = 256
+
+func maxResponseSize() int {
+	if setting.maxResponseSize <= 0 {
+		return defaultMaxResponseSize
+	}
+	return setting.maxResponseSize
+}
+
+const truncatedNoticePrefix = "... output truncated, exceeded the limit of "
+
+// Notice appended to the output when the backend returns more than allowed
+func truncatedNotice(limit int) string {
+	return "\n" + truncatedNoticePrefix + strconv.Itoa(limit) + " bytes ...\n"
+}
+
+// Check if the output of a backend has been cut off at the size limit
+func outputTruncated(output string) bool {
+	return strings.Contains(output, truncatedNoticePrefix)
+}
+
+// Compose URL of lgproxy endpoint of a server.
+// Format is the output format requested from lgproxy, empty for plain text.
+func proxyURL(server string, endpoint string, command string, format string) string {
+	result := proxyBaseURL(server) + "/" + url.PathEscape(endpoint) + "?q=" + url.QueryEscape(command)
+	if format != "" {
+		result += "&format=" + url.QueryEscape(format)
+	}
+	return result
+}
+
+// Compose base URL of lgproxy on a server, from the url of the server if set,
+// or from its name, domain and port otherwise
+func proxyBaseURL(server string) string {
+	config := serverConfigOf(server)
+	if config != nil && config.baseURL != "" {
+		return config.baseURL
+	}
+
+	hostname := server
+	hostname = url.PathEscape(hostname)
+	if strings.Contains(hostname, ":") {
+		hostname = "[" + hostname + "]"
+	}
+	if setting.domain != "" {
+		hostname += "." + setting.domain
+	}
+	scheme := "http"
+	if proxyUsesTLS(server) {
+		scheme = "https"
+	}
+	port := setting.proxyPort
+	if config != nil && config.Port != 0 {
+		port = config.Port
+	}
+	return scheme + "://" + hostname + ":" + strconv.Itoa(port)
+}
+
+// Check if the server is in the valid server list passed at startup
+func isValidServer(server string) bool {
+	for _, validServer := range setting.servers {
+		if validServer == server {
+			return true
+		}
+	}
+	return false
+}
+
+// Send a GET request to lgproxy on a server, signed if a secret is set
+func proxyGet(ctx context.Context, server string, url string) (*http.Response, error) {
+	socketPath := ""
+	if config := serverConfigOf(server); config != nil {
+		socketPath = config.socketPath
+	}
+	client := http.Client{
+		Transport: createConnectionTimeoutRoundTripper(setting.connectionTimeOut, socketPath),
+		Timeout:   time.Duration(setting.timeOut) * time.Second,
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if secret := serverSecret(server); secret != "" {
+		if err := reqsign.Sign(request, []byte(secret)); err != nil {
+			return nil, err
+		}
+	}
+	return client.Do(request)
+}
+
+// Errors of requests to lgproxy that didn't get a response from bird, or
+// traceroute and ping
+var (
+	errInvalidServer    = errors.New("invalid server")
+	errEndpointDisabled = errors.New("endpoint not enabled on this server")
+	errProxyUnavailable = errors.New("lgproxy unavailable")
+)
+
+// Send a request to lgproxy, and send its response line by line to the channel.
+// Output exceeding maxResponseSize() is dropped, with a notice in its place.
+// The request is aborted once ctx is cancelled, e.g. when the client disconnects.
+// Errors are also sent to the channel, the returned error is for deciding
+// whether the response can be cached. It wraps errProxyUnavailable if lgproxy
+// couldn't be reached or didn't respond. The caller closes the channel.
+func streamRequest(ctx context.Context, server string, endpoint string, url string, ch chan<- string) (err error) {
+	start := time.Now()
+	defer func() { observeProxyRequest(server, endpoint, start, err) }()
+
+	// Stop sending if nobody is waiting for the result anymore
+	send := func(s string) bool {
+		select {
+		case ch <- s:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	response, err := proxyGet(ctx, server, url)
+	if err != nil {
+		send("request failed: " + err.Error() + "\n")
+		return fmt.Errorf("%w: %w", errProxyUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	limit := maxResponseSize()
+	reader := bufio.NewReader(io.LimitReader(response.Body, int64(limit)))
+	size := 0
+	for {
+		line, err := reader.ReadString('\n')
+		size += len(line)
+		if len(line) > 0 && !send(line) {
+			return ctx.Err()
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			send("request failed: " + err.Error())
+			return fmt.Errorf("%w: %w", errProxyUnavailable, err)
+		}
+	}
+
+	if size == 0 {
+		send("node returned empty response, please refresh to try again.")
+		return fmt.Errorf("%w: empty response", errProxyUnavailable)
+	}
+
+	// Check if there's anything left after reaching the limit
+	if size >= limit {
+		var buf [1]byte
+		if n, _ := response.Body.Read(buf[:]); n > 0 {
+			send(truncatedNotice(limit))
+		}
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New(response.Status)
+	}
+	return nil
+}
+
 // Send commands to lgproxy instances in parallel, and retrieve their responses
-func batchRequest(servers []string, endpoint string, command string) []string {
+// as streams of lines. Each channel is closed when the response is complete.
+// All channels must be drained by the caller.
+func batchRequestStream(ctx context.Context, servers []string, endpoint string, command string) []<-chan string {
+	return batchRequestStreamFormat(ctx, servers, endpoint, command, "")
+}
+
+// Same as batchRequestStream, but requests lgproxy to output in the given format
+func batchRequestStreamFormat(ctx context.Context, servers []string, endpoint string, command string, format string) []<-chan string {
+	streams, _, _ := batchRequestStreamCached(ctx, servers, endpoint, command, format)
+	return streams
+}
+
+// Same as batchRequestStreamFormat, but also returns the time each result was
+// cached, or zero time for fresh results, and the error of each request. An
+// error is set before its channel is closed, and is nil if lgproxy responded
+// successfully.
+func batchRequestStreamCached(ctx context.Context, servers []string, endpoint string, command string, format string) ([]<-chan string, []time.Time, []error) {
 	if len(servers) > len(setting.servers) {
-		return []string{"invalid request: too many servers specified"}
+		ch := make(chan string, 1)
+		ch <- "invalid request: too many servers specified"
+		close(ch)
+		return []<-chan string{ch}, []time.Time{{}}, []error{errors.New("too many servers specified")}
 	}
 
-	// Channel and array for storing responses
-	var ch chan channelData = make(chan channelData)
-	var responseArray []string = make([]string, len(servers))
-	var createdGoroutines int = 0
-
+	var result []<-chan string = make([]<-chan string, len(servers))
+	cachedAt := make([]time.Time, len(servers))
+	errs := make([]error, len(servers))
+	ttl := cacheTTL(endpoint, command)
 	for i, server := range servers {
-		// Check if the server is in the valid server list passed at startup
-		var isValidServer bool = false
-		for _, validServer := range setting.servers {
-			if validServer == server {
-				isValidServer = true
-				break
-			}
-		}
+		ch := make(chan string, streamBufferLines)
+		result[i] = ch
 
-		if !isValidServer {
+		if !isValidServer(server) {
 			// If the server is not valid, return a failure
-			responseArray[i] = "request failed: invalid server\n"
+			ch <- "request failed: invalid server\n"
+			errs[i] = errInvalidServer
+			close(ch)
+		} else if !serverEndpointEnabled(server, endpoint) {
+			ch <- "request failed: " + endpoint + " is not enabled on this server\n"
+			errs[i] = errEndpointDisabled
+			close(ch)
+		} else if ttl > 0 {
+			url := proxyURL(server, endpoint, command, format)
+			cachedAt[i] = requestCache.stream(ctx, cacheCategory(endpoint, command), cacheKey(server, endpoint, command, format), ttl, ch, &errs[i], func(ctx context.Context, ch chan<- string) error {
+				defer close(ch)
+				return streamRequest(ctx, server, endpoint, url, ch)
+			})
 		} else {
-			// Compose URL and send the request
-			hostname := server
-			hostname = url.PathEscape(hostname)
-			if strings.Contains(hostname, ":") {
-				hostname = "[" + hostname + "]"
-			}
-			if setting.domain != "" {
-				hostname += "." + setting.domain
-			}
-			url := "http://" + hostname + ":" + strconv.Itoa(setting.proxyPort) + "/" + url.PathEscape(endpoint) + "?q=" + url.QueryEscape(command)
-			go func(url string, i int) {
-				client := http.Client{
-					Transport: createConnectionTimeoutRoundTripper(setting.connectionTimeOut),
-					Timeout:   time.Duration(setting.timeOut) * time.Second,
-				}
-				response, err := client.Get(url)
-				if err != nil {
-					ch <- channelData{i, "request failed: " + err.Error() + "\n"}
-					return
-				}
-
-				buf := make([]byte, 65536)
-				n, err := io.ReadFull(response.Body, buf)
-				if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-					ch <- channelData{i, "request failed: " + err.Error()}
-				} else {
-					ch <- channelData{i, string(buf[:n])}
-				}
-			}(url, i)
-			createdGoroutines++
+			go func() {
+				defer close(ch)
+				errs[i] = streamRequest(ctx, server, endpoint, proxyURL(server, endpoint, command, format), ch)
+			}()
 		}
 	}
 
-	// Sort the responses by their ids, to return data in order
-	for i := 0; i < createdGoroutines; i++ {
-		var output channelData = <-ch
-		responseArray[output.id] = output.data
-		if len(responseArray[output.id]) == 0 {
-			responseArray[output.id] = "node returned empty response, please refresh to try again."
+	return result, cachedAt, errs
+}
+
+// Wait for all streams to complete, and return their contents
+func collectStreams(streams []<-chan string) []string {
+	var responseArray []string = make([]string, len(streams))
+	for i, stream := range streams {
+		var builder strings.Builder
+		for line := range stream {
+			builder.WriteString(line)
 		}
+		responseArray[i] = builder.String()
 	}
 
 	return responseArray
 }
+
+// Send commands to lgproxy instances in parallel, and retrieve their responses
+func batchRequest(ctx context.Context, servers []string, endpoint string, command string) []string {
+	return collectStreams(batchRequestStream(ctx, servers, endpoint, command))
+}
+
+// Same as batchRequest, but requests lgproxy to output in the given format
+func batchRequestFormat(ctx context.Context, servers []string, endpoint string, command string, format string) []string {
+	return collectStreams(batchRequestStreamFormat(ctx, servers, endpoint, command, format))
+}
+
+// Same as batchRequestFormat, but also returns the time each result was
+// cached, or zero time for fresh results, and the error of each request, see
+// batchRequestStreamCached
+func batchRequestCached(ctx context.Context, servers []string, endpoint string, command string, format string) ([]string, []time.Time, []error) {
+	streams, cachedAt, errs := batchRequestStreamCached(ctx, servers, endpoint, command, format)
+	return collectStreams(streams), cachedAt, errs
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
 )
 
 func TestBatchRequestIPv4(t *testing.T) {
@@ -24,7 +28,7 @@ func TestBatchRequestIPv4(t *testing.T) {
 	}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest(setting.servers, "mock", "cmd")
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -52,7 +56,7 @@ func TestBatchRequestIPv6(t *testing.T) {
 	}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest(setting.servers, "mock", "cmd")
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -80,7 +84,7 @@ func TestBatchRequestEmptyResponse(t *testing.T) {
 	}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest(setting.servers, "mock", "cmd")
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -108,7 +112,7 @@ func TestBatchRequestDomainSuffix(t *testing.T) {
 	}
 	setting.domain = "suffix"
 	setting.proxyPort = 8000
-	response := batchRequest(setting.servers, "mock", "cmd")
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -136,7 +140,7 @@ func TestBatchRequestHTTPError(t *testing.T) {
 	}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest(setting.servers, "mock", "cmd")
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -152,7 +156,7 @@ func TestBatchRequestInvalidServer(t *testing.T) {
 	setting.servers = []string{"alpha"}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest([]string{"invalid"}, "mock", "cmd")
+	response := batchRequest(context.Background(), []string{"invalid"}, "mock", "cmd")
 
 	if len(response) != 1 {
 		t.Error("Did not get response of all mock servers")
@@ -166,7 +170,7 @@ func TestBatchRequestTooManyInvalidServer(t *testing.T) {
 	setting.servers = []string{"alpha"}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest([]string{"invalid1", "invalid2", "invalid3", "invalid4", "invalid5"}, "mock", "cmd")
+	response := batchRequest(context.Background(), []string{"invalid1", "invalid2", "invalid3", "invalid4", "invalid5"}, "mock", "cmd")
 
 	if len(response) != 1 {
 		t.Error("Should only return one response for too many servers specified")
@@ -188,7 +192,7 @@ func TestBatchRequestMixedValidInvalid(t *testing.T) {
 	setting.servers = []string{"alpha", "beta", "gamma"}
 	setting.domain = ""
 	setting.proxyPort = 8000
-	response := batchRequest([]string{"invalid1", "alpha", "invalid2"}, "mock", "cmd")
+	response := batchRequest(context.Background(), []string{"invalid1", "alpha", "invalid2"}, "mock", "cmd")
 
 	if len(response) != 3 {
 		t.Error("Did not get response of all three mock servers")
@@ -203,3 +207,132 @@ func TestBatchRequestMixedValidInvalid(t *testing.T) {
 		t.Error("Did not produce invalid server error")
 	}
 }
+
+func TestBatchRequestTruncated(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, strings.Repeat("A\n", 100))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/mock?q=cmd", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	setting.maxResponseSize = 10
+	defer func() { setting.maxResponseSize = 0 }()
+
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
+
+	if !strings.HasPrefix(response[0], strings.Repeat("A\n", 5)+"\n") {
+		t.Errorf("Output before limit not kept: %q", response[0])
+	}
+	if !strings.Contains(response[0], "output truncated") {
+		t.Error("Did not produce truncation notice")
+	}
+}
+
+func TestBatchRequestExactlyAtLimit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, strings.Repeat("A\n", 5))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/mock?q=cmd", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	setting.maxResponseSize = 10
+	defer func() { setting.maxResponseSize = 0 }()
+
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
+
+	if response[0] != strings.Repeat("A\n", 5) {
+		t.Errorf("Output should not be truncated: %q", response[0])
+	}
+}
+
+func TestBatchRequestStream(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/mock?q=cmd", httpmock.NewStringResponder(200, "Line 1\nLine 2\nLine 3"))
+	httpmock.RegisterResponder("GET", "http://beta:8000/mock?q=cmd", httpmock.NewStringResponder(200, "Beta"))
+
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	streams := batchRequestStream(context.Background(), []string{"alpha", "invalid", "beta"}, "mock", "cmd")
+
+	if len(streams) != 3 {
+		t.Fatal("Did not get streams of all three servers")
+	}
+
+	var lines []string
+	for line := range streams[0] {
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 || lines[0] != "Line 1\n" || lines[2] != "Line 3" {
+		t.Errorf("Response not split into lines: %q", lines)
+	}
+
+	if line := <-streams[1]; !strings.Contains(line, "invalid server") {
+		t.Error("Did not produce invalid server error")
+	}
+	if line := <-streams[2]; line != "Beta" {
+		t.Error("HTTP response mismatch")
+	}
+}
+
+func TestBatchRequestCancelled(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Result").Delay(10 * time.Second)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/mock?q=cmd", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	response := batchRequest(ctx, setting.servers, "mock", "cmd")
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("Request not aborted after cancellation")
+	}
+	if strings.Contains(response[0], "Mock Result") {
+		t.Error("Should not get result of cancelled request")
+	}
+}
+
+func TestBatchRequestSigned(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	verifier := reqsign.NewVerifier([]byte("secret"), 0)
+	httpmock.RegisterResponder("GET", "http://1.1.1.1:8000/mock?q=cmd", func(r *http.Request) (*http.Response, error) {
+		if err := verifier.Verify(r); err != nil {
+			return httpmock.NewStringResponse(http.StatusForbidden, err.Error()), nil
+		}
+		return httpmock.NewStringResponse(http.StatusOK, "Mock Result"), nil
+	})
+
+	setting.servers = []string{"1.1.1.1"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	response := batchRequest(context.Background(), setting.servers, "mock", "cmd")
+	if !strings.Contains(response[0], reqsign.ErrMissingSignature.Error()) {
+		t.Errorf("Unsigned request should be rejected, got %q", response[0])
+	}
+
+	setting.proxySecret = "secret"
+	defer func() { setting.proxySecret = "" }()
+	response = batchRequest(context.Background(), setting.servers, "mock", "cmd")
+	if response[0] != "Mock Result" {
+		t.Errorf("Signed request should be accepted, got %q", response[0])
+	}
+}
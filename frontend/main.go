@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
 )
 
 type settingType struct {
 	servers           []string
 	serversDisplay    []string
+	serverConfigs     map[string]*serverConfig
+	serverGroups      []serverGroup
 	domain            string
 	proxyPort         int
 	whoisServer       string
@@ -23,12 +29,36 @@ type settingType struct {
 	navBarAllURL      string
 	bgpmapInfo        string
 	telegramBotName   string
+	telegramSecret    string
 	protocolFilter    []string
 	nameFilter        string
 	timeOut           int
 	connectionTimeOut int
 	trustProxyHeaders bool
 	vrf               string
+	maxResponseSize   int
+
+	proxyTLS        bool
+	proxyTLSServers []string
+	proxyTLSConfig  *tls.Config
+	proxySecret     string
+
+	// How long results of each category are cached, not cached if absent
+	cacheTTL map[string]time.Duration
+
+	metrics bool
+
+	// Fetch "show protocols all" for route counts in summary tables
+	summaryRouteCounts bool
+
+	// Interval of background health checks, disabled if 0
+	statusInterval time.Duration
+
+	// Rate limits of each category, not limited if absent
+	rateLimits map[string]*ratelimit.Limiter
+
+	// Authentication and roles, nil if disabled
+	auth *authSettings
 }
 
 var setting settingType
@@ -37,6 +67,10 @@ func main() {
 	parseSettings()
 	ImportTemplates()
 
+	if setting.statusInterval > 0 {
+		statusStart(context.Background(), setting.statusInterval)
+	}
+
 	for _, listenAddr := range setting.listen {
 		go func(listenAddr string) {
 			var l net.Listener
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+// Upper bounds of histogram buckets in seconds, for latencies from
+// milliseconds to a full traceroute
+var metricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	metricRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_frontend_requests_total",
+		Help: "Number of requests handled, by handler and status code.",
+	}, []string{"handler", "code"})
+	metricRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "birdlg_frontend_request_duration_seconds",
+		Help:    "Time taken to handle requests, by handler.",
+		Buckets: metricsDurationBuckets,
+	}, []string{"handler"})
+	metricProxyRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_frontend_proxy_requests_total",
+		Help: "Number of requests sent to lgproxy, by server, endpoint and result (success or error).",
+	}, []string{"server", "endpoint", "result"})
+	metricProxyRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "birdlg_frontend_proxy_request_duration_seconds",
+		Help:    "Time taken by requests to lgproxy until the response is complete, by server and endpoint.",
+		Buckets: metricsDurationBuckets,
+	}, []string{"server", "endpoint"})
+	metricWhoisDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "birdlg_frontend_whois_duration_seconds",
+		Help:    "Time taken by whois queries, by result (success or error).",
+		Buckets: metricsDurationBuckets,
+	}, []string{"result"})
+	metricASNLookupDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "birdlg_frontend_asn_lookup_duration_seconds",
+		Help:    "Time taken to look up names of ASNs for BGP maps, by the source of the name (dns, whois or none).",
+		Buckets: metricsDurationBuckets,
+	}, []string{"source"})
+	metricASNCacheRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_frontend_asn_cache_requests_total",
+		Help: "Lookups of ASN names in the cache, by result (hit or miss).",
+	}, []string{"result"})
+	metricCacheRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_frontend_cache_requests_total",
+		Help: "Requests to the result cache, by category and result (hit, coalesced or miss).",
+	}, []string{"category", "result"})
+	metricRateLimited = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_frontend_rate_limited_total",
+		Help: "Requests rejected by rate limits, by category.",
+	}, []string{"category"})
+)
+
+func init() {
+	promauto.With(metricsRegistry).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "birdlg_frontend_cache_entries",
+		Help: "Number of results in the cache.",
+	}, func() float64 {
+		return float64(requestCache.len())
+	})
+}
+
+// Handler of metrics on /metrics
+func metricsRegistryHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Label of the result of an operation
+func metricsResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// Metrics handler, records the count and duration of requests. Requests are
+// labelled with the pattern they match in mux, to limit the number of series.
+func metricsHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "other"
+		}
+
+		// httpsnoop keeps the http.Flusher of the writer, needed for streaming
+		m := httpsnoop.CaptureMetrics(mux, w, r)
+
+		metricRequests.WithLabelValues(pattern, strconv.Itoa(m.Code)).Inc()
+		metricRequestDuration.WithLabelValues(pattern).Observe(m.Duration.Seconds())
+	})
+}
+
+// Record a request to lgproxy started at start
+func observeProxyRequest(server string, endpoint string, start time.Time, err error) {
+	metricProxyRequests.WithLabelValues(server, endpoint, metricsResult(err)).Inc()
+	metricProxyRequestDuration.WithLabelValues(server, endpoint).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	metricsRegistryHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	return w.Body.String()
+}
+
+// Number of observations of a histogram
+func histogramCount(t *testing.T, h prometheus.Observer) uint64 {
+	var m dto.Metric
+	if err := h.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/summary/", func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+	})
+	mux.Handle("/metrics", metricsRegistryHandler())
+	handler := metricsHandler(mux)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/summary/alpha", nil))
+	assert.Equal(t, w.Flushed, true)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nonexistent", nil))
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, `birdlg_frontend_requests_total{code="200",handler="/summary/"}`) {
+		t.Errorf("Request not counted:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_frontend_requests_total{code="404",handler="other"}`) {
+		t.Errorf("Unknown path not counted as other:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_frontend_request_duration_seconds_count{handler="/summary/"}`) {
+		t.Errorf("Request duration not recorded:\n%s", body)
+	}
+}
+
+func TestMetricsProxyRequests(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://metrics-alpha:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response"))
+	httpmock.RegisterResponder("GET", "http://metrics-beta:8000/ping?q=1.1.1.1", httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	setting.servers = []string{"metrics-alpha", "metrics-beta"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	batchRequest(context.Background(), setting.servers, "ping", "1.1.1.1")
+
+	body := scrapeMetrics(t)
+	if !strings.Contains(body, `birdlg_frontend_proxy_requests_total{endpoint="ping",result="success",server="metrics-alpha"} 1`) {
+		t.Errorf("Successful request not counted:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_frontend_proxy_requests_total{endpoint="ping",result="error",server="metrics-beta"} 1`) {
+		t.Errorf("Failed request not counted:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_frontend_proxy_request_duration_seconds_count{endpoint="ping",server="metrics-alpha"} 1`) {
+		t.Errorf("Request duration not recorded:\n%s", body)
+	}
+}
+
+func TestMetricsCacheRequests(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/traceroute?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response\n"))
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useCache(t, map[string]time.Duration{"traceroute": time.Minute})
+
+	hits := testutil.ToFloat64(metricCacheRequests.WithLabelValues("traceroute", "hit"))
+	misses := testutil.ToFloat64(metricCacheRequests.WithLabelValues("traceroute", "miss"))
+
+	batchRequest(context.Background(), setting.servers, "traceroute", "1.1.1.1")
+	batchRequest(context.Background(), setting.servers, "traceroute", "1.1.1.1")
+
+	assert.Equal(t, testutil.ToFloat64(metricCacheRequests.WithLabelValues("traceroute", "hit")), hits+1)
+	assert.Equal(t, testutil.ToFloat64(metricCacheRequests.WithLabelValues("traceroute", "miss")), misses+1)
+	if !strings.Contains(scrapeMetrics(t), "birdlg_frontend_cache_entries 1\n") {
+		t.Error("Cache entries not reported")
+	}
+}
+
+func TestMetricsWhois(t *testing.T) {
+	setting.whoisServer = "/bin/sh -c \"echo $0\""
+	defer func() { setting.whoisServer = "" }()
+
+	before := histogramCount(t, metricWhoisDuration.WithLabelValues("success"))
+	result := whois("AS4242420000")
+	assert.Equal(t, result, "AS4242420000\n")
+	assert.Equal(t, histogramCount(t, metricWhoisDuration.WithLabelValues("success")), before+1)
+}
+
+func TestMetricsASNLookup(t *testing.T) {
+	setting.dnsInterface = ""
+	setting.whoisServer = ""
+	hits := testutil.ToFloat64(metricASNCacheRequests.WithLabelValues("hit"))
+	lookups := histogramCount(t, metricASNLookupDuration.WithLabelValues("none"))
+
+	cache := make(ASNCache)
+	assert.Equal(t, cache.Lookup("4242420000"), "AS4242420000")
+	assert.Equal(t, cache.Lookup("4242420000"), "AS4242420000")
+
+	assert.Equal(t, testutil.ToFloat64(metricASNCacheRequests.WithLabelValues("hit")), hits+1)
+	assert.Equal(t, histogramCount(t, metricASNLookupDuration.WithLabelValues("none")), lookups+1)
+}
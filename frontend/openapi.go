@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path of the OpenAPI document of the JSON APIs
+const openAPIPath = "/api/openapi.json"
+
+// A JSON object of the OpenAPI document
+type openAPIObject = map[string]interface{}
+
+// Builds schemas of Go types from their JSON encoding. Named structs are
+// added to components/schemas, and referenced by name.
+type openAPISchemas struct {
+	schemas openAPIObject
+	types   map[string]reflect.Type
+}
+
+func newOpenAPISchemas() *openAPISchemas {
+	return &openAPISchemas{
+		schemas: make(openAPIObject),
+		types:   make(map[string]reflect.Type),
+	}
+}
+
+func openAPIRef(name string) openAPIObject {
+	return openAPIObject{"$ref": "#/components/schemas/" + name}
+}
+
+// Schema of values of type t
+func (s *openAPISchemas) schema(t reflect.Type) openAPIObject {
+	if t == reflect.TypeOf(time.Time{}) {
+		return openAPIObject{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schema(t.Elem())
+	case reflect.Bool:
+		return openAPIObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return openAPIObject{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return openAPIObject{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return openAPIObject{"type": "number"}
+	case reflect.String:
+		return openAPIObject{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return openAPIObject{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return openAPIObject{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Interface:
+		// Any value
+		return openAPIObject{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		if existing, ok := s.types[t.Name()]; ok {
+			if existing != t {
+				panic("openapi: types " + existing.String() + " and " + t.String() + " have the same name")
+			}
+		} else {
+			s.types[t.Name()] = t
+			s.schemas[t.Name()] = s.structSchema(t)
+		}
+		return openAPIRef(t.Name())
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// Schema of a struct, with properties named after their JSON keys. Fields
+// without omitempty are required.
+func (s *openAPISchemas) structSchema(t reflect.Type) openAPIObject {
+	properties := make(openAPIObject)
+	required := []string{}
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" && options == "" {
+				continue
+			}
+			// Fields of embedded structs are encoded as fields of the parent
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema := s.schema(field.Type)
+			omitEmpty := strings.Contains(","+options+",", ",omitempty,")
+			if field.Type.Kind() == reflect.Pointer && !omitEmpty {
+				schema = openAPIObject{"anyOf": []interface{}{schema, openAPIObject{"type": "null"}}}
+			}
+			properties[name] = schema
+			if !omitEmpty {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	result := openAPIObject{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		result["required"] = required
+	}
+	return result
+}
+
+// JSON response with the schema of value
+func (s *openAPISchemas) response(description string, value interface{}) openAPIObject {
+	return openAPIObject{
+		"description": description,
+		"content": openAPIObject{
+			"application/json": openAPIObject{"schema": s.schema(reflect.TypeOf(value))},
+		},
+	}
+}
+
+// Sorted keys of the API request types
+func apiRequestTypes() []string {
+	types := make([]string, 0, len(apiHandlerMap))
+	for requestType := range apiHandlerMap {
+		types = append(types, requestType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Operation of the POST API on /api/
+func openAPIQueryOperation(s *openAPISchemas) openAPIObject {
+	requestSchema := s.schema(reflect.TypeOf(apiRequest{}))
+	s.schemas["apiRequest"].(openAPIObject)["properties"].(openAPIObject)["type"] = openAPIObject{
+		"type": "string",
+		"enum": apiRequestTypes(),
+	}
+
+	// Items of result depend on the request type
+	var results []interface{}
+	seen := make(map[string]bool)
+	for _, requestType := range apiRequestTypes() {
+		ref := s.schema(reflect.TypeOf(apiResultTypes[requestType]))
+		if name := ref["$ref"].(string); !seen[name] {
+			seen[name] = true
+			results = append(results, ref)
+		}
+	}
+	response := s.response("Results of each server", apiResponse{})
+	// Result is null if the request failed
+	s.schemas["apiResponse"].(openAPIObject)["properties"].(openAPIObject)["result"] = openAPIObject{
+		"type":  []string{"array", "null"},
+		"items": openAPIObject{"oneOf": results},
+	}
+
+	errorResponse := openAPIObject{
+		"description": "Error of the request, in error",
+		"content":     response["content"],
+	}
+	return openAPIObject{
+		"operationId": "query",
+		"summary":     "Run a query of the given type on servers",
+		"requestBody": openAPIObject{
+			"required": true,
+			"content": openAPIObject{
+				"application/json": openAPIObject{"schema": requestSchema},
+			},
+		},
+		"responses": openAPIObject{
+			"200": response,
+			"403": errorResponse,
+			"413": openAPIObject{"description": "Request body too large"},
+			"429": errorResponse,
+		},
+	}
+}
+
+var openAPIPathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// Descriptions of path parameters of the REST API
+var openAPIPathParams = map[string]string{
+	"servers": "Servers joined by +, or a server group, e.g. alpha+beta or @eu",
+	"name":    "Name of the protocol",
+}
+
+// Operation of an endpoint of the REST API
+func openAPIEndpointOperation(s *openAPISchemas, endpoint apiV2Endpoint) openAPIObject {
+	_, path, _ := strings.Cut(endpoint.Pattern, " ")
+
+	parameters := []interface{}{}
+	for _, match := range openAPIPathParamRe.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, openAPIObject{
+			"name":        match[1],
+			"in":          "path",
+			"required":    true,
+			"description": openAPIPathParams[match[1]],
+			"schema":      openAPIObject{"type": "string"},
+		})
+	}
+	names := make([]string, 0, len(endpoint.Query))
+	for name := range endpoint.Query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parameters = append(parameters, openAPIObject{
+			"name":        name,
+			"in":          "query",
+			"required":    true,
+			"description": endpoint.Query[name],
+			"schema":      openAPIObject{"type": "string"},
+		})
+	}
+
+	responses := openAPIObject{
+		"200":     s.response("Success", endpoint.Response),
+		"default": s.response("Error of the request", apiV2ErrorResponse{}),
+	}
+	// Endpoints with results of each server fail if all servers failed
+	if _, ok := reflect.TypeOf(endpoint.Response).FieldByName("Results"); ok {
+		// Or an error of the request, for unknown servers
+		responses["404"] = openAPIObject{
+			"description": "Not found on any server, or unknown server",
+			"content": openAPIObject{
+				"application/json": openAPIObject{"schema": openAPIObject{"oneOf": []interface{}{
+					s.schema(reflect.TypeOf(endpoint.Response)),
+					s.schema(reflect.TypeOf(apiV2ErrorResponse{})),
+				}}},
+			},
+		}
+		responses["502"] = s.response("Failed on all servers", endpoint.Response)
+	}
+
+	operation := openAPIObject{
+		"operationId": endpoint.OperationID,
+		"summary":     endpoint.Summary,
+		"responses":   responses,
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	return operation
+}
+
+// Build the OpenAPI document of the POST API on /api/ and the REST API on
+// /api/v2/
+func openAPIDocument() openAPIObject {
+	s := newOpenAPISchemas()
+	paths := openAPIObject{
+		"/api/": openAPIObject{"post": openAPIQueryOperation(s)},
+	}
+	for _, endpoint := range apiV2Endpoints {
+		method, path, _ := strings.Cut(endpoint.Pattern, " ")
+		item, ok := paths[path].(openAPIObject)
+		if !ok {
+			item = make(openAPIObject)
+			paths[path] = item
+		}
+		item[strings.ToLower(method)] = openAPIEndpointOperation(s, endpoint)
+	}
+
+	return openAPIObject{
+		"openapi": "3.1.0",
+		"info": openAPIObject{
+			"title":       "Bird-lg-go API",
+			"version":     "2.0.0",
+			"description": "Queries of BIRD, traceroute and whois on servers of the looking glass. See docs/API.md for details.",
+		},
+		"paths": paths,
+		"components": openAPIObject{
+			"schemas": s.schemas,
+			"securitySchemes": openAPIObject{
+				"bearerAuth": openAPIObject{"type": "http", "scheme": "bearer"},
+				"basicAuth":  openAPIObject{"type": "http", "scheme": "basic"},
+			},
+		},
+		// Authentication is optional, depending on the auth setting
+		"security": []interface{}{
+			openAPIObject{},
+			openAPIObject{"bearerAuth": []string{}},
+			openAPIObject{"basicAuth": []string{}},
+		},
+	}
+}
+
+// The document doesn't depend on settings, so it's only built once
+var openAPIDocumentJSON = sync.OnceValue(func() []byte {
+	result, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return append(result, '\n')
+})
+
+func apiOpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPIDocumentJSON())
+}
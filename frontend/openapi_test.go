@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+)
+
+var updateOpenAPI = flag.Bool("update", false, "update docs/openapi.json")
+
+const openAPIDocumentFile = "../docs/openapi.json"
+
+// The checked in document must match the types of the API, run
+// "go test -run TestOpenAPIDocument -update" after changing them
+func TestOpenAPIDocument(t *testing.T) {
+	actual := openAPIDocumentJSON()
+	if *updateOpenAPI {
+		if err := os.WriteFile(openAPIDocumentFile, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(openAPIDocumentFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != string(actual) {
+		t.Error("docs/openapi.json is out of date, run go test -run TestOpenAPIDocument -update")
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	apiOpenAPIHandler(w, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, document["openapi"], "3.1.0")
+}
+
+func TestOpenAPIRequestTypes(t *testing.T) {
+	var resultTypes []string
+	for requestType := range apiResultTypes {
+		resultTypes = append(resultTypes, requestType)
+	}
+	sort.Strings(resultTypes)
+	assert.Equal(t, resultTypes, apiRequestTypes())
+
+	schemas := openAPIDocument()["components"].(openAPIObject)["schemas"].(openAPIObject)
+	requestType := schemas["apiRequest"].(openAPIObject)["properties"].(openAPIObject)["type"].(openAPIObject)
+	assert.Equal(t, requestType["enum"], apiRequestTypes())
+}
+
+// Results returned by each handler must be of the type in the document
+func TestOpenAPIResultTypes(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterNoResponder(httpmock.NewStringResponder(200, "Mock Response\n"))
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	serverStatuses.reset()
+
+	for _, requestType := range apiRequestTypes() {
+		response := apiHandlerMap[requestType](context.Background(), apiRequest{
+			Servers: []string{"alpha"},
+			Type:    requestType,
+			Args:    "192.0.2.1",
+		})
+		if len(response.Result) == 0 {
+			t.Errorf("%s: no result", requestType)
+			continue
+		}
+		expected := reflect.TypeOf(apiResultTypes[requestType])
+		for _, result := range response.Result {
+			actual := reflect.TypeOf(result)
+			if actual.Kind() == reflect.Pointer {
+				actual = actual.Elem()
+			}
+			if actual != expected {
+				t.Errorf("%s: result of type %s, documented as %s", requestType, actual, expected)
+			}
+		}
+	}
+}
+
+func TestOpenAPIEndpoints(t *testing.T) {
+	paths := openAPIDocument()["paths"].(openAPIObject)
+	for _, endpoint := range apiV2Endpoints {
+		if endpoint.OperationID == "" || endpoint.Summary == "" || endpoint.Response == nil {
+			t.Errorf("%s: missing operation ID, summary or response", endpoint.Pattern)
+		}
+		operation := paths["/api/v2/"+endpoint.Pattern[len("GET /api/v2/"):]].(openAPIObject)["get"].(openAPIObject)
+		assert.Equal(t, operation["operationId"], endpoint.OperationID)
+	}
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	s := newOpenAPISchemas()
+	s.schema(reflect.TypeOf(apiV2ProtocolResult{}))
+
+	schema := s.schemas["apiV2ProtocolResult"].(openAPIObject)
+	properties := schema["properties"].(openAPIObject)
+
+	// Fields of embedded structs are inlined
+	assert.Equal(t, properties["server"], openAPIObject{"type": "string"})
+	assert.Equal(t, properties["error"], openAPIRef("apiV2Error"))
+	// Pointers without omitempty can be null
+	assert.Equal(t, properties["data"], openAPIObject{"anyOf": []interface{}{openAPIRef("Protocol"), openAPIObject{"type": "null"}}})
+	assert.Equal(t, schema["required"], []string{"server", "data"})
+
+	// Fields skipped in JSON are not documented
+	s.schema(reflect.TypeOf(SummaryRowData{}))
+	_, ok := s.schemas["SummaryRowData"].(openAPIObject)["properties"].(openAPIObject)["MappedState"]
+	assert.Equal(t, ok, false)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+)
+
+// Categories of requests with their own rate limits
+var rateLimitCategories = []string{"bird", "traceroute", "ping", "whois", "status"}
+
+// Rate limit category of each API request type, not limited if absent
+var apiRateLimitCategories = map[string]string{
+	"summary":         "bird",
+	"detail":          "bird",
+	"route":           "bird",
+	"compare":         "bird",
+	"bird":            "bird",
+	"traceroute":      "traceroute",
+	"traceroute_hops": "traceroute",
+	"ping":            "ping",
+	"whois":           "whois",
+	"status":          "status",
+}
+
+// Check the rate limit of the client for a request querying the given number
+// of servers. Each server takes a token, so requests to all servers can't be
+// used to multiply the load, but never more than the whole bucket.
+// Returns false and the time to wait if the client is over the limit.
+func rateLimitAllow(r *http.Request, category string, servers int) (bool, string) {
+	// RemoteAddr is from proxy headers if trust_proxy_headers is set
+	return rateLimitAllowKey(ratelimit.ClientIP(r.RemoteAddr), category, servers)
+}
+
+// Check the rate limit of a client identified by key, e.g. its IP
+func rateLimitAllowKey(key string, category string, servers int) (bool, string) {
+	limiter := setting.rateLimits[category]
+	if limiter == nil {
+		return true, ""
+	}
+
+	cost := min(max(servers, 1), limiter.Rate().Count)
+	ok, wait := limiter.AllowN(key, cost)
+	if !ok {
+		metricRateLimited.WithLabelValues(category).Inc()
+		return false, ratelimit.RetryAfter(wait)
+	}
+	return true, ""
+}
+
+// Check the rate limit of the client, and respond with 429 if it's over
+// the limit. Returns true if the request should not be handled.
+func rateLimited(w http.ResponseWriter, r *http.Request, category string, servers int) bool {
+	ok, retryAfter := rateLimitAllow(r, category, servers)
+	if ok {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter)
+	http.Error(w, "Too many requests, please try again in "+retryAfter+" seconds.", http.StatusTooManyRequests)
+	return true
+}
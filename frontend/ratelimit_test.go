@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+)
+
+func useRateLimits(t *testing.T, rates map[string]ratelimit.Rate) {
+	setting.rateLimits = make(map[string]*ratelimit.Limiter)
+	for category, rate := range rates {
+		setting.rateLimits[category] = ratelimit.NewLimiter(rate)
+	}
+	t.Cleanup(func() { setting.rateLimits = nil })
+}
+
+func TestRateLimitAllow(t *testing.T) {
+	useRateLimits(t, map[string]ratelimit.Rate{"bird": {Count: 3, Per: time.Minute}})
+
+	r := httptest.NewRequest(http.MethodGet, "/summary/alpha", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+
+	// Each server takes a token
+	ok, _ := rateLimitAllow(r, "bird", 2)
+	assert.Equal(t, ok, true)
+	ok, retryAfter := rateLimitAllow(r, "bird", 2)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, "20")
+
+	// Requests to more servers than the bucket size take the whole bucket
+	r.RemoteAddr = "192.0.2.2:12345"
+	ok, _ = rateLimitAllow(r, "bird", 10)
+	assert.Equal(t, ok, true)
+	ok, _ = rateLimitAllow(r, "bird", 1)
+	assert.Equal(t, ok, false)
+
+	// Categories without limits
+	ok, _ = rateLimitAllow(r, "ping", 10)
+	assert.Equal(t, ok, true)
+	ok, _ = rateLimitAllow(r, "", 10)
+	assert.Equal(t, ok, true)
+}
+
+func TestRateLimitedWhois(t *testing.T) {
+	initSettings()
+	setting.whoisServer = "/bin/sh -c \"echo $0\""
+	defer func() { setting.whoisServer = "" }()
+	useRateLimits(t, map[string]ratelimit.Rate{"whois": {Count: 1, Per: time.Minute}})
+	before := testutil.ToFloat64(metricRateLimited.WithLabelValues("whois"))
+
+	w := httptest.NewRecorder()
+	webHandlerWhois(w, httptest.NewRequest(http.MethodGet, "/whois/AS4242420000", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = httptest.NewRecorder()
+	webHandlerWhois(w, httptest.NewRequest(http.MethodGet, "/whois/AS4242420000", nil))
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, w.Header().Get("Retry-After"), "60")
+	assert.Equal(t, testutil.ToFloat64(metricRateLimited.WithLabelValues("whois")), before+1)
+}
+
+func TestRateLimitedBackendCommunicator(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response\n"))
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useRateLimits(t, map[string]ratelimit.Rate{"ping": {Count: 1, Per: time.Minute}})
+
+	handler := webBackendCommunicator("ping", "ping")
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/ping/alpha/1.1.1.1", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/ping/alpha/1.1.1.1", nil))
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, httpmock.GetTotalCallCount(), 1)
+}
+
+func TestRateLimitedAPI(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show status"), httpmock.NewStringResponder(200, "Mock Response\n"))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q="+url.QueryEscape("show status"), httpmock.NewStringResponder(200, "Mock Response\n"))
+
+	setting.servers = []string{"alpha", "beta"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useRateLimits(t, map[string]ratelimit.Rate{"bird": {Count: 3, Per: time.Minute}})
+
+	request := func(body string) (int, apiResponse) {
+		w := httptest.NewRecorder()
+		apiHandler(w, httptest.NewRequest(http.MethodPost, "/api/", bytes.NewBufferString(body)))
+		var response apiResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, response
+	}
+
+	body := `{"servers": ["alpha", "beta"], "type": "bird", "args": "show status"}`
+	code, response := request(body)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, response.Error, "")
+
+	code, response = request(body)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	if !strings.HasPrefix(response.Error, "too many requests") {
+		t.Errorf("Unexpected error %s", response.Error)
+	}
+
+	// Types without rate limits
+	code, _ = request(`{"servers": [], "type": "server_list", "args": ""}`)
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestTelegramRateLimited(t *testing.T) {
+	useRateLimits(t, map[string]ratelimit.Rate{"traceroute": {Count: 2, Per: time.Minute}})
+	servers := []string{"alpha", "beta"}
+
+	_, limited := telegramRateLimited("/trace 1.1.1.1", 456, servers)
+	assert.Equal(t, limited, false)
+	message, limited := telegramRateLimited("/trace 1.1.1.1", 456, servers)
+	assert.Equal(t, limited, true)
+	assert.Equal(t, message, "Too many requests, please try again in 60 seconds.")
+
+	// Limits are kept for each chat, and commands without limits are allowed
+	_, limited = telegramRateLimited("/trace 1.1.1.1", 789, servers)
+	assert.Equal(t, limited, false)
+	_, limited = telegramRateLimited("/route 1.1.1.1", 456, servers)
+	assert.Equal(t, limited, false)
+	_, limited = telegramRateLimited("/help", 456, servers)
+	assert.Equal(t, limited, false)
+}
+
+func TestWebHandlerTelegramBotRateLimited(t *testing.T) {
+	useRateLimits(t, map[string]ratelimit.Rate{"ping": {Count: 1, Per: time.Minute}})
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q="+url.QueryEscape("1.1.1.1"),
+		httpmock.NewStringResponder(200, "Mock Result"))
+
+	assert.Equal(t, mockTelegramCall(t, "/ping 1.1.1.1", false), "```\nMock Result\n```")
+	assert.Equal(t, mockTelegramCall(t, "/ping 1.1.1.1", false), "```\nToo many requests, please try again in 60 seconds.\n```")
+	assert.Equal(t, httpmock.GetTotalCallCount(), 1)
+}
+
+func TestRateLimitedStatus(t *testing.T) {
+	initSettings()
+	mockStatusServers(t)
+	useRateLimits(t, map[string]ratelimit.Rate{"status": {Count: 1, Per: time.Minute}})
+
+	w := httptest.NewRecorder()
+	webHandlerStatus(w, httptest.NewRequest(http.MethodGet, "/status/alpha/", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	w = httptest.NewRecorder()
+	webHandlerStatus(w, httptest.NewRequest(http.MethodGet, "/status/alpha/", nil))
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, httpmock.GetCallCountInfo()["GET http://alpha:8000/healthz"], 1)
+}
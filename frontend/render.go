@@ -9,6 +9,8 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
 )
 
 // static options map
@@ -26,6 +28,7 @@ var optionsMap = map[string]string{
 	"route":                            "show route for ...",
 	"route_all":                        "show route for ... all",
 	"route_bgpmap":                     "show route for ... (bgpmap)",
+	"compare":                          "show route for ... (compare)",
 	"route_where":                      "show route where net ~ [ ... ]",
 	"route_where_all":                  "show route where net ~ [ ... ] all",
 	"route_where_bgpmap":               "show route where net ~ [ ... ] (bgpmap)",
@@ -33,10 +36,42 @@ var optionsMap = map[string]string{
 	"generic":                          "show ...",
 	"whois":                            "whois ...",
 	"traceroute":                       "traceroute ...",
+	"traceroute_table":                 "traceroute ... (table)",
+	"ping":                             "ping ...",
+	"status":                           "status",
 }
 
-// render the page template
-func renderPageTemplate(w http.ResponseWriter, r *http.Request, title string, content template.HTML) {
+// Placeholder of page content, for content streamed after the page is rendered
+const streamContentPlaceholder = "<!-- bird-lg-go content -->"
+
+// build server groups shown as dropdowns in the navigation bar, and the set
+// of servers in them. urlServer is the server part of the current URL.
+func templateServerGroups(urlServer string) ([]TemplateServerGroup, map[string]bool) {
+	groups := make([]TemplateServerGroup, 0, len(setting.serverGroups))
+	groupedServers := make(map[string]bool)
+
+	for _, group := range setting.serverGroups {
+		templateGroup := TemplateServerGroup{
+			Name:   group.name,
+			URL:    serverGroupPrefix + group.name,
+			Active: urlServer == serverGroupPrefix+group.name,
+		}
+		for _, server := range group.servers {
+			templateGroup.Servers = append(templateGroup.Servers, server)
+			templateGroup.ServersDisplay = append(templateGroup.ServersDisplay, serverDisplayName([]string{server}, 0))
+			if urlServer == server {
+				templateGroup.Active = true
+			}
+			groupedServers[server] = true
+		}
+		groups = append(groups, templateGroup)
+	}
+
+	return groups, groupedServers
+}
+
+// build arguments of the page template
+func pageTemplateArgs(r *http.Request, title string, content template.HTML) TemplatePage {
 	path := r.URL.Path[1:]
 	split := strings.SplitN(path, "/", 3)
 
@@ -52,11 +87,22 @@ func renderPageTemplate(w http.ResponseWriter, r *http.Request, title string, co
 	}
 
 	split = strings.SplitN(path, "/", 3)
+	groups, groupedServers := templateServerGroups(split[1])
+
+	// Only show options allowed for the client
+	options := make(map[string]string, len(optionsMap))
+	for option, text := range optionsMap {
+		if authAllowed(r, option) {
+			options[option] = text
+		}
+	}
 
-	args := TemplatePage{
-		Options:              optionsMap,
+	return TemplatePage{
+		Options:              options,
 		Servers:              setting.servers,
 		ServersDisplay:       setting.serversDisplay,
+		ServerGroups:         groups,
+		GroupedServers:       groupedServers,
 		AllServersLinkActive: strings.EqualFold(split[1], strings.Join(setting.servers, "+")),
 		AllServersURL:        strings.Join(setting.servers, "+"),
 		AllServerTitle:       setting.navBarAllServer,
@@ -72,20 +118,52 @@ func renderPageTemplate(w http.ResponseWriter, r *http.Request, title string, co
 		BrandURL:   setting.navBarBrandURL,
 		Content:    content,
 	}
+}
 
+// render the page template
+func renderPageTemplate(w http.ResponseWriter, r *http.Request, title string, content template.HTML) {
 	tmpl := TemplateLibrary["page"]
-	err := tmpl.Execute(w, args)
+	err := tmpl.Execute(w, pageTemplateArgs(r, title, content))
 	if err != nil {
 		fmt.Println("Error rendering page:", err.Error())
 	}
 
 }
 
-// Write the given text to http response, and add whois links for
-// ASNs and IP addresses
-func smartFormatter(s string) template.HTML {
+// Render a template with a placeholder, and split the result into the parts
+// before and after the placeholder
+func renderTemplateAround(name string, args interface{}) (string, string) {
+	tmpl := TemplateLibrary[name]
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, args)
+	if err != nil {
+		fmt.Println("Error rendering "+name+" template:", err.Error())
+	}
+
+	head, tail, _ := strings.Cut(buffer.String(), streamContentPlaceholder)
+	return head, tail
+}
+
+// render the page template, with content written by writeContent as it becomes available
+func renderPageTemplateStream(w http.ResponseWriter, r *http.Request, title string, writeContent func(w http.ResponseWriter)) {
+	head, tail := renderTemplateAround("page", pageTemplateArgs(r, title, streamContentPlaceholder))
+
+	w.Write([]byte(head))
+	flushResponse(w)
+	writeContent(w)
+	w.Write([]byte(tail))
+}
+
+// Send buffered response to client, if supported by the response writer
+func flushResponse(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Add whois links for ASNs and IP addresses to each line of the given text
+func smartFormatLines(s string) string {
 	var result string
-	result += "<pre>"
 	s = template.HTMLEscapeString(s)
 	for _, line := range strings.Split(s, "\n") {
 		var lineFormatted string
@@ -99,8 +177,13 @@ func smartFormatter(s string) template.HTML {
 		}
 		result += lineFormatted + "\n"
 	}
-	result += "</pre>"
-	return template.HTML(result)
+	return result
+}
+
+// Write the given text to http response, and add whois links for
+// ASNs and IP addresses
+func smartFormatter(s string) template.HTML {
+	return template.HTML("<pre>" + smartFormatLines(s) + "</pre>")
 }
 
 // Parse bird show protocols result
@@ -108,6 +191,7 @@ func summaryParse(data string, serverName string) (TemplateSummary, error) {
 	args := TemplateSummary{
 		ServerName: serverName,
 		Raw:        data,
+		Truncated:  outputTruncated(data),
 	}
 
 	lines := strings.Split(strings.TrimSpace(data), "\n")
@@ -125,8 +209,9 @@ func summaryParse(data string, serverName string) (TemplateSummary, error) {
 		args.Header = append(args.Header, col)
 	}
 
-	// Build regexp for nameFilter
-	nameFilterRegexp := regexp.MustCompile(setting.nameFilter)
+	filtered := summaryFilter()
+	routes := summaryRouteCounts(data)
+	channels := make(map[string]bool)
 
 	// sort the remaining rows
 	rows := lines[1:]
@@ -139,23 +224,73 @@ func summaryParse(data string, serverName string) (TemplateSummary, error) {
 			continue
 		}
 
-		// Filter row name
-		if setting.nameFilter != "" && nameFilterRegexp.MatchString(row.Name) {
+		if filtered(*row) {
 			continue
 		}
 
-		// Filter away unwanted protocol types, if setting.protocolFilter is non-empty
-		if len(setting.protocolFilter) > 0 && !row.ProtocolMatches(setting.protocolFilter) {
-			continue
+		row.Routes = routes[row.Name]
+		for channel := range row.Routes {
+			channels[channel] = true
 		}
 
 		// add to the result
 		args.Rows = append(args.Rows, *row)
 	}
 
+	for channel := range channels {
+		args.Channels = append(args.Channels, channel)
+	}
+	sort.Strings(args.Channels)
+
 	return args, nil
 }
 
+// Command of summary tables, with route counts if summary_route_counts is set
+func summaryCommand() string {
+	if setting.summaryRouteCounts {
+		return "show protocols all"
+	}
+	return primitiveMap["summary"]
+}
+
+// Route counts of each channel of each protocol, from the output of
+// "show protocols all"
+func summaryRouteCounts(data string) map[string]map[string]*birdparser.RouteCounts {
+	result := make(map[string]map[string]*birdparser.RouteCounts)
+	for _, protocol := range birdparser.ParseProtocols(data) {
+		for _, channel := range protocol.Channels {
+			if channel.Routes == nil {
+				continue
+			}
+			if result[protocol.Name] == nil {
+				result[protocol.Name] = make(map[string]*birdparser.RouteCounts)
+			}
+			result[protocol.Name][channel.Name] = channel.Routes
+		}
+	}
+	return result
+}
+
+// Build a function checking if a protocol is hidden by name_filter or
+// protocol_filter
+func summaryFilter() func(row SummaryRowData) bool {
+	// Build regexp for nameFilter
+	nameFilterRegexp := regexp.MustCompile(setting.nameFilter)
+
+	return func(row SummaryRowData) bool {
+		// Filter row name
+		if setting.nameFilter != "" && nameFilterRegexp.MatchString(row.Name) {
+			return true
+		}
+
+		// Filter away unwanted protocol types, if setting.protocolFilter is non-empty
+		if len(setting.protocolFilter) > 0 && !row.ProtocolMatches(setting.protocolFilter) {
+			return true
+		}
+		return false
+	}
+}
+
 // Output a table for the summary page
 func summaryTable(data string, serverName string) template.HTML {
 	result, err := summaryParse(data, serverName)
@@ -3,9 +3,13 @@ package main
 import (
 	"html/template"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
 )
 
 const BirdSummaryData = `Name       Proto      Table      State  Since         Info
@@ -48,6 +52,36 @@ func TestRenderPageTemplate(t *testing.T) {
 	}
 }
 
+func TestRenderPageTemplateStream(t *testing.T) {
+	initSettings()
+
+	title := "Test Title"
+	content := "Streamed Content"
+
+	r := httptest.NewRequest("GET", "/route/alpha/192.168.0.1/", nil)
+	w := httptest.NewRecorder()
+	renderPageTemplateStream(w, r, title, func(w http.ResponseWriter) {
+		if !w.(*httptest.ResponseRecorder).Flushed {
+			t.Error("Page header not flushed before content")
+		}
+		w.Write([]byte(content))
+	})
+
+	result := w.Body.String()
+	if !strings.Contains(result, title) {
+		t.Error("Title not found in output")
+	}
+	if !strings.Contains(result, "<div class=\"container\">\n\t"+content) {
+		t.Error("Content not found in place of placeholder")
+	}
+	if strings.Contains(result, streamContentPlaceholder) {
+		t.Error("Placeholder not removed from output")
+	}
+	if !strings.HasSuffix(strings.TrimSpace(result), "</html>") {
+		t.Error("Page not rendered completely")
+	}
+}
+
 func TestRenderPageTemplateXSS(t *testing.T) {
 	initSettings()
 
@@ -155,3 +189,66 @@ func TestSummaryTableNameFilter(t *testing.T) {
 		setting.nameFilter = ""
 	})
 }
+
+func TestSummaryTableRouteCounts(t *testing.T) {
+	initSettings()
+	data := readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt")
+
+	summary, err := summaryParse(data, "testserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, summary.Channels, []string{"ipv4", "ipv6"})
+	for _, row := range summary.Rows {
+		switch row.Name {
+		case "device1":
+			assert.Equal(t, len(row.Routes), 0)
+		case "dn42_alpha":
+			assert.Equal(t, *row.Routes["ipv4"], birdparser.RouteCounts{Imported: 680, Filtered: 3, Exported: 512, Preferred: 420})
+			assert.Equal(t, *row.Routes["ipv6"], birdparser.RouteCounts{Imported: 920, Exported: 0, Preferred: 610})
+		}
+	}
+
+	result := string(summaryTable(data, "testserver"))
+	for _, item := range []string{"ipv4 Imported", "ipv6 Exported", `<td data-sort="680">680</td>`, `<td data-sort="-1"></td>`} {
+		if !strings.Contains(result, item) {
+			t.Errorf("Did not find expected %s in summary table output", item)
+		}
+	}
+}
+
+func TestSummaryTableNoRouteCounts(t *testing.T) {
+	initSettings()
+
+	summary, err := summaryParse(BirdSummaryData, "testserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(summary.Channels), 0)
+	if strings.Contains(string(summaryTable(BirdSummaryData, "testserver")), "Imported") {
+		t.Error("Route count columns should be hidden without route counts")
+	}
+}
+
+func TestSummaryTableTruncated(t *testing.T) {
+	initSettings()
+
+	summary, err := summaryParse(BirdSummaryData, "testserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, summary.Truncated, false)
+	if strings.Contains(string(summaryTable(BirdSummaryData, "testserver")), "max_response_size") {
+		t.Error("Complete summary should not show a warning")
+	}
+
+	data := BirdSummaryData + truncatedNotice(65536)
+	summary, err = summaryParse(data, "testserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, summary.Truncated, true)
+	if !strings.Contains(string(summaryTable(data, "testserver")), "the last protocols are missing") {
+		t.Error("Truncated summary should show a warning")
+	}
+}
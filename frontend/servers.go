@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Prefix of group names in URLs and API requests, e.g. /summary/@eu/
+const serverGroupPrefix = "@"
+
+// Connection details of a server, from the structured form of the servers
+// setting. Unset fields fall back to the global settings.
+type serverConfig struct {
+	// Identifier of the server in URLs of the frontend
+	Name string `mapstructure:"name"`
+	// Name shown in the user interface, defaults to Name
+	Display string `mapstructure:"display"`
+	// Base URL of lgproxy, e.g. https://alpha.example.com:8443 or
+	// unix:///run/bird-lgproxy.sock. Defaults to Name, domain and port.
+	URL string `mapstructure:"url"`
+	// Port of lgproxy when URL is not set, defaults to proxy_port
+	Port int `mapstructure:"port"`
+	// Connect with HTTPS when URL is not set
+	TLS bool `mapstructure:"tls"`
+	// Secret to sign requests, defaults to proxy_secret
+	Secret   string `mapstructure:"secret"`
+	Group    string `mapstructure:"group"`
+	Location string `mapstructure:"location"`
+	// lgproxy endpoints enabled on this server, e.g. bird, traceroute and
+	// ping. All endpoints are enabled if empty.
+	Endpoints []string `mapstructure:"endpoints"`
+
+	// Parsed from URL
+	baseURL    string
+	socketPath string
+}
+
+// Split server names of the legacy form "DisplayName<Hostname>,Hostname2"
+func parseLegacyServers(servers string) ([]string, []string) {
+	names := strings.Split(servers, ",")
+	display := strings.Split(servers, ",")
+	for i, server := range names {
+		pos := strings.Index(server, "<")
+		if pos != -1 {
+			display[i] = server[0:pos]
+			names[i] = server[pos+1 : len(server)-1]
+		}
+	}
+	return names, display
+}
+
+// Validate structured server configs, and return names and display names
+// of the servers, and connection details indexed by name
+func parseServerConfigs(configs []serverConfig) ([]string, []string, map[string]*serverConfig, error) {
+	names := make([]string, 0, len(configs))
+	display := make([]string, 0, len(configs))
+	result := make(map[string]*serverConfig, len(configs))
+
+	for i := range configs {
+		config := &configs[i]
+		if config.Name == "" {
+			return nil, nil, nil, fmt.Errorf("server #%d has no name", i+1)
+		}
+		// "+" separates servers in URLs of the frontend
+		if strings.ContainsAny(config.Name, "+/") {
+			return nil, nil, nil, fmt.Errorf("server %s: name must not contain \"+\" or \"/\"", config.Name)
+		}
+		if _, ok := result[config.Name]; ok {
+			return nil, nil, nil, fmt.Errorf("server %s is defined more than once", config.Name)
+		}
+		if config.Display == "" {
+			config.Display = config.Name
+		}
+		if err := config.parseURL(); err != nil {
+			return nil, nil, nil, fmt.Errorf("server %s: %w", config.Name, err)
+		}
+
+		names = append(names, config.Name)
+		display = append(display, config.Display)
+		result[config.Name] = config
+	}
+
+	return names, display, result, nil
+}
+
+func (config *serverConfig) parseURL() error {
+	if config.URL == "" {
+		return nil
+	}
+	if config.Port != 0 || config.TLS {
+		return errors.New("port and tls can't be set together with url")
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("no host in url %s", config.URL)
+		}
+		config.baseURL = strings.TrimSuffix(u.String(), "/")
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("no socket path in url %s", config.URL)
+		}
+		config.socketPath = u.Path
+		// Host is ignored when connecting to unix sockets
+		config.baseURL = "http://localhost"
+	default:
+		return fmt.Errorf("unsupported scheme in url %s", config.URL)
+	}
+	return nil
+}
+
+// Connection details of the server, or nil if the server is configured in the
+// legacy form
+func serverConfigOf(server string) *serverConfig {
+	return setting.serverConfigs[server]
+}
+
+// Check if the lgproxy endpoint is enabled on the server
+func serverEndpointEnabled(server string, endpoint string) bool {
+	config := serverConfigOf(server)
+	if config == nil || len(config.Endpoints) == 0 {
+		return true
+	}
+	for _, enabled := range config.Endpoints {
+		if strings.EqualFold(enabled, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// Secret to sign requests to the server, empty if requests are not signed
+func serverSecret(server string) string {
+	if config := serverConfigOf(server); config != nil && config.Secret != "" {
+		return config.Secret
+	}
+	return setting.proxySecret
+}
+
+// A named group of servers, which can be queried together with "@name"
+type serverGroup struct {
+	name    string
+	servers []string
+}
+
+// Build server groups from the group of each server in configs, in the order
+// of servers, and from the server_groups setting, sorted by name. Members of
+// groups with the same name are merged.
+func buildServerGroups(servers []string, configs map[string]*serverConfig, groups map[string][]string) ([]serverGroup, error) {
+	var result []serverGroup
+	index := make(map[string]int)
+	valid := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		valid[server] = true
+	}
+
+	add := func(name string, server string) error {
+		if name == "" || strings.ContainsAny(name, "+/") {
+			return fmt.Errorf("invalid server group name %q", name)
+		}
+		if !valid[server] {
+			return fmt.Errorf("server group %s: unknown server %s", name, server)
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(result)
+			index[name] = i
+			result = append(result, serverGroup{name: name})
+		}
+		for _, existing := range result[i].servers {
+			if existing == server {
+				return nil
+			}
+		}
+		result[i].servers = append(result[i].servers, server)
+		return nil
+	}
+
+	for _, server := range servers {
+		if config := configs[server]; config != nil && config.Group != "" {
+			if err := add(config.Group, server); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, member := range groups[name] {
+			// Members can also be joined with "+" like in URLs, as commas
+			// separate groups in the command line parameter
+			for _, server := range strings.Split(member, "+") {
+				if err := add(name, strings.TrimSpace(server)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Find a server group by name
+func serverGroupOf(name string) *serverGroup {
+	for i := range setting.serverGroups {
+		if setting.serverGroups[i].name == name {
+			return &setting.serverGroups[i]
+		}
+	}
+	return nil
+}
+
+// Split servers joined by "+" in URLs, and expand "@group" to its servers
+func parseServersParam(param string) []string {
+	return expandServers(strings.Split(param, "+"))
+}
+
+// Expand "@group" in servers to the servers in the group, and remove
+// duplicates. Unknown groups are kept as is, and rejected later as invalid
+// servers.
+func expandServers(servers []string) []string {
+	result := make([]string, 0, len(servers))
+	seen := make(map[string]bool)
+	add := func(server string) {
+		if !seen[server] {
+			seen[server] = true
+			result = append(result, server)
+		}
+	}
+
+	for _, server := range servers {
+		if strings.HasPrefix(server, serverGroupPrefix) {
+			if group := serverGroupOf(server[len(serverGroupPrefix):]); group != nil {
+				for _, member := range group.servers {
+					add(member)
+				}
+				continue
+			}
+		}
+		add(server)
+	}
+	return result
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+	"github.com/spf13/viper"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
+)
+
+// Use structured server configs in tests, reset to legacy servers afterwards
+func useServerConfigs(t *testing.T, configs []serverConfig) {
+	servers, display, serverConfigs, err := parseServerConfigs(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting.servers = servers
+	setting.serversDisplay = display
+	setting.serverConfigs = serverConfigs
+	t.Cleanup(func() { setting.serverConfigs = nil })
+}
+
+func TestParseLegacyServers(t *testing.T) {
+	servers, display := parseLegacyServers("Prod<prod.example.com>,alpha,Test<fd00::1>")
+	assert.Equal(t, servers, []string{"prod.example.com", "alpha", "fd00::1"})
+	assert.Equal(t, display, []string{"Prod", "alpha", "Test"})
+}
+
+func TestParseServerConfigs(t *testing.T) {
+	servers, display, configs, err := parseServerConfigs([]serverConfig{
+		{Name: "alpha", Display: "Alpha"},
+		{Name: "beta", URL: "https://beta.example.com:8443/lg/"},
+		{Name: "gamma", URL: "unix:///run/bird-lgproxy.sock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, servers, []string{"alpha", "beta", "gamma"})
+	assert.Equal(t, display, []string{"Alpha", "beta", "gamma"})
+	assert.Equal(t, configs["beta"].baseURL, "https://beta.example.com:8443/lg")
+	assert.Equal(t, configs["gamma"].baseURL, "http://localhost")
+	assert.Equal(t, configs["gamma"].socketPath, "/run/bird-lgproxy.sock")
+}
+
+func TestParseServerConfigsInvalid(t *testing.T) {
+	for _, configs := range [][]serverConfig{
+		{{Display: "No name"}},
+		{{Name: "alpha+beta"}},
+		{{Name: "alpha"}, {Name: "alpha"}},
+		{{Name: "alpha", URL: "ftp://alpha"}},
+		{{Name: "alpha", URL: "http://"}},
+		{{Name: "alpha", URL: "unix://"}},
+		{{Name: "alpha", URL: "http://alpha", Port: 8000}},
+		{{Name: "alpha", URL: "http://alpha", TLS: true}},
+	} {
+		if _, _, _, err := parseServerConfigs(configs); err == nil {
+			t.Errorf("Should fail to parse %+v", configs)
+		}
+	}
+}
+
+func TestParseServersFromConfigFile(t *testing.T) {
+	resetFlags()
+	defer resetFlags()
+	defer func() { setting.serverConfigs = nil }()
+
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+servers:
+  - name: alpha
+    display: Alpha
+    port: 9000
+    tls: true
+    group: europe
+    location: Frankfurt, DE
+    endpoints: [bird, ping]
+  - name: beta
+    url: unix:///run/bird-lgproxy.sock
+    secret: beta-secret
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := parseServers(viper.Get("servers")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, setting.servers, []string{"alpha", "beta"})
+	assert.Equal(t, setting.serversDisplay, []string{"Alpha", "beta"})
+
+	alpha := setting.serverConfigs["alpha"]
+	assert.Equal(t, alpha.Port, 9000)
+	assert.Equal(t, alpha.TLS, true)
+	assert.Equal(t, alpha.Group, "europe")
+	assert.Equal(t, alpha.Location, "Frankfurt, DE")
+	assert.Equal(t, alpha.Endpoints, []string{"bird", "ping"})
+
+	beta := setting.serverConfigs["beta"]
+	assert.Equal(t, beta.socketPath, "/run/bird-lgproxy.sock")
+	assert.Equal(t, beta.Secret, "beta-secret")
+}
+
+func TestParseServersLegacy(t *testing.T) {
+	if err := parseServers("Alpha<alpha>,beta"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, setting.servers, []string{"alpha", "beta"})
+	assert.Equal(t, setting.serversDisplay, []string{"Alpha", "beta"})
+	if setting.serverConfigs != nil {
+		t.Error("Legacy servers should have no server configs")
+	}
+}
+
+func TestProxyURLServerConfig(t *testing.T) {
+	setting.domain = "example.com"
+	setting.proxyPort = 8000
+	setting.proxyTLS = false
+	defer func() { setting.domain = "" }()
+
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha"},
+		{Name: "beta", Port: 9000, TLS: true},
+		{Name: "gamma", URL: "https://lg.example.org/gamma"},
+		{Name: "delta", URL: "unix:///run/bird-lgproxy.sock"},
+	})
+
+	assert.Equal(t, proxyURL("alpha", "bird", "show status", ""), "http://alpha.example.com:8000/bird?q=show+status")
+	assert.Equal(t, proxyURL("beta", "bird", "show status", ""), "https://beta.example.com:9000/bird?q=show+status")
+	assert.Equal(t, proxyURL("gamma", "bird", "show status", ""), "https://lg.example.org/gamma/bird?q=show+status")
+	assert.Equal(t, proxyURL("delta", "bird", "show status", ""), "http://localhost/bird?q=show+status")
+}
+
+func TestServerSecret(t *testing.T) {
+	setting.proxySecret = "global"
+	defer func() { setting.proxySecret = "" }()
+
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha"},
+		{Name: "beta", Secret: "beta"},
+	})
+
+	assert.Equal(t, serverSecret("alpha"), "global")
+	assert.Equal(t, serverSecret("beta"), "beta")
+}
+
+func TestBatchRequestEndpointDisabled(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q=show+status", httpmock.NewStringResponder(200, "Mock Result"))
+
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha", Endpoints: []string{"bird"}},
+	})
+
+	response := batchRequest(context.Background(), setting.servers, "bird", "show status")
+	assert.Equal(t, response[0], "Mock Result")
+
+	response = batchRequest(context.Background(), setting.servers, "traceroute", "1.1.1.1")
+	assert.Equal(t, response[0], "request failed: traceroute is not enabled on this server\n")
+	assert.Equal(t, httpmock.GetTotalCallCount(), 1)
+}
+
+func TestBatchRequestServerSecret(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	verifier := reqsign.NewVerifier([]byte("alpha-secret"), 0)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q=show+status", func(r *http.Request) (*http.Response, error) {
+		if err := verifier.Verify(r); err != nil {
+			return httpmock.NewStringResponse(http.StatusForbidden, err.Error()), nil
+		}
+		return httpmock.NewStringResponse(http.StatusOK, "Mock Result"), nil
+	})
+
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha", Secret: "alpha-secret"},
+	})
+
+	response := batchRequest(context.Background(), setting.servers, "bird", "show status")
+	assert.Equal(t, response[0], "Mock Result")
+}
+
+func TestBatchRequestUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "proxy.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Mock Response " + r.URL.Path + " " + r.URL.Query().Get("q")))
+	}))
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha", URL: "unix://" + socketPath},
+	})
+
+	response := batchRequest(context.Background(), setting.servers, "bird", "show status")
+	assert.Equal(t, response[0], "Mock Response /bird show status")
+}
+
+// Use server groups in tests, reset afterwards
+func useServerGroups(t *testing.T, groups map[string][]string) {
+	serverGroups, err := buildServerGroups(setting.servers, setting.serverConfigs, groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting.serverGroups = serverGroups
+	t.Cleanup(func() { setting.serverGroups = nil })
+}
+
+func TestBuildServerGroups(t *testing.T) {
+	useServerConfigs(t, []serverConfig{
+		{Name: "alpha", Group: "eu"},
+		{Name: "beta", Group: "us"},
+		{Name: "gamma", Group: "eu"},
+		{Name: "delta"},
+	})
+
+	groups, err := buildServerGroups(setting.servers, setting.serverConfigs, map[string][]string{
+		"core": {"alpha+delta"},
+		"eu":   {"delta", "alpha"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, groups, []serverGroup{
+		{name: "eu", servers: []string{"alpha", "gamma", "delta"}},
+		{name: "us", servers: []string{"beta"}},
+		{name: "core", servers: []string{"alpha", "delta"}},
+	})
+}
+
+func TestBuildServerGroupsInvalid(t *testing.T) {
+	servers := []string{"alpha", "beta"}
+	for _, groups := range []map[string][]string{
+		{"eu": {"gamma"}},
+		{"eu+us": {"alpha"}},
+		{"": {"alpha"}},
+	} {
+		if _, err := buildServerGroups(servers, nil, groups); err == nil {
+			t.Errorf("Should fail to build %v", groups)
+		}
+	}
+}
+
+func TestExpandServers(t *testing.T) {
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	useServerGroups(t, map[string][]string{
+		"eu": {"alpha", "beta"},
+	})
+
+	assert.Equal(t, parseServersParam("@eu"), []string{"alpha", "beta"})
+	assert.Equal(t, parseServersParam("gamma+@eu+alpha"), []string{"gamma", "alpha", "beta"})
+	assert.Equal(t, parseServersParam("@unknown"), []string{"@unknown"})
+	assert.Equal(t, expandServers([]string{"@eu", "gamma"}), []string{"alpha", "beta", "gamma"})
+}
+
+func TestWebBackendCommunicatorServerGroup(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q=show+status", httpmock.NewStringResponder(200, "Alpha Result\n"))
+	httpmock.RegisterResponder("GET", "http://beta:8000/bird?q=show+status", httpmock.NewStringResponder(200, "Beta Result\n"))
+
+	initSettings()
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	setting.serversDisplay = []string{"Alpha", "Beta", "Gamma"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useServerGroups(t, map[string][]string{
+		"eu": {"alpha", "beta"},
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/generic/@eu/status", nil)
+	w := httptest.NewRecorder()
+	webBackendCommunicator("bird", "generic")(w, r)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "Alpha Result") || !strings.Contains(body, "Beta Result") {
+		t.Errorf("Results of group members not rendered: %s", body)
+	}
+	assert.Equal(t, httpmock.GetTotalCallCount(), 2)
+
+	// Group rendered as a dropdown, with gamma still in the navigation bar
+	if !strings.Contains(body, `href="/generic/@eu/status">All in eu</a>`) {
+		t.Error("Group dropdown not rendered")
+	}
+	if !strings.Contains(body, `href="/generic/gamma/status">Gamma</a>`) {
+		t.Error("Ungrouped server not rendered")
+	}
+	if strings.Count(body, `href="/generic/alpha/status"`) != 1 {
+		t.Error("Grouped server should only be rendered in the dropdown")
+	}
+}
+
+func TestApiHandlerServerGroup(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response"))
+	httpmock.RegisterResponder("GET", "http://beta:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response"))
+
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useServerGroups(t, map[string][]string{
+		"eu": {"alpha", "beta"},
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/", strings.NewReader(`{"servers":["@eu"],"type":"ping","args":"1.1.1.1"}`))
+	w := httptest.NewRecorder()
+	apiHandler(w, r)
+
+	var response struct {
+		Result []apiGenericResultPair `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(response.Result), 2)
+	assert.Equal(t, response.Result[0].Server, "alpha")
+	assert.Equal(t, response.Result[1].Server, "beta")
+}
+
+func TestWebHandlerTelegramBotServerGroup(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response"))
+	httpmock.RegisterResponder("GET", "http://beta:8000/ping?q=1.1.1.1", httpmock.NewStringResponder(200, "Mock Response"))
+
+	setting.servers = []string{"alpha", "beta", "gamma"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+	useServerGroups(t, map[string][]string{
+		"eu": {"alpha", "beta"},
+	})
+
+	response := mockTelegramEndpointCall(t, "/telegram/@eu", "/ping 1.1.1.1", false)
+	assert.Equal(t, response, "```\nalpha\nMock Response\n\nbeta\nMock Response\n```")
+}
@@ -3,32 +3,50 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
 )
 
 type viperSettingType struct {
-	Servers           string   `mapstructure:"servers"`
-	Domain            string   `mapstructure:"domain"`
-	ProxyPort         int      `mapstructure:"proxy_port"`
-	WhoisServer       string   `mapstructure:"whois"`
-	Listen            []string `mapstructure:"listen"`
-	DNSInterface      string   `mapstructure:"dns_interface"`
-	NetSpecificMode   string   `mapstructure:"net_specific_mode"`
-	TitleBrand        string   `mapstructure:"title_brand"`
-	NavBarBrand       string   `mapstructure:"navbar_brand"`
-	NavBarBrandURL    string   `mapstructure:"navbar_brand_url"`
-	NavBarAllServer   string   `mapstructure:"navbar_all_servers"`
-	NavBarAllURL      string   `mapstructure:"navbar_all_url"`
-	BgpmapInfo        string   `mapstructure:"bgpmap_info"`
-	TelegramBotName   string   `mapstructure:"telegram_bot_name"`
-	ProtocolFilter    string   `mapstructure:"protocol_filter"`
-	NameFilter        string   `mapstructure:"name_filter"`
-	TimeOut           int      `mapstructure:"timeout"`
-	ConnectionTimeOut int      `mapstructure:"connection_timeout"`
-	TrustProxyHeaders bool     `mapstructure:"trust_proxy_headers"`
-	Vrf               string   `mapstructure:"vrf"`
+	Servers            interface{}         `mapstructure:"servers"`
+	Domain             string              `mapstructure:"domain"`
+	ProxyPort          int                 `mapstructure:"proxy_port"`
+	WhoisServer        string              `mapstructure:"whois"`
+	Listen             []string            `mapstructure:"listen"`
+	DNSInterface       string              `mapstructure:"dns_interface"`
+	NetSpecificMode    string              `mapstructure:"net_specific_mode"`
+	TitleBrand         string              `mapstructure:"title_brand"`
+	NavBarBrand        string              `mapstructure:"navbar_brand"`
+	NavBarBrandURL     string              `mapstructure:"navbar_brand_url"`
+	NavBarAllServer    string              `mapstructure:"navbar_all_servers"`
+	NavBarAllURL       string              `mapstructure:"navbar_all_url"`
+	BgpmapInfo         string              `mapstructure:"bgpmap_info"`
+	TelegramBotName    string              `mapstructure:"telegram_bot_name"`
+	TelegramSecret     string              `mapstructure:"telegram_secret_token"`
+	ProtocolFilter     string              `mapstructure:"protocol_filter"`
+	NameFilter         string              `mapstructure:"name_filter"`
+	SummaryRouteCounts bool                `mapstructure:"summary_route_counts"`
+	TimeOut            int                 `mapstructure:"timeout"`
+	ConnectionTimeOut  int                 `mapstructure:"connection_timeout"`
+	TrustProxyHeaders  bool                `mapstructure:"trust_proxy_headers"`
+	Vrf                string              `mapstructure:"vrf"`
+	MaxResponseSize    int                 `mapstructure:"max_response_size"`
+	ProxyTLS           bool                `mapstructure:"proxy_tls"`
+	ProxyTLSServers    string              `mapstructure:"proxy_tls_servers"`
+	ProxyTLSCA         string              `mapstructure:"proxy_tls_ca"`
+	ProxyTLSCert       string              `mapstructure:"proxy_tls_cert"`
+	ProxyTLSKey        string              `mapstructure:"proxy_tls_key"`
+	ProxySecret        string              `mapstructure:"proxy_secret"`
+	ServerGroups       map[string][]string `mapstructure:"server_groups"`
+	CacheTTL           map[string]string   `mapstructure:"cache_ttl"`
+	CacheSize          int                 `mapstructure:"cache_size"`
+	Metrics            bool                `mapstructure:"metrics"`
+	StatusInterval     int                 `mapstructure:"status_interval"`
+	RateLimit          map[string]string   `mapstructure:"rate_limit"`
+	Auth               authConfig          `mapstructure:"auth"`
 }
 
 // Parse settings with viper, and convert to legacy setting format
@@ -50,6 +68,9 @@ func parseSettings() {
 	pflag.String("servers", "", "server name prefixes, separated by comma")
 	viper.BindPFlag("servers", pflag.Lookup("servers"))
 
+	pflag.StringToString("server-groups", nil, "server groups queried together with @name, in the form name=server1+server2, separated by comma")
+	viper.BindPFlag("server_groups", pflag.Lookup("server-groups"))
+
 	pflag.String("domain", "", "server name domain suffixes")
 	viper.BindPFlag("domain", pflag.Lookup("domain"))
 
@@ -89,6 +110,9 @@ func parseSettings() {
 	pflag.String("telegram-bot-name", "", "telegram bot name (used to filter @bot commands)")
 	viper.BindPFlag("telegram_bot_name", pflag.Lookup("telegram-bot-name"))
 
+	pflag.String("telegram-secret-token", "", "secret token set with setWebhook, required in webhook requests from Telegram")
+	viper.BindPFlag("telegram_secret_token", pflag.Lookup("telegram-secret-token"))
+
 	pflag.String("protocol-filter", "",
 		"protocol types to show in summary tables (comma separated list); defaults to all if not set")
 	viper.BindPFlag("protocol_filter", pflag.Lookup("protocol-filter"))
@@ -96,6 +120,9 @@ func parseSettings() {
 	pflag.String("name-filter", "", "protocol name regex to hide in summary tables (RE2 syntax); defaults to none if not set")
 	viper.BindPFlag("name_filter", pflag.Lookup("name-filter"))
 
+	pflag.Bool("summary-route-counts", false, "show route counts of each channel in summary tables, from the output of \"show protocols all\"")
+	viper.BindPFlag("summary_route_counts", pflag.Lookup("summary-route-counts"))
+
 	pflag.Int("time-out", 120, "time before backend HTTP request times out, in seconds; defaults to 120 if not set")
 	viper.BindPFlag("timeout", pflag.Lookup("time-out"))
 
@@ -108,6 +135,44 @@ func parseSettings() {
 	pflag.String("vrf", "", "VRF device to bind TCP sockets to (Linux only)")
 	viper.BindPFlag("vrf", pflag.Lookup("vrf"))
 
+	pflag.Int("max-response-size", 65536, "max size of response from each backend, in bytes; longer outputs are truncated with a notice")
+	viper.BindPFlag("max_response_size", pflag.Lookup("max-response-size"))
+
+	pflag.Bool("proxy-tls", false, "connect to bird-lgproxy on all servers with HTTPS")
+	viper.BindPFlag("proxy_tls", pflag.Lookup("proxy-tls"))
+
+	pflag.String("proxy-tls-servers", "", "servers to connect with HTTPS when proxy-tls is not set, separated by comma")
+	viper.BindPFlag("proxy_tls_servers", pflag.Lookup("proxy-tls-servers"))
+
+	pflag.String("proxy-tls-ca", "", "CA certificate file to verify bird-lgproxy certificates; system CAs are used if not set")
+	viper.BindPFlag("proxy_tls_ca", pflag.Lookup("proxy-tls-ca"))
+
+	pflag.String("proxy-tls-cert", "", "client certificate file presented to bird-lgproxy")
+	viper.BindPFlag("proxy_tls_cert", pflag.Lookup("proxy-tls-cert"))
+
+	pflag.String("proxy-tls-key", "", "private key file of proxy-tls-cert")
+	viper.BindPFlag("proxy_tls_key", pflag.Lookup("proxy-tls-key"))
+
+	pflag.String("proxy-secret", "", "shared secret to sign requests to bird-lgproxy, must match the secret setting of the proxies")
+	viper.BindPFlag("proxy_secret", pflag.Lookup("proxy-secret"))
+
+	pflag.StringToString("cache-ttl", map[string]string{},
+		"how long results are cached for each category (summary, route, bird, traceroute, ping, whois), e.g. summary=10s,route=30s; not cached if not set or 0")
+	viper.BindPFlag("cache_ttl", pflag.Lookup("cache-ttl"))
+
+	pflag.Int("cache-size", 1024, "max number of results in the cache, least recently used ones are evicted first; unlimited if 0")
+	viper.BindPFlag("cache_size", pflag.Lookup("cache-size"))
+
+	pflag.Bool("metrics", false, "expose Prometheus metrics on /metrics")
+	viper.BindPFlag("metrics", pflag.Lookup("metrics"))
+
+	pflag.Int("status-interval", 60, "check the health of all servers in the background every this many seconds, for the status page; servers are checked on each request if 0")
+	viper.BindPFlag("status_interval", pflag.Lookup("status-interval"))
+
+	pflag.StringToString("rate-limit", nil,
+		"max requests of each client IP for each category (bird, traceroute, ping, whois), e.g. bird=30/1m,traceroute=5/1m; each server queried counts as a request; not limited if not set")
+	viper.BindPFlag("rate_limit", pflag.Lookup("rate-limit"))
+
 	pflag.Parse()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -119,15 +184,13 @@ func parseSettings() {
 		panic(err)
 	}
 
-	setting.servers = strings.Split(viperSettings.Servers, ",")
-	setting.serversDisplay = strings.Split(viperSettings.Servers, ",")
-	// Split server names of the form "DisplayName<Hostname>"
-	for i, server := range setting.servers {
-		pos := strings.Index(server, "<")
-		if pos != -1 {
-			setting.serversDisplay[i] = server[0:pos]
-			setting.servers[i] = server[pos+1 : len(server)-1]
-		}
+	if err := parseServers(viperSettings.Servers); err != nil {
+		panic(err)
+	}
+	var err error
+	setting.serverGroups, err = buildServerGroups(setting.servers, setting.serverConfigs, viperSettings.ServerGroups)
+	if err != nil {
+		panic(err)
 	}
 
 	setting.domain = viperSettings.Domain
@@ -148,6 +211,7 @@ func parseSettings() {
 	setting.navBarAllURL = viperSettings.NavBarAllURL
 	setting.bgpmapInfo = viperSettings.BgpmapInfo
 	setting.telegramBotName = viperSettings.TelegramBotName
+	setting.telegramSecret = viperSettings.TelegramSecret
 
 	if viperSettings.ProtocolFilter != "" {
 		setting.protocolFilter = strings.Split(viperSettings.ProtocolFilter, ",")
@@ -156,10 +220,80 @@ func parseSettings() {
 	}
 
 	setting.nameFilter = viperSettings.NameFilter
+	setting.summaryRouteCounts = viperSettings.SummaryRouteCounts
 	setting.timeOut = viperSettings.TimeOut
 	setting.connectionTimeOut = viperSettings.ConnectionTimeOut
 	setting.trustProxyHeaders = viperSettings.TrustProxyHeaders
 	setting.vrf = viperSettings.Vrf
+	setting.maxResponseSize = viperSettings.MaxResponseSize
+
+	setting.proxyTLS = viperSettings.ProxyTLS
+	if viperSettings.ProxyTLSServers != "" {
+		setting.proxyTLSServers = strings.Split(viperSettings.ProxyTLSServers, ",")
+	} else {
+		setting.proxyTLSServers = []string{}
+	}
+
+	setting.proxySecret = viperSettings.ProxySecret
 
-	fmt.Printf("%#v\n", setting)
+	setting.cacheTTL, err = parseCacheTTL(viperSettings.CacheTTL)
+	if err != nil {
+		panic(err)
+	}
+	requestCache = newResultCache(viperSettings.CacheSize)
+
+	setting.metrics = viperSettings.Metrics
+	setting.statusInterval = time.Duration(viperSettings.StatusInterval) * time.Second
+
+	setting.rateLimits, err = ratelimit.ParseLimits(viperSettings.RateLimit, rateLimitCategories)
+	if err != nil {
+		panic(err)
+	}
+
+	setting.auth, err = parseAuthConfig(viperSettings.Auth)
+	if err != nil {
+		panic(err)
+	}
+
+	setting.proxyTLSConfig, err = loadProxyTLSConfig(viperSettings.ProxyTLSCA, viperSettings.ProxyTLSCert, viperSettings.ProxyTLSKey)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%#v\n", redactedSettings(setting))
+}
+
+// Copy of settings with secrets replaced, for printing to logs
+func redactedSettings(s settingType) settingType {
+	if s.proxySecret != "" {
+		s.proxySecret = "<redacted>"
+	}
+	if s.telegramSecret != "" {
+		s.telegramSecret = "<redacted>"
+	}
+	return s
+}
+
+// Parse the servers setting, either in the legacy comma separated form, or as
+// a list of server configs in the config file
+func parseServers(servers interface{}) error {
+	switch servers := servers.(type) {
+	case nil:
+		setting.servers, setting.serversDisplay = parseLegacyServers("")
+		setting.serverConfigs = nil
+	case string:
+		setting.servers, setting.serversDisplay = parseLegacyServers(servers)
+		setting.serverConfigs = nil
+	default:
+		var configs []serverConfig
+		if err := viper.UnmarshalKey("servers", &configs); err != nil {
+			return err
+		}
+		var err error
+		setting.servers, setting.serversDisplay, setting.serverConfigs, err = parseServerConfigs(configs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
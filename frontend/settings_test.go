@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strings"
 	"testing"
@@ -537,4 +538,24 @@ func TestParseSettings(t *testing.T) {
 	resetFlags()
 	parseSettings()
 	resetFlags()
+
+	if len(setting.cacheTTL) != 0 {
+		t.Errorf("Results should not be cached by default: %v", setting.cacheTTL)
+	}
+	// Don't cache results in other tests
+	setting.cacheTTL = nil
+}
+
+func TestRedactedSettings(t *testing.T) {
+	s := settingType{proxySecret: "hmac-secret", telegramSecret: "telegram-secret", domain: "example.com"}
+	printed := fmt.Sprintf("%#v", redactedSettings(s))
+	if strings.Contains(printed, "hmac-secret") || strings.Contains(printed, "telegram-secret") {
+		t.Errorf("Secrets printed: %s", printed)
+	}
+	if redactedSettings(s).domain != "example.com" {
+		t.Error("Other settings should be kept")
+	}
+	if s.proxySecret != "hmac-secret" {
+		t.Error("Settings should not be modified")
+	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max time to wait for the health check of a server
+const statusCheckTimeout = 10 * time.Second
+
+// Response of /healthz of lgproxy
+type proxyHealthResponse struct {
+	Status string `json:"status"`
+	Bird   struct {
+		OK       bool   `json:"ok"`
+		Version  string `json:"version"`
+		RouterID string `json:"router_id"`
+		Error    string `json:"error"`
+	} `json:"bird"`
+	Traceroute struct {
+		OK bool `json:"ok"`
+	} `json:"traceroute"`
+	Ping struct {
+		OK bool `json:"ok"`
+	} `json:"ping"`
+}
+
+// Status of a server, from the health check of its lgproxy
+type serverStatus struct {
+	Server string `json:"server"`
+	// lgproxy responded to the health check
+	Reachable bool `json:"reachable"`
+	// bird is usable on the server
+	Healthy     bool    `json:"healthy"`
+	LatencyMs   float64 `json:"latency_ms"`
+	BirdVersion string  `json:"bird_version,omitempty"`
+	RouterID    string  `json:"router_id,omitempty"`
+	Traceroute  bool    `json:"traceroute"`
+	Ping        bool    `json:"ping"`
+	Error       string  `json:"error,omitempty"`
+	// Time of the health check
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Check the health of lgproxy on a server
+func statusCheck(ctx context.Context, server string) serverStatus {
+	ctx, cancel := context.WithTimeout(ctx, statusCheckTimeout)
+	defer cancel()
+
+	status := serverStatus{Server: server, CheckedAt: time.Now()}
+	if !isValidServer(server) {
+		status.Error = "invalid server"
+		return status
+	}
+
+	start := time.Now()
+	response, err := proxyGet(ctx, server, proxyBaseURL(server)+"/healthz")
+	if err != nil {
+		observeProxyRequest(server, "healthz", start, err)
+		status.Error = "request failed: " + err.Error()
+		return status
+	}
+	defer response.Body.Close()
+
+	var health proxyHealthResponse
+	err = json.NewDecoder(io.LimitReader(response.Body, int64(maxResponseSize()))).Decode(&health)
+	observeProxyRequest(server, "healthz", start, err)
+	status.Reachable = true
+	status.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		// Likely an older lgproxy without health checks
+		status.Error = "unexpected response: " + response.Status
+		return status
+	}
+
+	status.Healthy = health.Status == "ok"
+	status.BirdVersion = health.Bird.Version
+	status.RouterID = health.Bird.RouterID
+	status.Traceroute = health.Traceroute.OK
+	status.Ping = health.Ping.OK
+	status.Error = health.Bird.Error
+	return status
+}
+
+// Check the health of servers in parallel
+func statusCheckAll(ctx context.Context, servers []string) []serverStatus {
+	result := make([]serverStatus, len(servers))
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result[i] = statusCheck(ctx, server)
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+// Results of the background health checks, by server
+type statusStore struct {
+	lock     sync.RWMutex
+	statuses map[string]serverStatus
+}
+
+var serverStatuses = statusStore{statuses: make(map[string]serverStatus)}
+
+func (s *statusStore) update(statuses []serverStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, status := range statuses {
+		s.statuses[status.Server] = status
+	}
+}
+
+func (s *statusStore) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statuses = make(map[string]serverStatus)
+}
+
+// Get the status of servers from the background health checks. Servers not
+// checked yet, e.g. if background checks are disabled, are checked now.
+func (s *statusStore) get(ctx context.Context, servers []string) []serverStatus {
+	result := make([]serverStatus, len(servers))
+	var missing []string
+	var missingIndex []int
+
+	s.lock.RLock()
+	for i, server := range servers {
+		status, ok := s.statuses[server]
+		if ok {
+			result[i] = status
+		} else {
+			missing = append(missing, server)
+			missingIndex = append(missingIndex, i)
+		}
+	}
+	s.lock.RUnlock()
+
+	for i, status := range statusCheckAll(ctx, missing) {
+		result[missingIndex[i]] = status
+	}
+	return result
+}
+
+// Check the health of all servers every interval, until ctx is cancelled
+func statusStart(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			serverStatuses.update(statusCheckAll(ctx, setting.servers))
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Bootstrap class of the row of a server in the status table
+func statusMappedState(status serverStatus) string {
+	switch {
+	case !status.Reachable:
+		return "danger"
+	case !status.Healthy:
+		return "warning"
+	}
+	return "success"
+}
+
+// Status page of servers
+func webHandlerStatus(w http.ResponseWriter, r *http.Request) {
+	split := strings.SplitN(r.URL.Path[1:], "/", 3)
+	servers := setting.servers
+	if len(split) >= 2 && split[1] != "" {
+		servers = parseServersParam(split[1])
+	}
+	if len(servers) > len(setting.servers) {
+		http.Error(w, "invalid request: too many servers specified", http.StatusBadRequest)
+		return
+	}
+	if rateLimited(w, r, "status", len(servers)) {
+		return
+	}
+
+	args := TemplateStatus{}
+	for i, status := range serverStatuses.get(r.Context(), servers) {
+		row := TemplateStatusRow{
+			ServerName:  serverDisplayName(servers, i),
+			Status:      status,
+			MappedState: statusMappedState(status),
+			CheckedAgo:  time.Since(status.CheckedAt).Round(time.Second).String(),
+		}
+		if status.Reachable {
+			row.Latency = strconv.FormatFloat(status.LatencyMs, 'f', 1, 64)
+		}
+		args.Rows = append(args.Rows, row)
+	}
+
+	tmpl := TemplateLibrary["status"]
+	var buffer bytes.Buffer
+	err := tmpl.Execute(&buffer, args)
+	if err != nil {
+		fmt.Println("Error rendering status template:", err.Error())
+	}
+
+	renderPageTemplate(
+		w, r,
+		" - status",
+		template.HTML(buffer.String()),
+	)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+)
+
+const mockHealthResponse = `{"status":"ok","bird":{"ok":true,"version":"2.0.8","router_id":"172.20.0.1"},"traceroute":{"ok":true,"binary":"mtr"},"ping":{"ok":false}}`
+
+func mockStatusServers(t *testing.T) {
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/healthz", httpmock.NewStringResponder(200, mockHealthResponse))
+	httpmock.RegisterResponder("GET", "http://beta:8000/healthz", httpmock.NewStringResponder(503,
+		`{"status":"error","bird":{"ok":false,"error":"dial unix /var/run/bird/bird.ctl: connect: no such file or directory"},"traceroute":{"ok":false},"ping":{"ok":false}}`))
+	httpmock.RegisterResponder("GET", "http://gamma:8000/healthz", httpmock.NewStringResponder(500, "Invalid Request\n"))
+	httpmock.RegisterResponder("GET", "http://delta:8000/healthz", httpmock.NewErrorResponder(errors.New("connection refused")))
+
+	setting.servers = []string{"alpha", "beta", "gamma", "delta"}
+	setting.serversDisplay = setting.servers
+	setting.domain = ""
+	setting.proxyPort = 8000
+	serverStatuses.reset()
+	t.Cleanup(serverStatuses.reset)
+}
+
+func TestStatusCheckAll(t *testing.T) {
+	mockStatusServers(t)
+
+	statuses := statusCheckAll(context.Background(), setting.servers)
+
+	alpha := statuses[0]
+	assert.Equal(t, alpha.Server, "alpha")
+	assert.Equal(t, alpha.Reachable, true)
+	assert.Equal(t, alpha.Healthy, true)
+	assert.Equal(t, alpha.BirdVersion, "2.0.8")
+	assert.Equal(t, alpha.RouterID, "172.20.0.1")
+	assert.Equal(t, alpha.Traceroute, true)
+	assert.Equal(t, alpha.Ping, false)
+	assert.Equal(t, alpha.Error, "")
+
+	beta := statuses[1]
+	assert.Equal(t, beta.Reachable, true)
+	assert.Equal(t, beta.Healthy, false)
+	assert.Equal(t, beta.Error, "dial unix /var/run/bird/bird.ctl: connect: no such file or directory")
+
+	// lgproxy without health checks
+	gamma := statuses[2]
+	assert.Equal(t, gamma.Reachable, true)
+	assert.Equal(t, gamma.Healthy, false)
+	assert.Equal(t, gamma.Error, "unexpected response: 500 Internal Server Error")
+
+	delta := statuses[3]
+	assert.Equal(t, delta.Reachable, false)
+	if !strings.Contains(delta.Error, "connection refused") {
+		t.Errorf("Unexpected error %s", delta.Error)
+	}
+}
+
+func TestStatusCheckInvalidServer(t *testing.T) {
+	setting.servers = []string{"alpha"}
+	status := statusCheck(context.Background(), "nonexistent")
+	assert.Equal(t, status.Reachable, false)
+	assert.Equal(t, status.Error, "invalid server")
+}
+
+func TestStatusStoreGet(t *testing.T) {
+	mockStatusServers(t)
+
+	serverStatuses.update(statusCheckAll(context.Background(), []string{"alpha"}))
+	calls := httpmock.GetTotalCallCount()
+
+	// Only servers not checked in the background are checked on request
+	statuses := serverStatuses.get(context.Background(), []string{"alpha", "beta"})
+	assert.Equal(t, httpmock.GetTotalCallCount(), calls+1)
+	assert.Equal(t, statuses[0].Healthy, true)
+	assert.Equal(t, statuses[1].Server, "beta")
+	assert.Equal(t, statuses[1].Healthy, false)
+}
+
+func TestWebHandlerStatus(t *testing.T) {
+	initSettings()
+	mockStatusServers(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/status/", nil)
+	w := httptest.NewRecorder()
+	webHandlerStatus(w, r)
+
+	body := w.Body.String()
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(body, `<tr class="table-success">`) || !strings.Contains(body, "2.0.8") {
+		t.Errorf("Healthy server not shown:\n%s", body)
+	}
+	if !strings.Contains(body, `<tr class="table-warning">`) || !strings.Contains(body, "bird down") {
+		t.Errorf("Server with bird down not shown:\n%s", body)
+	}
+	if !strings.Contains(body, `<tr class="table-danger">`) || !strings.Contains(body, "unreachable") {
+		t.Errorf("Unreachable server not shown:\n%s", body)
+	}
+}
+
+func TestWebHandlerStatusSingleServer(t *testing.T) {
+	initSettings()
+	mockStatusServers(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/status/alpha/", nil)
+	w := httptest.NewRecorder()
+	webHandlerStatus(w, r)
+
+	body := w.Body.String()
+	assert.Equal(t, strings.Count(body, "<tr class="), 1)
+	assert.Equal(t, httpmock.GetCallCountInfo()["GET http://beta:8000/healthz"], 0)
+}
+
+func TestApiStatusHandler(t *testing.T) {
+	mockStatusServers(t)
+
+	response := apiStatusHandler(context.Background(), apiRequest{Type: "status"})
+	assert.Equal(t, response.Error, "")
+	assert.Equal(t, len(response.Result), 4)
+	assert.Equal(t, response.Result[0].(serverStatus).Healthy, true)
+
+	response = apiStatusHandler(context.Background(), apiRequest{Type: "status", Servers: []string{"beta"}})
+	assert.Equal(t, len(response.Result), 1)
+	assert.Equal(t, response.Result[0].(serverStatus).Server, "beta")
+}
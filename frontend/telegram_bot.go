@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
 )
 
 type tgChat struct {
@@ -29,6 +33,33 @@ type tgWebhookResponse struct {
 	ParseMode        string `json:"parse_mode"`
 }
 
+// Rate limit category of each command, limited for each chat
+var telegramRateLimitCategories = map[string]string{
+	"trace": "traceroute",
+	"ping":  "ping",
+	"route": "bird",
+	"path":  "bird",
+	"whois": "whois",
+}
+
+// Check the rate limit of the chat for the command in message. Returns the
+// message replied instead if the chat is over the limit.
+func telegramRateLimited(message string, chatID int64, servers []string) (string, bool) {
+	for command, category := range telegramRateLimitCategories {
+		if !telegramIsCommand(message, command) {
+			continue
+		}
+		cost := len(servers)
+		if category == "whois" {
+			cost = 1
+		}
+		if ok, retryAfter := rateLimitAllowKey("telegram:"+strconv.FormatInt(chatID, 10), category, cost); !ok {
+			return "Too many requests, please try again in " + retryAfter + " seconds.", true
+		}
+	}
+	return "", false
+}
+
 func telegramIsCommand(message string, command string) bool {
 	b := false
 	b = b || strings.HasPrefix(message, "/"+command+"@"+setting.telegramBotName+" ")
@@ -42,8 +73,8 @@ func telegramDefaultPostProcess(s string) string {
 	return strings.TrimSpace(s)
 }
 
-func telegramBatchRequestFormat(servers []string, endpoint string, command string, postProcess func(string) string) string {
-	results := batchRequest(servers, endpoint, command)
+func telegramBatchRequestFormat(ctx context.Context, servers []string, endpoint string, command string, postProcess func(string) string) string {
+	results := batchRequest(ctx, servers, endpoint, command)
 	result := ""
 	for i, r := range results {
 		if len(servers) > 1 {
@@ -54,7 +85,21 @@ func telegramBatchRequestFormat(servers []string, endpoint string, command strin
 	return result
 }
 
+// Check the secret token of the webhook, set with setWebhook, if configured
+func telegramSecretValid(r *http.Request) bool {
+	if setting.telegramSecret == "" {
+		return true
+	}
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(setting.telegramSecret)) == 1
+}
+
 func webHandlerTelegramBot(w http.ResponseWriter, r *http.Request) {
+	if !telegramSecretValid(r) {
+		http.Error(w, "Forbidden: invalid secret token", http.StatusForbidden)
+		return
+	}
+
 	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
 	var err error
 	var request tgWebhookRequest
@@ -78,7 +123,7 @@ func webHandlerTelegramBot(w http.ResponseWriter, r *http.Request) {
 	if len(r.URL.Path[len("/telegram/"):]) == 0 {
 		servers = setting.servers
 	} else {
-		servers = strings.Split(r.URL.Path[len("/telegram/"):], "+")
+		servers = parseServersParam(r.URL.Path[len("/telegram/"):])
 	}
 
 	// Parse target
@@ -91,19 +136,26 @@ func webHandlerTelegramBot(w http.ResponseWriter, r *http.Request) {
 	// Execute command
 	commandResult := ""
 
-	// - traceroute
-	if telegramIsCommand(request.Message.Text, "trace") {
-		commandResult = telegramBatchRequestFormat(servers, "traceroute", target, telegramDefaultPostProcess)
+	if message, limited := telegramRateLimited(request.Message.Text, request.Message.Chat.ID, servers); limited {
+		commandResult = message
+
+	} else if telegramIsCommand(request.Message.Text, "trace") {
+		// - traceroute
+		commandResult = telegramBatchRequestFormat(r.Context(), servers, "traceroute", target, telegramDefaultPostProcess)
+
+	} else if telegramIsCommand(request.Message.Text, "ping") {
+		commandResult = telegramBatchRequestFormat(r.Context(), servers, "ping", target, telegramDefaultPostProcess)
 
 	} else if telegramIsCommand(request.Message.Text, "route") {
-		commandResult = telegramBatchRequestFormat(servers, "bird", "show route for "+target+" primary", telegramDefaultPostProcess)
+		commandResult = telegramBatchRequestFormat(r.Context(), servers, "bird", "show route for "+target+" primary", telegramDefaultPostProcess)
 
 	} else if telegramIsCommand(request.Message.Text, "path") {
-		commandResult = telegramBatchRequestFormat(servers, "bird", "show route for "+target+" all primary", func(result string) string {
-			for _, s := range strings.Split(result, "\n") {
-				if strings.Contains(s, "BGP.as_path: ") || strings.Contains(s, "bgp_path: ") {
-					return strings.TrimSpace(strings.Split(s, ":")[1])
+		commandResult = telegramBatchRequestFormat(r.Context(), servers, "bird", "show route for "+target+" all primary", func(result string) string {
+			for _, route := range birdparser.ParseRoutes(result) {
+				if route.BGP == nil {
+					continue
 				}
+				return strings.Join(route.BGP.ASPathStrings(), " ")
 			}
 			return ""
 		})
@@ -132,6 +184,7 @@ func webHandlerTelegramBot(w http.ResponseWriter, r *http.Request) {
 	} else if telegramIsCommand(request.Message.Text, "help") {
 		commandResult = `
 /path <IP>
+/ping <IP>
 /route <IP>
 /trace <IP>
 /whois <Target>
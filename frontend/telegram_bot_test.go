@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -100,7 +101,7 @@ func TestTelegramBatchRequestFormatSingleServer(t *testing.T) {
 	setting.domain = ""
 	setting.proxyPort = 8000
 
-	result := telegramBatchRequestFormat(setting.servers, "mock", "cmd", telegramDefaultPostProcess)
+	result := telegramBatchRequestFormat(context.Background(), setting.servers, "mock", "cmd", telegramDefaultPostProcess)
 	expected := "Mock\n\n"
 	assert.Equal(t, result, expected)
 }
@@ -122,7 +123,7 @@ func TestTelegramBatchRequestFormatMultipleServers(t *testing.T) {
 	setting.domain = ""
 	setting.proxyPort = 8000
 
-	result := telegramBatchRequestFormat(setting.servers, "mock", "cmd", telegramDefaultPostProcess)
+	result := telegramBatchRequestFormat(context.Background(), setting.servers, "mock", "cmd", telegramDefaultPostProcess)
 	expected := "alpha\nMock\n\nbeta\nMock\n\ngamma\nMock\n\n"
 	assert.Equal(t, result, expected)
 }
@@ -168,6 +169,21 @@ func TestWebHandlerTelegramBotTraceWithServerList(t *testing.T) {
 	assert.Equal(t, response, "```\nMock Response\n```")
 }
 
+func TestWebHandlerTelegramBotPing(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Mock Response")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpResponse)
+
+	setting.servers = []string{"alpha"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	response := mockTelegramCall(t, "/ping 1.1.1.1", false)
+	assert.Equal(t, response, "```\nMock Response\n```")
+}
+
 func TestWebHandlerTelegramBotRoute(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -187,8 +203,11 @@ func TestWebHandlerTelegramBotPath(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	httpResponse := httpmock.NewStringResponder(200, `
-BGP.as_path: 123 456
+	httpResponse := httpmock.NewStringResponder(200, `Table master4:
+1.1.1.0/24           unicast [bgp1 2023-04-29] * (100) [AS456i]
+	via 192.168.0.1 on eth0
+	Type: BGP univ
+	BGP.as_path: 123 456
 `)
 	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 1.1.1.1 all primary"), httpResponse)
 
@@ -388,3 +407,27 @@ func TestWebHandlerTelegramBotRequestBodyTooLarge(t *testing.T) {
 
 	assert.Equal(t, w.Code, http.StatusRequestEntityTooLarge)
 }
+
+func TestWebHandlerTelegramBotSecretToken(t *testing.T) {
+	setting.telegramSecret = "telegram-secret"
+	defer func() { setting.telegramSecret = "" }()
+
+	request := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/telegram/", strings.NewReader(`{"message":{"text":"/help"}}`))
+		if token != "" {
+			r.Header.Set("X-Telegram-Bot-Api-Secret-Token", token)
+		}
+		w := httptest.NewRecorder()
+		webHandlerTelegramBot(w, r)
+		return w
+	}
+
+	assert.Equal(t, request("").Code, http.StatusForbidden)
+	assert.Equal(t, request("wrong-secret").Code, http.StatusForbidden)
+
+	w := request("telegram-secret")
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "/trace") {
+		t.Error("Help not returned with a valid secret token")
+	}
+}
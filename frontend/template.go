@@ -2,10 +2,15 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"html/template"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
 )
 
 // import templates and other assets
@@ -23,6 +28,9 @@ type TemplatePage struct {
 	Options        map[string]string
 	Servers        []string
 	ServersDisplay []string
+	ServerGroups   []TemplateServerGroup
+	// Servers shown in dropdowns of groups instead of the navigation bar
+	GroupedServers map[string]bool
 
 	// Parameters related to current request
 	AllServersLinkActive bool
@@ -45,6 +53,15 @@ type TemplatePage struct {
 	Content  template.HTML
 }
 
+type TemplateServerGroup struct {
+	Name           string
+	URL            string
+	Servers        []string
+	ServersDisplay []string
+	// Current page is of the group or one of its servers
+	Active bool
+}
+
 // summary
 type SummaryRowData struct {
 	Name        string `json:"name"`
@@ -54,6 +71,9 @@ type SummaryRowData struct {
 	MappedState string `json:"-"`
 	Since       string `json:"since"`
 	Info        string `json:"info"`
+	// Route counts of each channel, by the name of the channel. Only set if
+	// summary_route_counts is enabled.
+	Routes map[string]*birdparser.RouteCounts `json:"routes,omitempty"`
 }
 
 // utility functions to allow filtering of results in the template
@@ -112,19 +132,53 @@ type TemplateSummary struct {
 	Raw        string
 	Header     []string
 	Rows       []SummaryRowData
+	// Channels with route counts in any row, shown as columns
+	Channels []string
+	// Output exceeded max_response_size, so the last protocols are missing
+	Truncated bool
 }
 
 // whois
 type TemplateWhois struct {
-	Target string
-	Result template.HTML
+	Target   string
+	Result   template.HTML
+	CacheAge string
 }
 
 // bgpmap
 type TemplateBGPmap struct {
-	Servers []string
-	Target  string
-	Result  string
+	Servers  []string
+	Target   string
+	Result   string
+	CacheAge string
+}
+
+// traceroute table
+type TemplateTracerouteTable struct {
+	ServerName string
+	Target     string
+	Hops       []tracerouteparser.Hop
+	Error      string
+	CacheAge   string
+}
+
+// comparison of routes between servers
+type TemplateCompareRow struct {
+	ServerName string
+	Server     string
+	// Values of the best route, nil if there's none
+	Values     map[string]string
+	Alternates []map[string]string
+	// Fields that don't match other servers
+	Differs map[string]bool
+	Error   string
+}
+
+type TemplateCompare struct {
+	Target   string
+	Rows     []TemplateCompareRow
+	Error    string
+	CacheAge string
 }
 
 // bird
@@ -132,6 +186,20 @@ type TemplateBird struct {
 	ServerName string
 	Target     string
 	Result     template.HTML
+	CacheAge   string
+}
+
+// status
+type TemplateStatusRow struct {
+	ServerName  string
+	Status      serverStatus
+	MappedState string
+	Latency     string
+	CheckedAgo  string
+}
+
+type TemplateStatus struct {
+	Rows []TemplateStatusRow
 }
 
 // global variable to hold the templates
@@ -146,12 +214,32 @@ var requiredTemplates = [...]string{
 	"whois",
 	"bgpmap",
 	"bird",
+	"traceroute_table",
+	"compare",
+	"status",
 }
 
 // define functions to be made available in templates
 
 var funcMap = template.FuncMap{
 	"pathescape": url.PathEscape,
+	"hoprtt":     hopRTTString,
+}
+
+// Format RTT of a traceroute hop: average, best and worst for mtr, all samples for traceroute
+func hopRTTString(hop tracerouteparser.Hop) string {
+	if len(hop.RTT) == 0 {
+		return ""
+	}
+	if hop.MTR != nil {
+		return fmt.Sprintf("%.1f (best %.1f, worst %.1f)", hop.MTR.Avg, hop.MTR.Best, hop.MTR.Worst)
+	}
+
+	var samples []string
+	for _, rtt := range hop.RTT {
+		samples = append(samples, strconv.FormatFloat(rtt, 'f', -1, 64))
+	}
+	return strings.Join(samples, " / ")
 }
 
 // import templates from embedded assets
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
 )
 
 func TestSummaryRowDataNameHasPrefix(t *testing.T) {
@@ -87,3 +88,12 @@ func TestSummaryRowDataFromLineWithDash(t *testing.T) {
 	assert.Equal(t, data.Since, "07:16:51.656")
 	assert.Equal(t, data.Info, "Established")
 }
+
+func TestHopRTTString(t *testing.T) {
+	assert.Equal(t, hopRTTString(tracerouteparser.Hop{RTT: []float64{}}), "")
+	assert.Equal(t, hopRTTString(tracerouteparser.Hop{RTT: []float64{0.456, 10}}), "0.456 / 10")
+	assert.Equal(t, hopRTTString(tracerouteparser.Hop{
+		RTT: []float64{10.1},
+		MTR: &tracerouteparser.MTRStats{Avg: 10.25, Best: 10.1, Worst: 10.4},
+	}), "10.2 (best 10.1, worst 10.4)")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Build TLS config of connections to lgproxy. If caFile is set, lgproxy
+// certificates are verified against it instead of the system CAs. If
+// certFile and keyFile are set, the certificate is presented to lgproxy
+// for client certificate verification.
+func loadProxyTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("proxy_tls_cert and proxy_tls_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// Check if lgproxy on the server should be connected with HTTPS
+func proxyUsesTLS(server string) bool {
+	if setting.proxyTLS {
+		return true
+	}
+	if config := serverConfigOf(server); config != nil && config.TLS {
+		return true
+	}
+	for _, tlsServer := range setting.proxyTLSServers {
+		if tlsServer == server {
+			return true
+		}
+	}
+	return false
+}
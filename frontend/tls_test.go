@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func writeTestPEM(t *testing.T, path string, blockType string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Generate a certificate signed by parent, or a self signed CA if parent is nil.
+// Files are written to dir/name.crt and dir/name.key in PEM format.
+func generateTestCert(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestPEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writeTestPEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+// Generate a CA, and server and client certificates signed by it
+func generateTestCerts(t *testing.T) string {
+	dir := t.TempDir()
+	ca, caKey := generateTestCert(t, dir, "ca", &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	generateTestCert(t, dir, "server", &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca, caKey)
+	generateTestCert(t, dir, "client", &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	return dir
+}
+
+// Start a mock lgproxy with HTTPS, which requires client certificates signed by the CA
+func startTLSProxy(t *testing.T, dir string) int {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, err := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Mock Response"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server.Listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestLoadProxyTLSConfig(t *testing.T) {
+	dir := generateTestCerts(t)
+
+	config, err := loadProxyTLSConfig(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(config.Certificates), 1)
+	if config.RootCAs == nil {
+		t.Error("CA not loaded")
+	}
+
+	// System CAs and no client certificate
+	config, err = loadProxyTLSConfig("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(config.Certificates), 0)
+	if config.RootCAs != nil {
+		t.Error("System CAs should be used")
+	}
+}
+
+func TestLoadProxyTLSConfigInvalid(t *testing.T) {
+	dir := generateTestCerts(t)
+
+	for _, files := range [][3]string{
+		{"/nonexistent", "", ""},
+		{filepath.Join(dir, "client.key"), "", ""},
+		{"", filepath.Join(dir, "client.crt"), ""},
+		{"", "", filepath.Join(dir, "client.key")},
+		{"", filepath.Join(dir, "client.crt"), filepath.Join(dir, "server.key")},
+	} {
+		if _, err := loadProxyTLSConfig(files[0], files[1], files[2]); err == nil {
+			t.Errorf("Should fail to load %q", files)
+		}
+	}
+}
+
+func TestProxyURLTLS(t *testing.T) {
+	setting.domain = ""
+	setting.proxyPort = 8000
+	setting.proxyTLS = false
+	setting.proxyTLSServers = []string{"beta"}
+	defer func() { setting.proxyTLSServers = nil }()
+
+	assert.Equal(t, proxyURL("alpha", "bird", "show status", ""), "http://alpha:8000/bird?q=show+status")
+	assert.Equal(t, proxyURL("beta", "bird", "show status", ""), "https://beta:8000/bird?q=show+status")
+
+	setting.proxyTLS = true
+	defer func() { setting.proxyTLS = false }()
+	assert.Equal(t, proxyURL("alpha", "bird", "show status", ""), "https://alpha:8000/bird?q=show+status")
+}
+
+func TestBatchRequestMutualTLS(t *testing.T) {
+	dir := generateTestCerts(t)
+	port := startTLSProxy(t, dir)
+
+	setting.servers = []string{"127.0.0.1"}
+	setting.domain = ""
+	setting.proxyPort = port
+	setting.proxyTLS = true
+	defer func() {
+		setting.proxyTLS = false
+		setting.proxyTLSConfig = nil
+	}()
+
+	var err error
+	setting.proxyTLSConfig, err = loadProxyTLSConfig(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := batchRequest(context.Background(), setting.servers, "bird", "show status")
+	assert.Equal(t, response[0], "Mock Response")
+
+	// Rejected by the proxy without a client certificate
+	setting.proxyTLSConfig, err = loadProxyTLSConfig(filepath.Join(dir, "ca.crt"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = batchRequest(context.Background(), setting.servers, "bird", "show status")
+	if !strings.HasPrefix(response[0], "request failed") {
+		t.Errorf("Request without client certificate should fail, got %q", response[0])
+	}
+
+	// Proxy certificate not trusted without the CA
+	setting.proxyTLSConfig, err = loadProxyTLSConfig("", filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = batchRequest(context.Background(), setting.servers, "bird", "show status")
+	if !strings.HasPrefix(response[0], "request failed") {
+		t.Errorf("Request to untrusted proxy should fail, got %q", response[0])
+	}
+}
+
+func TestBatchRequestPlainHTTPWithTLSConfig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Mock Response"))
+	}))
+	defer server.Close()
+
+	// Servers not in proxy_tls_servers still use plain HTTP
+	setting.servers = []string{"127.0.0.1"}
+	setting.domain = ""
+	setting.proxyPort = server.Listener.Addr().(*net.TCPAddr).Port
+	setting.proxyTLS = false
+	setting.proxyTLSServers = []string{"alpha"}
+	defer func() { setting.proxyTLSServers = nil }()
+
+	response := batchRequest(context.Background(), setting.servers, "bird", "show status")
+	assert.Equal(t, response[0], "Mock Response")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
+)
+
+// Structured traceroute result returned by lgproxy
+type tracerouteProxyResponse struct {
+	Format string                 `json:"format"`
+	Hops   []tracerouteparser.Hop `json:"hops"`
+	Raw    string                 `json:"raw"`
+	Error  string                 `json:"error"`
+
+	// Time the result was cached, zero if fresh
+	cachedAt time.Time
+	// Error of the request to lgproxy, see batchRequestCached
+	err error
+}
+
+// Run traceroute on lgproxy instances, and retrieve the parsed hops
+func tracerouteHopsRequest(ctx context.Context, servers []string, target string) []tracerouteProxyResponse {
+	results, cachedAt, errs := batchRequestCached(ctx, servers, "traceroute", target, "json")
+
+	responses := make([]tracerouteProxyResponse, len(results))
+	for i, result := range results {
+		if err := json.Unmarshal([]byte(result), &responses[i]); err != nil {
+			// Likely request failed, or lgproxy doesn't support JSON output
+			responses[i] = tracerouteProxyResponse{
+				Error: strings.TrimSpace(result),
+			}
+		}
+		if responses[i].Hops == nil {
+			responses[i].Hops = []tracerouteparser.Hop{}
+		}
+		responses[i].cachedAt = cachedAt[i]
+		responses[i].err = errs[i]
+	}
+	return responses
+}
+
+// traceroute result as tables
+func webHandlerTracerouteTable(w http.ResponseWriter, r *http.Request) {
+	split := strings.SplitN(r.URL.Path[1:], "/", 3)
+	var target string
+	if len(split) >= 3 {
+		target = strings.TrimSpace(split[2])
+	}
+
+	servers := parseServersParam(split[1])
+	if rateLimited(w, r, "traceroute", len(servers)) {
+		return
+	}
+	responses := tracerouteHopsRequest(r.Context(), servers, target)
+
+	var content string
+	for i, response := range responses {
+		// render the traceroute table template
+		args := TemplateTracerouteTable{
+			ServerName: serverDisplayName(servers, i),
+			Target:     target,
+			Hops:       response.Hops,
+			Error:      response.Error,
+			CacheAge:   cacheAgeText(response.cachedAt),
+		}
+
+		tmpl := TemplateLibrary["traceroute_table"]
+		var buffer bytes.Buffer
+		err := tmpl.Execute(&buffer, args)
+		if err != nil {
+			fmt.Println("Error rendering traceroute table template:", err.Error())
+		}
+
+		content += buffer.String()
+	}
+
+	renderPageTemplate(
+		w, r,
+		" - traceroute "+target,
+		template.HTML(content),
+	)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/magiconair/properties/assert"
+)
+
+const tracerouteJSONResult = `{"target":"1.1.1.1","format":"traceroute","hops":[` +
+	`{"hop":1,"address":"192.168.1.1","hostname":"_gateway","rtt":[0.456],"loss":0},` +
+	`{"hop":2,"rtt":[],"loss":100},` +
+	`{"hop":3,"address":"1.1.1.1","hostname":"one.one.one.one","asn":13335,"rtt":[10.1,10.2],"loss":0}` +
+	`],"raw":""}`
+
+func TestTracerouteHopsRequest(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/traceroute?q=1.1.1.1&format=json", httpmock.NewStringResponder(200, tracerouteJSONResult))
+	httpmock.RegisterResponder("GET", "http://beta:8000/traceroute?q=1.1.1.1&format=json", httpmock.NewStringResponder(200, "traceroute not supported on this node.\n"))
+
+	setting.servers = []string{"alpha", "beta"}
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	responses := tracerouteHopsRequest(context.Background(), setting.servers, "1.1.1.1")
+
+	assert.Equal(t, len(responses), 2)
+	assert.Equal(t, responses[0].Format, "traceroute")
+	assert.Equal(t, len(responses[0].Hops), 3)
+	assert.Equal(t, responses[0].Hops[2].ASN, uint32(13335))
+	assert.Equal(t, responses[0].Error, "")
+
+	// Responses that are not JSON are reported as errors
+	assert.Equal(t, len(responses[1].Hops), 0)
+	assert.Equal(t, responses[1].Error, "traceroute not supported on this node.")
+}
+
+func TestWebHandlerTracerouteTable(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("GET", "http://alpha:8000/traceroute?q=1.1.1.1&format=json", httpmock.NewStringResponder(200, tracerouteJSONResult))
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	r := httptest.NewRequest(http.MethodGet, "/traceroute_table/alpha/1.1.1.1", nil)
+	w := httptest.NewRecorder()
+	webHandlerTracerouteTable(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	body := w.Body.String()
+	if !strings.Contains(body, "<h2>alpha: traceroute 1.1.1.1</h2>") {
+		t.Error("Traceroute table header not rendered")
+	}
+	if !strings.Contains(body, `<a href="/whois/AS13335" class="whois">AS13335</a>`) {
+		t.Error("ASN not rendered")
+	}
+	if !strings.Contains(body, "10.1 / 10.2") {
+		t.Error("RTT not rendered")
+	}
+	if !strings.Contains(body, `class="table-secondary"`) {
+		t.Error("Hop not responding not highlighted")
+	}
+}
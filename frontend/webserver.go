@@ -38,6 +38,7 @@ var primitiveMap = map[string]string{
 	"generic":                          "show %s",
 	"whois":                            "%s",
 	"traceroute":                       "%s",
+	"ping":                             "%s",
 }
 
 var webServerPrepared uint32 = 0
@@ -55,11 +56,16 @@ func webHandlerWhois(w http.ResponseWriter, r *http.Request) {
 		serverError(w, r)
 		return
 	}
+	if rateLimited(w, r, "whois", 1) {
+		return
+	}
 
 	// render the whois template
+	result, cachedAt := whoisCached(target)
 	args := TemplateWhois{
-		Target: target,
-		Result: smartFormatter(whois(target)),
+		Target:   target,
+		Result:   smartFormatter(result),
+		CacheAge: cacheAgeText(cachedAt),
 	}
 
 	tmpl := TemplateLibrary["whois"]
@@ -98,50 +104,114 @@ func webBackendCommunicator(endpoint string, command string) func(w http.Respons
 		}
 		backendCommand = strings.TrimSpace(backendCommand)
 
-		servers := strings.Split(split[1], "+")
-
-		var responses []string = batchRequest(servers, endpoint, backendCommand)
-		var content string
-		for i, response := range responses {
+		servers := parseServersParam(split[1])
+		if rateLimited(w, r, endpoint, len(servers)) {
+			return
+		}
+		title := " - " + endpoint + " " + backendCommand
 
-			var result template.HTML
-			if (endpoint == "bird") && backendCommand == "show protocols" && len(response) > 4 && strings.ToLower(response[0:4]) == "name" {
-				result = summaryTable(response, servers[i])
-			} else {
-				result = smartFormatter(response)
-			}
+		// Summary tables need the complete output, everything else is streamed
+		if endpoint == "bird" && backendCommand == "show protocols" {
+			webBackendSummary(w, r, title, servers, endpoint, summaryCommand())
+			return
+		}
 
-			serverDisplay := servers[i]
-			for k, v := range setting.servers {
-				if servers[i] == v {
-					serverDisplay = setting.serversDisplay[k]
-					break
+		streams, cachedAt, _ := batchRequestStreamCached(r.Context(), servers, endpoint, backendCommand, "")
+		renderPageTemplateStream(w, r, title, func(w http.ResponseWriter) {
+			for i, stream := range streams {
+				// render the bird result template around the streamed result
+				head, tail := renderTemplateAround("bird", TemplateBird{
+					ServerName: serverDisplayName(servers, i),
+					Target:     backendCommand,
+					Result:     streamContentPlaceholder,
+					CacheAge:   cacheAgeText(cachedAt[i]),
+				})
+
+				w.Write([]byte(head + "<pre>"))
+				for line := range stream {
+					w.Write([]byte(smartFormatLines(strings.TrimSuffix(line, "\n"))))
+					// Only flush when we're waiting for more data
+					if len(stream) == 0 {
+						flushResponse(w)
+					}
 				}
+				w.Write([]byte("</pre>" + tail))
+				flushResponse(w)
 			}
+		})
+	}
+}
 
-			// render the bird result template
-			args := TemplateBird{
-				ServerName: serverDisplay,
-				Target:     backendCommand,
-				Result:     result,
-			}
+// Details of a protocol, rendered by page, or as the JSON of API type detail
+// with ?format=json
+func webHandlerDetail(page http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") != "json" {
+			page(w, r)
+			return
+		}
 
-			tmpl := TemplateLibrary["bird"]
-			var buffer bytes.Buffer
-			err := tmpl.Execute(&buffer, args)
-			if err != nil {
-				fmt.Println("Error rendering bird template:", err.Error())
-			}
+		split := strings.SplitN(r.URL.Path[1:], "/", 3)
+		var name string
+		if len(split) >= 3 {
+			name = split[2]
+		}
+		servers := parseServersParam(split[1])
+		if rateLimited(w, r, "bird", len(servers)) {
+			return
+		}
 
-			content += buffer.String()
+		response := apiDetailHandler(r.Context(), apiRequest{Servers: servers, Type: "detail", Args: name})
+		apiWriteResponse(w, http.StatusOK, response)
+	}
+}
+
+// Display name of the i-th server in the request
+func serverDisplayName(servers []string, i int) string {
+	for k, v := range setting.servers {
+		if servers[i] == v {
+			return setting.serversDisplay[k]
 		}
+	}
+	return servers[i]
+}
 
-		renderPageTemplate(
-			w, r,
-			" - "+endpoint+" "+backendCommand,
-			template.HTML(content),
-		)
+// Render results of "show protocols" as summary tables
+func webBackendSummary(w http.ResponseWriter, r *http.Request, title string, servers []string, endpoint string, backendCommand string) {
+	responses, cachedAt, _ := batchRequestCached(r.Context(), servers, endpoint, backendCommand, "")
+	var content string
+	for i, response := range responses {
+
+		var result template.HTML
+		if len(response) > 4 && strings.ToLower(response[0:4]) == "name" {
+			result = summaryTable(response, servers[i])
+		} else {
+			result = smartFormatter(response)
+		}
+
+		// render the bird result template
+		args := TemplateBird{
+			ServerName: serverDisplayName(servers, i),
+			Target:     backendCommand,
+			Result:     result,
+			CacheAge:   cacheAgeText(cachedAt[i]),
+		}
+
+		tmpl := TemplateLibrary["bird"]
+		var buffer bytes.Buffer
+		err := tmpl.Execute(&buffer, args)
+		if err != nil {
+			fmt.Println("Error rendering bird template:", err.Error())
+		}
+
+		content += buffer.String()
 	}
+
+	renderPageTemplate(
+		w, r,
+		title,
+		template.HTML(content),
+	)
 }
 
 // bgpmap result
@@ -166,8 +236,11 @@ func webHandlerBGPMap(endpoint string, command string) func(w http.ResponseWrite
 			backendCommand = backendCommandPrimitive
 		}
 
-		var servers []string = strings.Split(split[1], "+")
-		var responses []string = batchRequest(servers, endpoint, backendCommand)
+		var servers []string = parseServersParam(split[1])
+		if rateLimited(w, r, endpoint, len(servers)) {
+			return
+		}
+		responses, cachedAt, _ := batchRequestCached(r.Context(), servers, endpoint, backendCommand, "")
 
 		// encode result with base64 to prevent xss
 		result := birdRouteToGraphviz(servers, responses, urlCommands)
@@ -175,9 +248,10 @@ func webHandlerBGPMap(endpoint string, command string) func(w http.ResponseWrite
 
 		// render the bgpmap result template
 		args := TemplateBGPmap{
-			Servers: servers,
-			Target:  backendCommand,
-			Result:  result,
+			Servers:  servers,
+			Target:   backendCommand,
+			Result:   result,
+			CacheAge: cacheAgeText(oldestCachedAt(cachedAt)),
 		}
 
 		tmpl := TemplateLibrary["bgpmap"]
@@ -215,7 +289,7 @@ func webServerPrepare() {
 
 	// backend routes
 	http.HandleFunc("/summary/", webBackendCommunicator("bird", "summary"))
-	http.HandleFunc("/detail/", webBackendCommunicator("bird", "detail"))
+	http.HandleFunc("/detail/", webHandlerDetail(webBackendCommunicator("bird", "detail")))
 	http.HandleFunc("/route_filtered_from_protocol/", webBackendCommunicator("bird", "route_filtered_from_protocol"))
 	http.HandleFunc("/route_filtered_from_protocol_all/", webBackendCommunicator("bird", "route_filtered_from_protocol_all"))
 	http.HandleFunc("/route_from_protocol/", webBackendCommunicator("bird", "route_from_protocol"))
@@ -229,15 +303,24 @@ func webServerPrepare() {
 	http.HandleFunc("/route/", webBackendCommunicator("bird", "route"))
 	http.HandleFunc("/route_all/", webBackendCommunicator("bird", "route_all"))
 	http.HandleFunc("/route_bgpmap/", webHandlerBGPMap("bird", "route_bgpmap"))
+	http.HandleFunc("/compare/", webHandlerCompare)
 	http.HandleFunc("/route_where/", webBackendCommunicator("bird", "route_where"))
 	http.HandleFunc("/route_where_all/", webBackendCommunicator("bird", "route_where_all"))
 	http.HandleFunc("/route_where_bgpmap/", webHandlerBGPMap("bird", "route_where_bgpmap"))
 	http.HandleFunc("/route_generic/", webBackendCommunicator("bird", "route_generic"))
 	http.HandleFunc("/generic/", webBackendCommunicator("bird", "generic"))
 	http.HandleFunc("/traceroute/", webBackendCommunicator("traceroute", "traceroute"))
+	http.HandleFunc("/traceroute_table/", webHandlerTracerouteTable)
+	http.HandleFunc("/ping/", webBackendCommunicator("ping", "ping"))
 	http.HandleFunc("/whois/", webHandlerWhois)
+	http.HandleFunc("/status/", webHandlerStatus)
 	http.HandleFunc("/api/", apiHandler)
+	http.Handle(apiV2Prefix, apiV2Handler())
+	http.HandleFunc(openAPIPath, apiOpenAPIHandler)
 	http.HandleFunc("/telegram/", webHandlerTelegramBot)
+	if setting.metrics {
+		http.Handle("/metrics", metricsRegistryHandler())
+	}
 }
 
 // start webserver
@@ -247,7 +330,7 @@ func webServerStart(l net.Listener) {
 	}
 
 	var handler http.Handler
-	handler = http.DefaultServeMux
+	handler = authHandler(metricsHandler(http.DefaultServeMux))
 	if setting.trustProxyHeaders {
 		handler = handlers.ProxyHeaders(handler)
 	}
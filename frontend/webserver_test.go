@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -65,6 +66,44 @@ func TestWebBackendCommunicator(t *testing.T) {
 	assert.Equal(t, w.Code, http.StatusOK)
 }
 
+func TestWebHandlerDetailJSON(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, readDataFile(t, "lib/birdparser/testdata/protocols_bird2.txt"))
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols all 'dn42_beta'"), httpResponse)
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	pageRendered := false
+	handler := webHandlerDetail(func(w http.ResponseWriter, r *http.Request) {
+		pageRendered = true
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/detail/alpha/dn42_beta?format=json", nil)
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Equal(t, pageRendered, false)
+
+	var response struct {
+		Result []apiDetailResultPair `json:"result"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, response.Result[0].Server, "alpha")
+	assert.Equal(t, response.Result[0].Data.BGP.LastError, "Socket: Connection refused")
+
+	// Without format, the page is rendered
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/detail/alpha/dn42_beta", nil))
+	assert.Equal(t, pageRendered, true)
+}
+
 func TestWebHandlerBGPMap(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -87,3 +126,80 @@ func TestWebHandlerBGPMap(t *testing.T) {
 
 	assert.Equal(t, w.Code, http.StatusOK)
 }
+
+func TestWebBackendCommunicatorStream(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "Table master4:\n1.1.1.0/24 unicast [bgp1 2021-08-27] * (100) [AS13335i]\n")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show route for 1.1.1.1"), httpResponse)
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	r := httptest.NewRequest(http.MethodGet, "/route/alpha/1.1.1.1", nil)
+	w := httptest.NewRecorder()
+
+	handler := webBackendCommunicator("bird", "route")
+	handler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Flushed, true)
+	body := w.Body.String()
+	if !strings.Contains(body, "<h2>alpha: show route for 1.1.1.1</h2>\n<pre>Table master4:\n") {
+		t.Error("Result not rendered with bird template")
+	}
+	if !strings.Contains(body, `[<a href="/whois/AS13335" class="whois">AS13335</a>i]`) {
+		t.Error("Result not formatted with whois links")
+	}
+	if !strings.Contains(body, "</html>") {
+		t.Error("Page not rendered completely")
+	}
+}
+
+func TestWebBackendCommunicatorPing(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, "PING 1.1.1.1 (1.1.1.1) 56(84) bytes of data.\n")
+	httpmock.RegisterResponder("GET", "http://alpha:8000/ping?q=1.1.1.1", httpResponse)
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	r := httptest.NewRequest(http.MethodGet, "/ping/alpha/1.1.1.1", nil)
+	w := httptest.NewRecorder()
+
+	handler := webBackendCommunicator("ping", "ping")
+	handler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "<h2>alpha: 1.1.1.1</h2>\n<pre>PING ") {
+		t.Errorf("Ping result not rendered: %s", w.Body.String())
+	}
+}
+
+func TestWebBackendCommunicatorSummary(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpResponse := httpmock.NewStringResponder(200, BirdSummaryData)
+	httpmock.RegisterResponder("GET", "http://alpha:8000/bird?q="+url.QueryEscape("show protocols"), httpResponse)
+
+	initSettings()
+	setting.domain = ""
+	setting.proxyPort = 8000
+
+	r := httptest.NewRequest(http.MethodGet, "/summary/alpha/", nil)
+	w := httptest.NewRecorder()
+
+	handler := webBackendCommunicator("bird", "summary")
+	handler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "<table") {
+		t.Error("Summary table not rendered")
+	}
+}
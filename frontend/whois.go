@@ -25,16 +25,41 @@ func addDefaultWhoisPort(server string) string {
 	return server
 }
 
-// Send a whois request
+// Send a whois request, results are cached if enabled
 func whois(s string) string {
+	result, _ := whoisCached(s)
+	return result
+}
+
+// Same as whois, but also returns the time the result was cached, or zero
+// time if the result is fresh
+func whoisCached(s string) (string, time.Time) {
+	ttl := setting.cacheTTL["whois"]
+	if ttl <= 0 {
+		result, _ := whoisQuery(s)
+		return result, time.Time{}
+	}
+	return requestCache.getOrFetch("whois", cacheKey("", "whois", s, ""), ttl, func() (string, error) {
+		return whoisQuery(s)
+	})
+}
+
+// Send a whois request without caching. On errors, the error message is
+// returned along with any output received.
+func whoisQuery(s string) (result string, err error) {
 	if setting.whoisServer == "" {
-		return ""
+		return "", nil
 	}
 
+	start := time.Now()
+	defer func() {
+		metricWhoisDuration.WithLabelValues(metricsResult(err)).Observe(time.Since(start).Seconds())
+	}()
+
 	if strings.HasPrefix(setting.whoisServer, "/") {
 		args, err := shlex.Split(setting.whoisServer)
 		if err != nil {
-			return err.Error()
+			return err.Error(), err
 		}
 		args = append(args, s)
 
@@ -44,9 +69,9 @@ func whois(s string) string {
 			output = output[:65535]
 		}
 		if err != nil {
-			return err.Error() + "\n" + string(output)
+			return err.Error() + "\n" + string(output), err
 		} else {
-			return string(output)
+			return string(output), nil
 		}
 	} else {
 		buf := make([]byte, 65536)
@@ -55,7 +80,7 @@ func whois(s string) string {
 
 		conn, err := (&net.Dialer{Timeout: 5 * time.Second, Control: vrfControl(setting.vrf)}).Dial("tcp", whoisServer)
 		if err != nil {
-			return err.Error()
+			return err.Error(), err
 		}
 		defer conn.Close()
 
@@ -63,9 +88,9 @@ func whois(s string) string {
 
 		n, err := io.ReadFull(conn, buf)
 		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
-			return err.Error() + "\n" + string(buf[:n])
+			return err.Error() + "\n" + string(buf[:n]), err
 		}
-		return string(buf[:n])
+		return string(buf[:n]), nil
 	}
 
 }
@@ -0,0 +1,365 @@
+package birdparser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol is a protocol of "show protocols [all]" output. Channels and
+// BGP are only filled in with "all".
+type Protocol struct {
+	Name        string      `json:"name"`
+	Proto       string      `json:"proto"`
+	Table       string      `json:"table"`
+	State       string      `json:"state"`
+	Since       string      `json:"since"`
+	Info        string      `json:"info,omitempty"`
+	Description string      `json:"description,omitempty"`
+	BGP         *BGPSession `json:"bgp,omitempty"`
+	Channels    []Channel   `json:"channels,omitempty"`
+	// Other "key: value" lines of the protocol, e.g. "Message"
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Channel is a channel of a protocol, e.g. "ipv4". BIRD 1 protocols have
+// a single channel without a name.
+type Channel struct {
+	Name         string       `json:"name"`
+	State        string       `json:"state,omitempty"`
+	Table        string       `json:"table,omitempty"`
+	Preference   int          `json:"preference,omitempty"`
+	InputFilter  string       `json:"input_filter,omitempty"`
+	OutputFilter string       `json:"output_filter,omitempty"`
+	Routes       *RouteCounts `json:"routes,omitempty"`
+	// Limits of routes, "Receive limit" is the limit before filters
+	ImportLimit  *RouteLimit `json:"import_limit,omitempty"`
+	ReceiveLimit *RouteLimit `json:"receive_limit,omitempty"`
+	ExportLimit  *RouteLimit `json:"export_limit,omitempty"`
+	// Other "key: value" lines of the channel, e.g. "BGP Next hop"
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// RouteCounts is the "Routes:" line of a channel. Filtered is only shown by
+// BIRD if import of filtered routes is enabled, and is 0 otherwise.
+type RouteCounts struct {
+	Imported  uint64 `json:"imported"`
+	Filtered  uint64 `json:"filtered"`
+	Exported  uint64 `json:"exported"`
+	Preferred uint64 `json:"preferred"`
+}
+
+// RouteLimit is a limit of routes of a channel, and the action taken by
+// BIRD when it's hit, e.g. "block" or "disable".
+type RouteLimit struct {
+	Limit  uint64 `json:"limit"`
+	Action string `json:"action,omitempty"`
+}
+
+// BGPCapability is a capability of a BGP session, e.g. "Multiprotocol",
+// with details such as "AF announced: ipv4 ipv6".
+type BGPCapability struct {
+	Name    string   `json:"name"`
+	Details []string `json:"details,omitempty"`
+}
+
+// BGPSession is the state of a BGP session, from the "BGP state:" block.
+type BGPSession struct {
+	State           string `json:"state"`
+	NeighborAddress string `json:"neighbor_address,omitempty"`
+	NeighborAS      uint32 `json:"neighbor_as,omitempty"`
+	LocalAS         uint32 `json:"local_as,omitempty"`
+	NeighborID      string `json:"neighbor_id,omitempty"`
+	Session         string `json:"session,omitempty"`
+	SourceAddress   string `json:"source_address,omitempty"`
+	HoldTimer       string `json:"hold_timer,omitempty"`
+	KeepaliveTimer  string `json:"keepalive_timer,omitempty"`
+	LastError       string `json:"last_error,omitempty"`
+	// Capabilities announced by BIRD, only shown by BIRD 2 and later
+	LocalCapabilities []BGPCapability `json:"local_capabilities,omitempty"`
+	// Capabilities announced by the neighbor. BIRD 1 only shows their names.
+	NeighborCapabilities []BGPCapability `json:"neighbor_capabilities,omitempty"`
+	// Other "key: value" lines of the block, e.g. "Neighbor port"
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// BGPStates are the states of a BGP session in order, as shown by BIRD.
+var BGPStates = []string{"Idle", "Connect", "Active", "OpenSent", "OpenConfirm", "Established", "Close"}
+
+// Header line of a protocol, e.g.
+// bgp1       BGP        ---        up     2021-08-27 12:00:00  Established
+var protocolHeaderRe = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(\S+)\s+(\S+)\s+([0-9][0-9\-\.:]*(?: [0-9][0-9\.:]*)?)(?:\s+(.*))?$`)
+
+var routeCountRe = regexp.MustCompile(`(\d+) (imported|filtered|exported|preferred)`)
+
+// Width of the indentation of a line, with tabs counting as 8 spaces
+func indentWidth(line string) int {
+	width := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 8
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+func setAttribute(attributes *map[string]string, key string, value string) {
+	if *attributes == nil {
+		*attributes = make(map[string]string)
+	}
+	(*attributes)[key] = value
+}
+
+func (c *Channel) setAttribute(key string, value string) {
+	switch key {
+	case "State":
+		c.State = value
+	case "Table":
+		c.Table = value
+	case "Preference":
+		c.Preference, _ = strconv.Atoi(value)
+	case "Input filter":
+		c.InputFilter = value
+	case "Output filter":
+		c.OutputFilter = value
+	case "Routes":
+		c.Routes = parseRouteCounts(value)
+	case "Import limit":
+		c.ImportLimit = parseRouteLimit(value)
+	case "Receive limit":
+		c.ReceiveLimit = parseRouteLimit(value)
+	case "Export limit":
+		c.ExportLimit = parseRouteLimit(value)
+	default:
+		setAttribute(&c.Attributes, key, value)
+	}
+}
+
+// Limit set by the "key: value" line, nil if the line is not a limit
+func (c *Channel) routeLimit(key string) *RouteLimit {
+	switch key {
+	case "Import limit":
+		return c.ImportLimit
+	case "Receive limit":
+		return c.ReceiveLimit
+	case "Export limit":
+		return c.ExportLimit
+	}
+	return nil
+}
+
+func (b *BGPSession) setAttribute(key string, value string) {
+	switch key {
+	case "BGP state":
+		b.State = value
+	case "Neighbor address":
+		b.NeighborAddress = value
+	case "Neighbor AS":
+		b.NeighborAS, _ = parseUint32(value)
+	case "Local AS":
+		b.LocalAS, _ = parseUint32(value)
+	case "Neighbor ID":
+		b.NeighborID = value
+	case "Session":
+		b.Session = value
+	case "Source address":
+		b.SourceAddress = value
+	case "Hold timer":
+		b.HoldTimer = value
+	case "Keepalive timer":
+		b.KeepaliveTimer = value
+	case "Last error":
+		b.LastError = value
+	case "Neighbor caps":
+		// BIRD 1, e.g. "refresh restart-aware AS4"
+		for _, name := range strings.Fields(value) {
+			b.NeighborCapabilities = append(b.NeighborCapabilities, BGPCapability{Name: name})
+		}
+	default:
+		setAttribute(&b.Attributes, key, value)
+	}
+}
+
+// Parse the value of a limit, e.g. "10000"
+func parseRouteLimit(value string) *RouteLimit {
+	limit := &RouteLimit{}
+	if fields := strings.Fields(value); len(fields) > 0 {
+		limit.Limit, _ = strconv.ParseUint(fields[0], 10, 64)
+	}
+	return limit
+}
+
+// Parse "10 imported, 2 filtered, 5 exported, 8 preferred"
+func parseRouteCounts(value string) *RouteCounts {
+	var counts RouteCounts
+	for _, match := range routeCountRe.FindAllStringSubmatch(value, -1) {
+		n, _ := strconv.ParseUint(match[1], 10, 64)
+		switch match[2] {
+		case "imported":
+			counts.Imported = n
+		case "filtered":
+			counts.Filtered = n
+		case "exported":
+			counts.Exported = n
+		case "preferred":
+			counts.Preferred = n
+		}
+	}
+	return &counts
+}
+
+// ParseProtocols parses the output of "show protocols [all]" commands.
+// Lines that are not recognized (e.g. the table header or error messages)
+// are skipped.
+func ParseProtocols(output string) []Protocol {
+	protocols := []Protocol{}
+	// Indented blocks of the current protocol
+	var channel *Channel
+	var bgp *BGPSession
+	// Limit of the previous line, followed by its action indented further
+	var limit *RouteLimit
+	var limitIndent int
+	// List of capabilities being parsed, and the indentation of the list
+	// and its items
+	var capabilities *[]BGPCapability
+	var capabilitiesIndent, capabilityIndent int
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		indent := indentWidth(line)
+		if indent == 0 {
+			channel, bgp, limit, capabilities = nil, nil, nil, nil
+			match := protocolHeaderRe.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			protocols = append(protocols, Protocol{
+				Name:  match[1],
+				Proto: match[2],
+				Table: match[3],
+				State: match[4],
+				Since: match[5],
+				Info:  strings.TrimSpace(match[6]),
+			})
+			continue
+		}
+		if len(protocols) == 0 {
+			continue
+		}
+		protocol := &protocols[len(protocols)-1]
+
+		key, value, found := strings.Cut(trimmed, ":")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		if limit != nil && indent > limitIndent && key == "Action" {
+			limit.Action = value
+			continue
+		}
+		limit = nil
+
+		// Each capability is followed by its details indented further
+		if capabilities != nil && indent > capabilitiesIndent {
+			if capabilityIndent == 0 || indent <= capabilityIndent {
+				capabilityIndent = indent
+				*capabilities = append(*capabilities, BGPCapability{Name: trimmed})
+			} else if last := len(*capabilities) - 1; last >= 0 {
+				(*capabilities)[last].Details = append((*capabilities)[last].Details, trimmed)
+			}
+			continue
+		}
+		capabilities = nil
+
+		// Lines of the protocol itself, or starting a block
+		if indent <= 2 {
+			channel, bgp = nil, nil
+			switch {
+			case !found && strings.HasPrefix(trimmed, "Channel "):
+				protocol.Channels = append(protocol.Channels, Channel{Name: strings.TrimPrefix(trimmed, "Channel ")})
+				channel = &protocol.Channels[len(protocol.Channels)-1]
+			case !found || key == "Route change stats":
+			case key == "BGP state":
+				protocol.BGP = &BGPSession{State: value}
+				bgp = protocol.BGP
+			case key == "Description":
+				protocol.Description = value
+			case key == "Routes" || key == "Input filter" || key == "Output filter" || key == "Preference" ||
+				key == "Import limit" || key == "Receive limit" || key == "Export limit":
+				// BIRD 1 shows the only channel as part of the protocol
+				if len(protocol.Channels) == 0 {
+					protocol.Channels = append(protocol.Channels, Channel{})
+				}
+				protocol.Channels[0].setAttribute(key, value)
+				limit, limitIndent = protocol.Channels[0].routeLimit(key), indent
+			default:
+				setAttribute(&protocol.Attributes, key, value)
+			}
+			continue
+		}
+
+		if bgp != nil && !found && (trimmed == "Local capabilities" || trimmed == "Neighbor capabilities") {
+			if trimmed == "Local capabilities" {
+				capabilities = &bgp.LocalCapabilities
+			} else {
+				capabilities = &bgp.NeighborCapabilities
+			}
+			capabilitiesIndent, capabilityIndent = indent, 0
+			continue
+		}
+
+		// Tables (e.g. "Route change stats") are not parsed
+		if indent > 4 || !found || value == "" || key == "Route change stats" {
+			continue
+		}
+		if channel != nil {
+			channel.setAttribute(key, value)
+			limit, limitIndent = channel.routeLimit(key), indent
+		} else if bgp != nil {
+			bgp.setAttribute(key, value)
+		}
+	}
+
+	return protocols
+}
+
+// Time formats of "since" in "show protocols", from "timeformat protocol"
+// of BIRD. The default "iso short" shows the time only for today.
+var sinceFormats = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"15:04:05.999999999",
+	"15:04:05",
+}
+
+// ParseSince parses the "since" of a protocol into a time in loc, relative
+// to now if only the time of day is shown. Returns false if the format is
+// not recognized.
+func ParseSince(since string, now time.Time, loc *time.Location) (time.Time, bool) {
+	now = now.In(loc)
+	for _, format := range sinceFormats {
+		t, err := time.ParseInLocation(format, since, loc)
+		if err != nil {
+			continue
+		}
+		if !strings.HasPrefix(format, "2006") {
+			// Time of today, or yesterday if that would be in the future
+			t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+			if t.After(now) {
+				t = t.AddDate(0, 0, -1)
+			}
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
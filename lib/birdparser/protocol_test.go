@@ -0,0 +1,156 @@
+package birdparser
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProtocolsGolden(t *testing.T) {
+	for _, name := range []string{
+		"protocols_bird2",
+		"protocols_bird1",
+		"protocols_error",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertGolden(t, name, ParseProtocols(readTestData(t, name)))
+		})
+	}
+}
+
+func TestParseProtocolsBird2(t *testing.T) {
+	protocols := ParseProtocols(readTestData(t, "protocols_bird2"))
+	if len(protocols) != 4 {
+		t.Fatalf("Expected 4 protocols, got %d", len(protocols))
+	}
+
+	kernel := protocols[1]
+	if kernel.Name != "kernel1" || kernel.Since != "2023-04-29 10:00:00" || kernel.Info != "" || kernel.BGP != nil {
+		t.Errorf("Kernel protocol parsed incorrectly: %+v", kernel)
+	}
+	if len(kernel.Channels) != 1 || kernel.Channels[0].Routes == nil || kernel.Channels[0].Routes.Exported != 512 {
+		t.Errorf("Kernel channel parsed incorrectly: %+v", kernel.Channels)
+	}
+	if kernel.Attributes != nil || kernel.Channels[0].Attributes != nil {
+		t.Error("Route change stats should be skipped")
+	}
+
+	bgp := protocols[2]
+	if bgp.Description != "Alpha Network" || bgp.Info != "Established" {
+		t.Errorf("BGP protocol parsed incorrectly: %+v", bgp)
+	}
+	if bgp.BGP == nil {
+		t.Fatal("BGP session not parsed")
+	}
+	if bgp.BGP.State != "Established" || bgp.BGP.NeighborAS != 4242421080 || bgp.BGP.LocalAS != 4242420001 || bgp.BGP.NeighborAddress != "fe80::1234%dn42-alpha" {
+		t.Errorf("BGP session parsed incorrectly: %+v", bgp.BGP)
+	}
+	if bgp.BGP.HoldTimer != "150.343/240" || bgp.BGP.Session != "external AS4" {
+		t.Errorf("BGP session parsed incorrectly: %+v", bgp.BGP)
+	}
+	if len(bgp.Channels) != 2 {
+		t.Fatalf("Expected 2 channels, got %d", len(bgp.Channels))
+	}
+	if *bgp.Channels[0].Routes != (RouteCounts{Imported: 680, Filtered: 3, Exported: 512, Preferred: 420}) {
+		t.Errorf("Route counts parsed incorrectly: %+v", bgp.Channels[0].Routes)
+	}
+	if bgp.Channels[0].Attributes["BGP Next hop"] != "172.20.0.1 fe80::1" || len(bgp.Channels[0].Attributes) != 1 {
+		t.Errorf("Channel attributes parsed incorrectly: %v", bgp.Channels[0].Attributes)
+	}
+	if *bgp.Channels[0].ImportLimit != (RouteLimit{Limit: 10000, Action: "block"}) || bgp.Channels[0].ExportLimit != nil {
+		t.Errorf("Import limit parsed incorrectly: %+v", bgp.Channels[0].ImportLimit)
+	}
+	if len(bgp.BGP.LocalCapabilities) != 6 || len(bgp.BGP.NeighborCapabilities) != 4 {
+		t.Fatalf("Capabilities parsed incorrectly: %+v %+v", bgp.BGP.LocalCapabilities, bgp.BGP.NeighborCapabilities)
+	}
+	if bgp.BGP.LocalCapabilities[0].Name != "Multiprotocol" || bgp.BGP.LocalCapabilities[0].Details[0] != "AF announced: ipv4 ipv6" {
+		t.Errorf("Capability parsed incorrectly: %+v", bgp.BGP.LocalCapabilities[0])
+	}
+	if bgp.BGP.NeighborCapabilities[2].Name != "Extended next hop" || bgp.BGP.NeighborCapabilities[2].Details[0] != "IPv6 nexthop: ipv4" {
+		t.Errorf("Capability parsed incorrectly: %+v", bgp.BGP.NeighborCapabilities[2])
+	}
+	if bgp.BGP.Session != "external AS4" || bgp.BGP.KeepaliveTimer != "45.141/80" {
+		t.Errorf("Lines after capabilities parsed incorrectly: %+v", bgp.BGP)
+	}
+	if bgp.Channels[1].Name != "ipv6" || bgp.Channels[1].Table != "master6" || bgp.Channels[1].Routes.Filtered != 0 {
+		t.Errorf("Second channel parsed incorrectly: %+v", bgp.Channels[1])
+	}
+
+	down := protocols[3]
+	if down.State != "start" || down.Since != "12:30:01.123" || down.Info != "Active        Socket: Connection refused" {
+		t.Errorf("Protocol header parsed incorrectly: %+v", down)
+	}
+	if down.BGP == nil || down.BGP.LastError != "Socket: Connection refused" || down.BGP.Attributes["Connect delay"] != "3.124/5" {
+		t.Errorf("BGP session parsed incorrectly: %+v", down.BGP)
+	}
+	if down.Channels[0].Routes != nil {
+		t.Error("Routes should be absent for channels that are down")
+	}
+}
+
+func TestParseProtocolsBird1(t *testing.T) {
+	protocols := ParseProtocols(readTestData(t, "protocols_bird1"))
+	if len(protocols) != 2 {
+		t.Fatalf("Expected 2 protocols, got %d", len(protocols))
+	}
+
+	peer := protocols[1]
+	if len(peer.Channels) != 1 || peer.Channels[0].Name != "" || peer.Channels[0].Preference != 100 {
+		t.Errorf("Channel parsed incorrectly: %+v", peer.Channels)
+	}
+	if peer.Channels[0].Routes.Imported != 120 {
+		t.Errorf("Route counts parsed incorrectly: %+v", peer.Channels[0].Routes)
+	}
+	if *peer.Channels[0].ReceiveLimit != (RouteLimit{Limit: 1000, Action: "restart"}) {
+		t.Errorf("Receive limit parsed incorrectly: %+v", peer.Channels[0].ReceiveLimit)
+	}
+	if peer.BGP == nil || peer.BGP.NeighborAS != 64512 || len(peer.BGP.NeighborCapabilities) != 3 || peer.BGP.NeighborCapabilities[2].Name != "AS4" {
+		t.Errorf("BGP session parsed incorrectly: %+v", peer.BGP)
+	}
+	if peer.Attributes != nil {
+		t.Errorf("Unexpected attributes %v", peer.Attributes)
+	}
+}
+
+func TestParseProtocolsSummary(t *testing.T) {
+	protocols := ParseProtocols(`Name       Proto      Table      State  Since         Info
+static1    Static     master4    up     2021-08-27
+bgp1       BGP        ---        up     2021-08-27    Established
+`)
+	if len(protocols) != 2 {
+		t.Fatalf("Expected 2 protocols, got %d", len(protocols))
+	}
+	if protocols[0].Name != "static1" || protocols[0].Table != "master4" || protocols[0].Since != "2021-08-27" {
+		t.Errorf("Protocol parsed incorrectly: %+v", protocols[0])
+	}
+	if protocols[1].Info != "Established" || protocols[1].Channels != nil {
+		t.Errorf("Protocol parsed incorrectly: %+v", protocols[1])
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2023, 5, 2, 12, 0, 0, 0, loc)
+
+	tests := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"2023-05-01 08:15:42", time.Date(2023, 5, 1, 8, 15, 42, 0, loc)},
+		{"2023-05-01 08:15:42.500", time.Date(2023, 5, 1, 8, 15, 42, 500000000, loc)},
+		{"2023-04-29", time.Date(2023, 4, 29, 0, 0, 0, 0, loc)},
+		{"11:30:01", time.Date(2023, 5, 2, 11, 30, 1, 0, loc)},
+		{"11:30:01.123", time.Date(2023, 5, 2, 11, 30, 1, 123000000, loc)},
+		// Time of day after now is from yesterday
+		{"13:00:00", time.Date(2023, 5, 1, 13, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		result, ok := ParseSince(tt.input, now, loc)
+		if !ok || !result.Equal(tt.expected) {
+			t.Errorf("ParseSince(%q) = %v, expected %v", tt.input, result, tt.expected)
+		}
+	}
+
+	if _, ok := ParseSince("yesterday", now, loc); ok {
+		t.Error("Should fail to parse unknown format")
+	}
+}
@@ -0,0 +1,317 @@
+// Package birdparser parses the text output of BIRD commands, as returned by
+// bird-lgproxy-go, into typed structures.
+package birdparser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Route is a single route entry of "show route ... all" output.
+type Route struct {
+	Table      string            `json:"table,omitempty"`
+	Prefix     string            `json:"prefix"`
+	Type       string            `json:"type"`
+	Protocol   string            `json:"protocol"`
+	Since      string            `json:"since"`
+	From       string            `json:"from,omitempty"`
+	Preferred  bool              `json:"preferred"`
+	Preference int               `json:"preference"`
+	Metric     *uint32           `json:"metric,omitempty"`
+	Origin     string            `json:"origin,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Nexthops   []Nexthop         `json:"nexthops,omitempty"`
+	Interface  string            `json:"interface,omitempty"`
+	BGP        *BGPAttributes    `json:"bgp,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Nexthop is a "via" or "dev" line of a route.
+type Nexthop struct {
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Weight    int    `json:"weight,omitempty"`
+}
+
+// Community is a standard BGP community, (ASN, value).
+type Community [2]uint32
+
+// LargeCommunity is a BGP large community, (ASN, data1, data2).
+type LargeCommunity [3]uint32
+
+// BGPAttributes are the BGP specific attributes of a route.
+// Both BIRD 2 (BGP.as_path) and BIRD 3 (bgp_path) notations are recognized.
+type BGPAttributes struct {
+	Origin           string           `json:"origin,omitempty"`
+	ASPath           []uint32         `json:"as_path"`
+	NextHop          []string         `json:"next_hop,omitempty"`
+	MED              *uint32          `json:"med,omitempty"`
+	LocalPref        *uint32          `json:"local_pref,omitempty"`
+	Communities      []Community      `json:"communities,omitempty"`
+	LargeCommunities []LargeCommunity `json:"large_communities,omitempty"`
+	ExtCommunities   []string         `json:"ext_communities,omitempty"`
+}
+
+func (n Nexthop) String() string {
+	var result string
+	if n.Gateway != "" {
+		result = "via " + n.Gateway
+		if n.Interface != "" {
+			result += " on " + n.Interface
+		}
+	} else {
+		result = "dev " + n.Interface
+	}
+	if n.Weight != 0 {
+		result += " weight " + strconv.Itoa(n.Weight)
+	}
+	return result
+}
+
+func (c Community) String() string {
+	return fmt.Sprintf("(%d,%d)", c[0], c[1])
+}
+
+func (c LargeCommunity) String() string {
+	return fmt.Sprintf("(%d, %d, %d)", c[0], c[1], c[2])
+}
+
+// ASPathStrings returns the AS path with each ASN formatted as a string.
+func (b *BGPAttributes) ASPathStrings() []string {
+	result := []string{}
+	for _, asn := range b.ASPath {
+		result = append(result, strconv.FormatUint(uint64(asn), 10))
+	}
+	return result
+}
+
+// The first line of each route, for example:
+//
+//	172.20.0.53/32       unicast [ibgp_sjc2 2023-04-29 from fd86:bad:11b7:22::1] * (100/38) [AS4242423914i]
+//
+// The prefix is omitted for alternative routes of the same prefix.
+// Possible route types are defined at https://gitlab.nic.cz/labs/bird/-/blob/v2.0.8/nest/rt-attr.c#L81-87
+var routeHeaderRe = regexp.MustCompile(`^(\S*)\s+(unicast|blackhole|unreachable|prohibited)\s+\[(\S+)\s+(.*?)(?:\s+from\s+(\S+))?\]\s*(\*)?\s*(?:\((\d+)(?:/(\d+))?\))?\s*(?:\[(.*)\])?\s*$`)
+
+var communityRe = regexp.MustCompile(`\((\d+),\s*(\d+)\)`)
+var largeCommunityRe = regexp.MustCompile(`\((\d+),\s*(\d+),\s*(\d+)\)`)
+var extCommunityRe = regexp.MustCompile(`\(([^()]*)\)`)
+
+func parseUint32(s string) (uint32, bool) {
+	value, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(value), true
+}
+
+func parseUint32Ptr(s string) *uint32 {
+	value, ok := parseUint32(s)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func parseNexthop(line string) *Nexthop {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	var nexthop Nexthop
+	switch fields[0] {
+	case "via":
+		nexthop.Gateway = fields[1]
+	case "dev":
+		nexthop.Interface = fields[1]
+	default:
+		return nil
+	}
+
+	fields = fields[2:]
+	for i := 0; i+1 < len(fields); i += 2 {
+		switch fields[i] {
+		case "on":
+			nexthop.Interface = fields[i+1]
+		case "weight":
+			nexthop.Weight, _ = strconv.Atoi(fields[i+1])
+		}
+	}
+	return &nexthop
+}
+
+// ParseASPath parses an AS path attribute. AS sets and confederation
+// segments are flattened into the sequence.
+func ParseASPath(s string) []uint32 {
+	path := []uint32{}
+	for _, asn := range strings.Fields(strings.NewReplacer("(", " ", ")", " ", "{", " ", "}", " ").Replace(s)) {
+		if value, ok := parseUint32(asn); ok {
+			path = append(path, value)
+		}
+	}
+	return path
+}
+
+func parseCommunities(s string) []Community {
+	var result []Community
+	for _, match := range communityRe.FindAllStringSubmatch(s, -1) {
+		asn, _ := parseUint32(match[1])
+		value, _ := parseUint32(match[2])
+		result = append(result, Community{asn, value})
+	}
+	return result
+}
+
+func parseLargeCommunities(s string) []LargeCommunity {
+	var result []LargeCommunity
+	for _, match := range largeCommunityRe.FindAllStringSubmatch(s, -1) {
+		asn, _ := parseUint32(match[1])
+		data1, _ := parseUint32(match[2])
+		data2, _ := parseUint32(match[3])
+		result = append(result, LargeCommunity{asn, data1, data2})
+	}
+	return result
+}
+
+// Normalize BGP attribute names of BIRD 2 ("BGP.as_path") and BIRD 3 ("bgp_path")
+// into the same form ("path"). Returns false if the attribute isn't a BGP one.
+func bgpAttributeName(key string) (string, bool) {
+	key = strings.ToLower(key)
+	switch {
+	case strings.HasPrefix(key, "bgp."):
+		key = key[len("bgp."):]
+	case strings.HasPrefix(key, "bgp_"):
+		key = key[len("bgp_"):]
+	default:
+		return "", false
+	}
+	if key == "as_path" {
+		key = "path"
+	}
+	return key, true
+}
+
+func (r *Route) setAttribute(key string, value string) {
+	if r.Attributes == nil {
+		r.Attributes = make(map[string]string)
+	}
+	r.Attributes[key] = value
+
+	switch strings.ToLower(key) {
+	case "type", "source":
+		if fields := strings.Fields(value); len(fields) > 0 {
+			r.Source = fields[0]
+		}
+		return
+	}
+
+	name, isBGP := bgpAttributeName(key)
+	if !isBGP {
+		return
+	}
+	if r.BGP == nil {
+		r.BGP = &BGPAttributes{ASPath: []uint32{}}
+	}
+
+	switch name {
+	case "origin":
+		r.BGP.Origin = value
+	case "path":
+		r.BGP.ASPath = ParseASPath(value)
+	case "next_hop":
+		r.BGP.NextHop = strings.Fields(value)
+	case "med":
+		r.BGP.MED = parseUint32Ptr(value)
+	case "local_pref":
+		r.BGP.LocalPref = parseUint32Ptr(value)
+	case "community":
+		r.BGP.Communities = parseCommunities(value)
+	case "large_community":
+		r.BGP.LargeCommunities = parseLargeCommunities(value)
+	case "ext_community":
+		r.BGP.ExtCommunities = nil
+		for _, match := range extCommunityRe.FindAllStringSubmatch(value, -1) {
+			r.BGP.ExtCommunities = append(r.BGP.ExtCommunities, strings.TrimSpace(match[1]))
+		}
+	}
+}
+
+// ParseRoutes parses the output of "show route ... [all]" commands.
+// Lines that are not recognized (e.g. error messages) are skipped.
+func ParseRoutes(output string) []Route {
+	routes := []Route{}
+	var table string
+	var prefix string
+	// Attribute of the current route, continued on lines indented further
+	var lastKey string
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "Table ") && strings.HasSuffix(line, ":") {
+			table = strings.TrimSuffix(strings.TrimPrefix(line, "Table "), ":")
+			continue
+		}
+
+		// Nexthops and attributes of the current route are indented with a tab
+		if strings.HasPrefix(line, "\t") {
+			if len(routes) == 0 {
+				continue
+			}
+			route := &routes[len(routes)-1]
+
+			if nexthop := parseNexthop(line); nexthop != nil {
+				route.Nexthops = append(route.Nexthops, *nexthop)
+				if route.Interface == "" {
+					route.Interface = nexthop.Interface
+				}
+				continue
+			}
+
+			// Long lists, e.g. of communities, are wrapped onto lines
+			// indented with two tabs
+			if strings.HasPrefix(line, "\t\t") && lastKey != "" {
+				route.setAttribute(lastKey, route.Attributes[lastKey]+" "+strings.TrimSpace(line))
+				continue
+			}
+
+			key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+			if found {
+				lastKey = strings.TrimSpace(key)
+				route.setAttribute(lastKey, strings.TrimSpace(value))
+			}
+			continue
+		}
+
+		match := routeHeaderRe.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if match[1] != "" {
+			prefix = match[1]
+		}
+		lastKey = ""
+
+		route := Route{
+			Table:     table,
+			Prefix:    prefix,
+			Type:      match[2],
+			Protocol:  match[3],
+			Since:     match[4],
+			From:      match[5],
+			Preferred: match[6] == "*",
+			Metric:    parseUint32Ptr(match[8]),
+			Origin:    match[9],
+		}
+		route.Preference, _ = strconv.Atoi(match[7])
+		routes = append(routes, route)
+	}
+
+	return routes
+}
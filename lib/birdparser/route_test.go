@@ -0,0 +1,219 @@
+package birdparser
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// Compare the JSON representation of result with testdata/<name>.golden.json
+func assertGolden(t *testing.T, name string, result interface{}) {
+	actual, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	goldenPath := filepath.Join("testdata", name+".golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != string(actual) {
+		t.Errorf("Result of %s doesn't match golden file, got:\n%s", name, actual)
+	}
+}
+
+func readTestData(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseRoutesGolden(t *testing.T) {
+	for _, name := range []string{
+		"route_bgp_bird2",
+		"route_bgp_bird3",
+		"route_bgp_wrapped",
+		"route_static",
+		"route_not_found",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertGolden(t, name, ParseRoutes(readTestData(t, name)))
+		})
+	}
+}
+
+func TestParseRoutesBird2(t *testing.T) {
+	routes := ParseRoutes(readTestData(t, "route_bgp_bird2"))
+	if len(routes) != 3 {
+		t.Fatalf("Expected 3 routes, got %d", len(routes))
+	}
+
+	route := routes[0]
+	if route.Prefix != "172.20.0.53/32" || route.Table != "master4" || route.Protocol != "ibgp_sjc2" {
+		t.Errorf("Route header parsed incorrectly: %+v", route)
+	}
+	if !route.Preferred || routes[1].Preferred {
+		t.Error("Preferred flag parsed incorrectly")
+	}
+	if route.Preference != 100 || route.Metric == nil || *route.Metric != 38 {
+		t.Error("Preference and metric parsed incorrectly")
+	}
+	if route.Interface != "igp-sjc2" {
+		t.Errorf("Interface parsed incorrectly: %s", route.Interface)
+	}
+	if route.BGP == nil {
+		t.Fatal("BGP attributes not parsed")
+	}
+	if !reflect.DeepEqual(route.BGP.ASPath, []uint32{4242423914}) {
+		t.Errorf("AS path parsed incorrectly: %v", route.BGP.ASPath)
+	}
+	if route.BGP.LocalPref == nil || *route.BGP.LocalPref != 100 {
+		t.Error("Local pref parsed incorrectly")
+	}
+	if route.BGP.MED == nil || *route.BGP.MED != 50 {
+		t.Error("MED parsed incorrectly")
+	}
+	if !reflect.DeepEqual(route.BGP.Communities, []Community{{64511, 1}, {64511, 24}, {64511, 34}}) {
+		t.Errorf("Communities parsed incorrectly: %v", route.BGP.Communities)
+	}
+	if len(route.BGP.LargeCommunities) != 3 || route.BGP.LargeCommunities[0] != (LargeCommunity{4242421080, 101, 44}) {
+		t.Errorf("Large communities parsed incorrectly: %v", route.BGP.LargeCommunities)
+	}
+
+	// Alternative routes inherit the prefix
+	if routes[2].Prefix != "172.20.0.53/32" {
+		t.Error("Prefix not inherited by alternative route")
+	}
+}
+
+func TestParseRoutesWrappedCommunities(t *testing.T) {
+	routes := ParseRoutes(readTestData(t, "route_bgp_wrapped"))
+	if len(routes) != 1 || routes[0].BGP == nil {
+		t.Fatal("BGP route not parsed")
+	}
+
+	bgp := routes[0].BGP
+	if len(bgp.Communities) != 8 || bgp.Communities[7] != (Community{65000, 500}) {
+		t.Errorf("Wrapped communities parsed incorrectly: %v", bgp.Communities)
+	}
+	if len(bgp.LargeCommunities) != 3 {
+		t.Errorf("Wrapped large communities parsed incorrectly: %v", bgp.LargeCommunities)
+	}
+	if len(bgp.ExtCommunities) != 3 {
+		t.Errorf("Wrapped extended communities parsed incorrectly: %v", bgp.ExtCommunities)
+	}
+	if bgp.LocalPref == nil || *bgp.LocalPref != 100 {
+		t.Error("Attributes before wrapped lines parsed incorrectly")
+	}
+}
+
+func TestParseRoutesBird3(t *testing.T) {
+	routes := ParseRoutes(readTestData(t, "route_bgp_bird3"))
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route, got %d", len(routes))
+	}
+
+	route := routes[0]
+	if route.Since != "18:41:16.608" || route.From != "fe80::3391" {
+		t.Errorf("Route header parsed incorrectly: %+v", route)
+	}
+	if route.Source != "BGP" || route.BGP == nil {
+		t.Fatal("BGP attributes not parsed")
+	}
+	if !reflect.DeepEqual(route.BGP.ASPath, []uint32{4242423391, 4242420604, 4242423914}) {
+		t.Errorf("AS path parsed incorrectly: %v", route.BGP.ASPath)
+	}
+	if route.BGP.Origin != "IGP" {
+		t.Error("Origin parsed incorrectly")
+	}
+}
+
+func TestParseRoutesNotFound(t *testing.T) {
+	routes := ParseRoutes("Network not found\n")
+	if routes == nil || len(routes) != 0 {
+		t.Error("Should return empty list of routes")
+	}
+}
+
+func TestParseRoutesXSS(t *testing.T) {
+	routes := ParseRoutes(`<script>alert("evil!")</script>`)
+	if len(routes) != 0 {
+		t.Error("Parsed routes from invalid input")
+	}
+}
+
+func TestParseASPath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []uint32
+	}{
+		{"", []uint32{}},
+		{"4242423914", []uint32{4242423914}},
+		{"64512 64513", []uint32{64512, 64513}},
+		{"(65000 65001) 64512", []uint32{65000, 65001, 64512}},
+		{"64512 {64513 64514}", []uint32{64512, 64513, 64514}},
+	}
+
+	for _, tt := range tests {
+		result := ParseASPath(tt.input)
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("ParseASPath(%q) = %v, expected %v", tt.input, result, tt.expected)
+		}
+	}
+}
+
+func TestParseExtCommunities(t *testing.T) {
+	routes := ParseRoutes(strings.Join([]string{
+		"10.0.0.0/8           unicast [bgp1 2021-08-27] * (100) [AS64512i]",
+		"\tBGP.as_path: 64512",
+		"\tBGP.ext_community: (rt, 64512, 1) (ro, 64512, 2)",
+	}, "\n"))
+
+	if len(routes) != 1 || routes[0].BGP == nil {
+		t.Fatal("Route not parsed")
+	}
+	if !reflect.DeepEqual(routes[0].BGP.ExtCommunities, []string{"rt, 64512, 1", "ro, 64512, 2"}) {
+		t.Errorf("Extended communities parsed incorrectly: %v", routes[0].BGP.ExtCommunities)
+	}
+}
+
+func TestNexthopString(t *testing.T) {
+	if s := (Nexthop{Gateway: "10.0.0.1", Interface: "eth0"}).String(); s != "via 10.0.0.1 on eth0" {
+		t.Errorf("Unexpected nexthop string %s", s)
+	}
+	if s := (Nexthop{Interface: "eth0", Weight: 2}).String(); s != "dev eth0 weight 2" {
+		t.Errorf("Unexpected nexthop string %s", s)
+	}
+}
+
+func TestCommunityString(t *testing.T) {
+	if s := (Community{64511, 1}).String(); s != "(64511,1)" {
+		t.Errorf("Unexpected community string %s", s)
+	}
+	if s := (LargeCommunity{4242421080, 101, 44}).String(); s != "(4242421080, 101, 44)" {
+		t.Errorf("Unexpected large community string %s", s)
+	}
+}
+
+func TestASPathStrings(t *testing.T) {
+	attrs := BGPAttributes{ASPath: []uint32{64512, 4242423914}}
+	if !reflect.DeepEqual(attrs.ASPathStrings(), []string{"64512", "4242423914"}) {
+		t.Errorf("Unexpected AS path strings %v", attrs.ASPathStrings())
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/xddxdd/bird-lg-go/lib
+
+go 1.25.0
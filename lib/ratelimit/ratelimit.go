@@ -0,0 +1,203 @@
+// Package ratelimit limits requests of each client with token buckets.
+//
+// Each key, usually the IP address of a client, has a bucket holding up to
+// Rate.Count tokens, refilled evenly over Rate.Per. A request takes one or
+// more tokens, and is rejected if the bucket doesn't have enough.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is Count requests per duration Per, with bursts of up to Count
+// requests.
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// ParseRate parses a rate in the form of "N/duration", e.g. "10/1m" or
+// "10/m". The duration is a Go duration, its number can be omitted if 1.
+func ParseRate(s string) (Rate, error) {
+	countStr, perStr, found := strings.Cut(strings.TrimSpace(s), "/")
+	if !found {
+		return Rate{}, fmt.Errorf("invalid rate %q, must be in the form of N/duration, e.g. 10/1m", s)
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || count <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, count must be a positive integer", s)
+	}
+
+	perStr = strings.TrimSpace(perStr)
+	if perStr != "" && (perStr[0] < '0' || perStr[0] > '9') {
+		perStr = "1" + perStr
+	}
+	per, err := time.ParseDuration(perStr)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	if per <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate %q, duration must be positive", s)
+	}
+
+	return Rate{Count: count, Per: per}, nil
+}
+
+func (r Rate) String() string {
+	return strconv.Itoa(r.Count) + "/" + r.Per.String()
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter keeps a token bucket for each key. It's safe for concurrent use.
+type Limiter struct {
+	rate Rate
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastPurge time.Time
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewLimiter creates a Limiter allowing requests of each key at rate.
+func NewLimiter(rate Rate) *Limiter {
+	if rate.Count <= 0 || rate.Per <= 0 {
+		panic("ratelimit: invalid rate " + rate.String())
+	}
+	return &Limiter{
+		rate:    rate,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Rate returns the rate of the Limiter.
+func (l *Limiter) Rate() Rate {
+	return l.rate
+}
+
+// Allow is AllowN(key, 1).
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN takes n tokens from the bucket of key. If the bucket doesn't have
+// enough tokens, nothing is taken, and the time until it would have is
+// returned. Requests of more than Rate.Count tokens are never allowed.
+func (l *Limiter) AllowN(key string, n int) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	l.purge(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.rate.Count), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if n > l.rate.Count {
+		return false, l.rate.Per
+	}
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		return true, 0
+	}
+
+	missing := float64(n) - b.tokens
+	wait := time.Duration(missing / float64(l.rate.Count) * float64(l.rate.Per))
+	// Avoid rounding errors of floats, e.g. 1.000000001s
+	return false, max(wait.Round(time.Millisecond), time.Millisecond)
+}
+
+// Len returns the number of keys being tracked.
+func (l *Limiter) Len() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return len(l.buckets)
+}
+
+// Tokens in the bucket at now
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return b.tokens
+	}
+	tokens := b.tokens + elapsed.Seconds()/l.rate.Per.Seconds()*float64(l.rate.Count)
+	return math.Min(tokens, float64(l.rate.Count))
+}
+
+// Forget buckets that have been refilled, at most once per Rate.Per. They're
+// the same as new buckets.
+func (l *Limiter) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < l.rate.Per {
+		return
+	}
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.rate.Count) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPurge = now
+}
+
+// ParseLimits parses rates of each category, e.g. {"bird": "10/1m"}, and
+// creates a Limiter for each. Categories must be one of known.
+func ParseLimits(rates map[string]string, known []string) (map[string]*Limiter, error) {
+	result := make(map[string]*Limiter)
+	for category, rateStr := range rates {
+		valid := false
+		for _, k := range known {
+			if category == k {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown rate limit category %s, must be one of %s", category, strings.Join(known, ", "))
+		}
+
+		rate, err := ParseRate(rateStr)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit of %s: %w", category, err)
+		}
+		result[category] = NewLimiter(rate)
+	}
+	return result, nil
+}
+
+// ClientIP returns the IP of a client from its address, e.g. the RemoteAddr
+// of a HTTP request, as the key of its bucket. Addresses without an IP, e.g.
+// of unix sockets, are returned as is, and share a bucket.
+func ClientIP(remoteAddr string) string {
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if ip == nil {
+		return remoteAddr
+	}
+	return ip.String()
+}
+
+// RetryAfter formats a wait time returned by AllowN as the value of a
+// Retry-After header, in whole seconds rounded up.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
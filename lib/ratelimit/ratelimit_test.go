@@ -0,0 +1,199 @@
+package ratelimit
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Limiter with a clock controlled by the test
+func testLimiter(rate Rate) (*Limiter, *time.Time) {
+	l := NewLimiter(rate)
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Rate
+	}{
+		{"10/1m", Rate{10, time.Minute}},
+		{"10/m", Rate{10, time.Minute}},
+		{" 5 / 30s ", Rate{5, 30 * time.Second}},
+		{"100/1h30m", Rate{100, 90 * time.Minute}},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.input)
+		if err != nil || rate != tt.expected {
+			t.Errorf("ParseRate(%q) = %v, %v, expected %v", tt.input, rate, err, tt.expected)
+		}
+	}
+
+	for _, input := range []string{"", "10", "10/", "0/1m", "-1/1m", "x/1m", "10/0s", "10/forever"} {
+		if _, err := ParseRate(input); err == nil {
+			t.Errorf("ParseRate(%q) should fail", input)
+		}
+	}
+}
+
+func TestRateString(t *testing.T) {
+	if s := (Rate{10, time.Minute}).String(); s != "10/1m0s" {
+		t.Errorf("Unexpected string %s", s)
+	}
+}
+
+func TestAllowBurst(t *testing.T) {
+	l, _ := testLimiter(Rate{3, time.Minute})
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("Request %d should be allowed", i+1)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Error("Request over burst should be rejected")
+	}
+	if wait != 20*time.Second {
+		t.Errorf("Expected to wait 20s, got %s", wait)
+	}
+
+	// Other keys have their own buckets
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("Request of another key should be allowed")
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l, now := testLimiter(Rate{3, time.Minute})
+	l.AllowN("a", 3)
+
+	*now = now.Add(19 * time.Second)
+	if ok, wait := l.Allow("a"); ok || wait != time.Second {
+		t.Errorf("Request before refill should be rejected, got %v, wait %s", ok, wait)
+	}
+
+	*now = now.Add(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Request after refill should be allowed")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("Only one token should be refilled")
+	}
+
+	// Bucket doesn't exceed its size
+	*now = now.Add(time.Hour)
+	if ok, _ := l.AllowN("a", 3); !ok {
+		t.Error("Full bucket should allow a burst")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Error("Bucket should be empty after the burst")
+	}
+}
+
+func TestAllowN(t *testing.T) {
+	l, _ := testLimiter(Rate{5, time.Minute})
+	if ok, _ := l.AllowN("a", 4); !ok {
+		t.Error("Request of 4 tokens should be allowed")
+	}
+	// Nothing is taken from rejected requests
+	if ok, wait := l.AllowN("a", 3); ok || wait != 24*time.Second {
+		t.Errorf("Request of 3 tokens should be rejected, got %v, wait %s", ok, wait)
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Remaining token should be allowed")
+	}
+
+	if ok, wait := l.AllowN("b", 6); ok || wait != time.Minute {
+		t.Errorf("Request over the bucket size should be rejected, got %v, wait %s", ok, wait)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	l, now := testLimiter(Rate{2, time.Minute})
+	l.Allow("a")
+	l.AllowN("b", 2)
+	if l.Len() != 2 {
+		t.Fatalf("Expected 2 buckets, got %d", l.Len())
+	}
+
+	// Bucket of a is full again, b is still refilling
+	*now = now.Add(40 * time.Second)
+	l.Allow("c")
+	if l.Len() != 3 {
+		t.Errorf("Buckets shouldn't be purged before a full period, got %d", l.Len())
+	}
+
+	// Buckets of a and b are full, c is still refilling
+	*now = now.Add(20 * time.Second)
+	l.Allow("d")
+	if l.Len() != 2 {
+		t.Errorf("Full buckets should be purged, got %d", l.Len())
+	}
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	l := NewLimiter(Rate{100, time.Hour})
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	allowed := 0
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, _ := l.Allow("a"); ok {
+				lock.Lock()
+				allowed++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 100 {
+		t.Errorf("Expected 100 requests allowed, got %d", allowed)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits(map[string]string{"bird": "10/1m", "whois": "5/s"}, []string{"bird", "whois", "ping"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limits) != 2 || limits["bird"].Rate() != (Rate{10, time.Minute}) || limits["whois"].Rate() != (Rate{5, time.Second}) {
+		t.Errorf("Unexpected limits %v", limits)
+	}
+
+	if _, err := ParseLimits(map[string]string{"api": "10/1m"}, []string{"bird"}); err == nil || !strings.Contains(err.Error(), "unknown rate limit category api") {
+		t.Errorf("Unknown category should fail, got %v", err)
+	}
+	if _, err := ParseLimits(map[string]string{"bird": "10"}, []string{"bird"}); err == nil {
+		t.Error("Invalid rate should fail")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := map[string]string{
+		"192.0.2.1:12345":     "192.0.2.1",
+		"[2001:db8::1]:12345": "2001:db8::1",
+		"2001:db8::1":         "2001:db8::1",
+		"192.0.2.1":           "192.0.2.1",
+		"@":                   "@",
+		"":                    "",
+	}
+	for input, expected := range tests {
+		if result := ClientIP(input); result != expected {
+			t.Errorf("ClientIP(%q) = %q, expected %q", input, result, expected)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if s := RetryAfter(1500 * time.Millisecond); s != "2" {
+		t.Errorf("Expected 2, got %s", s)
+	}
+	if s := RetryAfter(20 * time.Second); s != "20" {
+		t.Errorf("Expected 20, got %s", s)
+	}
+}
@@ -0,0 +1,143 @@
+// Package reqsign signs requests from bird-lg-go frontend to
+// bird-lgproxy-go with a shared secret, and verifies them on the proxy.
+//
+// The signature is HMAC-SHA256 over the timestamp, a random nonce, the
+// request path and the query. The proxy rejects requests with timestamps
+// too far from its own clock, and nonces it has already seen.
+package reqsign
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers carrying the signature
+const (
+	HeaderTimestamp = "X-BirdLG-Timestamp"
+	HeaderNonce     = "X-BirdLG-Nonce"
+	HeaderSignature = "X-BirdLG-Signature"
+)
+
+// DefaultMaxSkew is the default tolerance of clock difference between
+// frontend and proxy.
+const DefaultMaxSkew = 30 * time.Second
+
+// Errors returned by Verify
+var (
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrExpired          = errors.New("request timestamp out of allowed clock skew")
+	ErrReplayed         = errors.New("request nonce already used")
+)
+
+// Signature computes the hex encoded signature of a request.
+// The query is canonicalized, so the order of parameters doesn't matter.
+func Signature(secret []byte, timestamp int64, nonce string, r *http.Request) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("\n" + nonce))
+	mac.Write([]byte("\n" + r.URL.Path))
+	mac.Write([]byte("\n" + r.URL.Query().Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign adds the timestamp, a random nonce and the signature to the headers
+// of the request.
+func Sign(r *http.Request, secret []byte) error {
+	nonceBytes := make([]byte, 16)
+	if _, err := rand.Read(nonceBytes); err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := time.Now().Unix()
+
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(HeaderNonce, nonce)
+	r.Header.Set(HeaderSignature, Signature(secret, timestamp, nonce, r))
+	return nil
+}
+
+// Verifier checks signatures of requests, and remembers nonces within the
+// allowed clock skew to reject replayed requests. It's safe for concurrent use.
+type Verifier struct {
+	secret  []byte
+	maxSkew time.Duration
+
+	lock      sync.Mutex
+	nonces    map[string]time.Time
+	lastPurge time.Time
+
+	// Replaced in tests
+	now func() time.Time
+}
+
+// NewVerifier creates a Verifier. A maxSkew of 0 or less uses DefaultMaxSkew.
+func NewVerifier(secret []byte, maxSkew time.Duration) *Verifier {
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &Verifier{
+		secret:  secret,
+		maxSkew: maxSkew,
+		nonces:  make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Verify checks the signature of the request. A request is only accepted
+// once, later requests with the same nonce return ErrReplayed.
+func (v *Verifier) Verify(r *http.Request) error {
+	timestampStr := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature := r.Header.Get(HeaderSignature)
+	if timestampStr == "" || nonce == "" || signature == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	expected := Signature(v.secret, timestamp, nonce, r)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	now := v.now()
+	signedAt := time.Unix(timestamp, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return ErrExpired
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.purge(now)
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	// Requests with this nonce are rejected as expired after this time,
+	// so it no longer needs to be remembered
+	v.nonces[nonce] = signedAt.Add(v.maxSkew)
+	return nil
+}
+
+// Forget expired nonces, at most once per maxSkew
+func (v *Verifier) purge(now time.Time) {
+	if now.Sub(v.lastPurge) < v.maxSkew {
+		return
+	}
+	for nonce, expiry := range v.nonces {
+		if now.After(expiry) {
+			delete(v.nonces, nonce)
+		}
+	}
+	v.lastPurge = now
+}
@@ -0,0 +1,150 @@
+package reqsign
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("secret")
+
+func signedRequest(t *testing.T, target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	if err := Sign(r, testSecret); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestSignVerify(t *testing.T) {
+	v := NewVerifier(testSecret, 0)
+	r := signedRequest(t, "/bird?q=show+protocols")
+	if err := v.Verify(r); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyQueryOrder(t *testing.T) {
+	v := NewVerifier(testSecret, 0)
+	r := signedRequest(t, "/traceroute?q=1.1.1.1&format=json")
+
+	// Query parameters reordered by an intermediate proxy
+	r2 := httptest.NewRequest(http.MethodGet, "/traceroute?format=json&q=1.1.1.1", nil)
+	r2.Header = r.Header
+	if err := v.Verify(r2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyMissing(t *testing.T) {
+	v := NewVerifier(testSecret, 0)
+	r := signedRequest(t, "/bird?q=show+protocols")
+	r.Header.Del(HeaderNonce)
+	if err := v.Verify(r); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("Expected ErrMissingSignature, got %v", err)
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	for name, modify := range map[string]func(r *http.Request){
+		"path": func(r *http.Request) {
+			r.URL.Path = "/traceroute"
+		},
+		"query": func(r *http.Request) {
+			r.URL.RawQuery = "q=show+route+all"
+		},
+		"nonce": func(r *http.Request) {
+			r.Header.Set(HeaderNonce, "0123456789abcdef")
+		},
+		"timestamp": func(r *http.Request) {
+			r.Header.Set(HeaderTimestamp, "invalid")
+		},
+		"signature": func(r *http.Request) {
+			r.Header.Set(HeaderSignature, "0000")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			v := NewVerifier(testSecret, 0)
+			r := signedRequest(t, "/bird?q=show+protocols")
+			modify(r)
+			if err := v.Verify(r); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyWrongSecret(t *testing.T) {
+	v := NewVerifier([]byte("another secret"), 0)
+	r := signedRequest(t, "/bird?q=show+protocols")
+	if err := v.Verify(r); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	for _, tt := range []struct {
+		offset time.Duration
+		err    error
+	}{
+		{0, nil},
+		{20 * time.Second, nil},
+		{-20 * time.Second, nil},
+		{time.Minute, ErrExpired},
+		{-time.Minute, ErrExpired},
+	} {
+		v := NewVerifier(testSecret, 30*time.Second)
+		v.now = func() time.Time { return time.Now().Add(tt.offset) }
+		r := signedRequest(t, "/bird?q=show+protocols")
+		if err := v.Verify(r); !errors.Is(err, tt.err) {
+			t.Errorf("Clock offset %s: expected %v, got %v", tt.offset, tt.err, err)
+		}
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	v := NewVerifier(testSecret, 0)
+	r := signedRequest(t, "/bird?q=show+protocols")
+	if err := v.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(r); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected ErrReplayed, got %v", err)
+	}
+
+	// Other requests are not affected
+	if err := v.Verify(signedRequest(t, "/bird?q=show+protocols")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyPurgeNonces(t *testing.T) {
+	now := time.Now()
+	v := NewVerifier(testSecret, 30*time.Second)
+	v.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		if err := v.Verify(signedRequest(t, "/bird?q=show+protocols")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(v.nonces) != 10 {
+		t.Errorf("Expected 10 nonces, got %d", len(v.nonces))
+	}
+
+	// All previous nonces expire
+	now = now.Add(2 * time.Minute)
+	r := httptest.NewRequest(http.MethodGet, "/bird?q=show+protocols", nil)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	r.Header.Set(HeaderNonce, "nonce")
+	r.Header.Set(HeaderSignature, Signature(testSecret, now.Unix(), "nonce", r))
+	if err := v.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.nonces) != 1 {
+		t.Errorf("Expected expired nonces to be purged, got %d", len(v.nonces))
+	}
+}
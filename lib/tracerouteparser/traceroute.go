@@ -0,0 +1,227 @@
+// Package tracerouteparser parses the text output of traceroute and mtr,
+// as run by bird-lgproxy-go, into hop records.
+package tracerouteparser
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Output formats recognized by the parser.
+const (
+	FormatTraceroute = "traceroute"
+	FormatMTR        = "mtr"
+)
+
+// Hop is a single hop of traceroute or mtr output.
+//
+// If multiple routers responded to the probes of the same hop (e.g. with
+// ECMP), the address and hostname are of the first one, while RTT contains
+// samples from all of them.
+type Hop struct {
+	Hop      int       `json:"hop"`
+	Address  string    `json:"address,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	ASN      uint32    `json:"asn,omitempty"`
+	RTT      []float64 `json:"rtt"`
+	Loss     float64   `json:"loss"`
+	MTR      *MTRStats `json:"mtr,omitempty"`
+}
+
+// MTRStats are the statistics columns of mtr report. RTTs are in milliseconds.
+type MTRStats struct {
+	Sent  int     `json:"sent"`
+	Last  float64 `json:"last"`
+	Avg   float64 `json:"avg"`
+	Best  float64 `json:"best"`
+	Worst float64 `json:"worst"`
+	StDev float64 `json:"stdev"`
+}
+
+// Result is the parsed output of a traceroute or mtr run.
+type Result struct {
+	Format string `json:"format"`
+	Hops   []Hop  `json:"hops"`
+}
+
+// Responding returns whether any probe of the hop got a response.
+func (h Hop) Responding() bool {
+	return h.Address != "" || h.Hostname != ""
+}
+
+// A hop of traceroute, for example:
+//
+//	 3  one.one.one.one (1.1.1.1) [AS13335]  10.123 ms  10.456 ms *
+var tracerouteHopRe = regexp.MustCompile(`^\s*(\d+)\s+(.*)$`)
+
+// A hop of mtr -w report, for example:
+//
+//	  3.|-- AS13335  one.one.one.one (1.1.1.1)   0.0%     1   10.1  10.1  10.1  10.1   0.0
+var mtrHopRe = regexp.MustCompile(`^\s*(\d+)\.\|--\s+(.*?)\s+([\d.]+)%?\s+(\d+)\s+([\d.]+)\s+([\d.]+)\s+([\d.]+)\s+([\d.]+)\s+([\d.]+)\s*$`)
+
+var asnRe = regexp.MustCompile(`^\[?AS(\d+)(?:/.*)?\]?$`)
+
+func parseASN(token string) (uint32, bool) {
+	match := asnRe.FindStringSubmatch(token)
+	if match == nil {
+		return 0, false
+	}
+	asn, err := strconv.ParseUint(match[1], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(asn), true
+}
+
+func isIP(s string) bool {
+	if pos := strings.Index(s, "%"); pos != -1 {
+		s = s[:pos]
+	}
+	return net.ParseIP(s) != nil
+}
+
+func parseFloat(s string) float64 {
+	value, _ := strconv.ParseFloat(s, 64)
+	return value
+}
+
+// Set hostname and address of a hop from "hostname (address)" or "address" notation
+func (h *Hop) setHost(host string, address string) {
+	if address != "" {
+		h.Address = address
+		if host != address {
+			h.Hostname = host
+		}
+	} else if isIP(host) {
+		h.Address = host
+	} else {
+		h.Hostname = host
+	}
+}
+
+func parseTracerouteHop(line string) *Hop {
+	match := tracerouteHopRe.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+
+	hop := Hop{RTT: []float64{}}
+	hop.Hop, _ = strconv.Atoi(match[1])
+
+	tokens := strings.Fields(match[2])
+	probes := 0
+	lost := 0
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch {
+		case token == "*":
+			probes++
+			lost++
+		case i+1 < len(tokens) && tokens[i+1] == "ms":
+			// RTT sample, skip the unit
+			if rtt, err := strconv.ParseFloat(token, 64); err == nil {
+				hop.RTT = append(hop.RTT, rtt)
+				probes++
+			}
+			i++
+		case strings.HasPrefix(token, "!"):
+			// Annotations like !H, !N
+		case strings.HasPrefix(token, "["):
+			if asn, ok := parseASN(token); ok && hop.ASN == 0 {
+				hop.ASN = asn
+			}
+		case strings.HasPrefix(token, "(") && strings.HasSuffix(token, ")"):
+			// Address of the host before it, handled together with the host
+		default:
+			// Only the first responding router is recorded
+			if hop.Responding() {
+				continue
+			}
+			address := ""
+			if i+1 < len(tokens) && strings.HasPrefix(tokens[i+1], "(") && strings.HasSuffix(tokens[i+1], ")") {
+				address = strings.Trim(tokens[i+1], "()")
+			}
+			hop.setHost(token, address)
+		}
+	}
+
+	// Not a hop line if no probe result is found
+	if probes == 0 {
+		return nil
+	}
+	hop.Loss = float64(lost) * 100 / float64(probes)
+	return &hop
+}
+
+func parseMTRHop(line string) *Hop {
+	match := mtrHopRe.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+
+	hop := Hop{RTT: []float64{}}
+	hop.Hop, _ = strconv.Atoi(match[1])
+
+	tokens := strings.Fields(match[2])
+	if len(tokens) > 0 && strings.HasPrefix(tokens[0], "AS") {
+		hop.ASN, _ = parseASN(tokens[0])
+		tokens = tokens[1:]
+	}
+	if len(tokens) > 0 && tokens[0] != "???" {
+		address := ""
+		if len(tokens) > 1 {
+			address = strings.Trim(tokens[1], "()")
+		}
+		hop.setHost(tokens[0], address)
+	}
+
+	hop.Loss = parseFloat(match[3])
+	hop.MTR = &MTRStats{
+		Last:  parseFloat(match[5]),
+		Avg:   parseFloat(match[6]),
+		Best:  parseFloat(match[7]),
+		Worst: parseFloat(match[8]),
+		StDev: parseFloat(match[9]),
+	}
+	hop.MTR.Sent, _ = strconv.Atoi(match[4])
+	if hop.Responding() && hop.Loss < 100 {
+		hop.RTT = append(hop.RTT, hop.MTR.Last)
+	}
+	return &hop
+}
+
+// Parse parses the output of traceroute or mtr (report mode, -w).
+// The format is detected automatically. Lines that are not recognized
+// (e.g. headers and error messages) are skipped.
+func Parse(output string) Result {
+	result := Result{
+		Format: FormatTraceroute,
+		Hops:   []Hop{},
+	}
+
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "HOST:") || mtrHopRe.MatchString(line) {
+			result.Format = FormatMTR
+			break
+		}
+	}
+
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+
+		var hop *Hop
+		if result.Format == FormatMTR {
+			hop = parseMTRHop(line)
+		} else {
+			hop = parseTracerouteHop(line)
+		}
+		if hop != nil {
+			result.Hops = append(result.Hops, *hop)
+		}
+	}
+
+	return result
+}
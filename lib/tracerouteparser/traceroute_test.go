@@ -0,0 +1,160 @@
+package tracerouteparser
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// Compare the JSON representation of result with testdata/<name>.golden.json
+func assertGolden(t *testing.T, name string, result interface{}) {
+	actual, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual = append(actual, '\n')
+
+	goldenPath := filepath.Join("testdata", name+".golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expected) != string(actual) {
+		t.Errorf("Result of %s doesn't match golden file, got:\n%s", name, actual)
+	}
+}
+
+func readTestData(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join("testdata", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseGolden(t *testing.T) {
+	for _, name := range []string{
+		"traceroute_linux",
+		"traceroute_multi",
+		"traceroute_asn",
+		"mtr_report",
+		"mtr_report_no_asn",
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertGolden(t, name, Parse(readTestData(t, name)))
+		})
+	}
+}
+
+func TestParseTraceroute(t *testing.T) {
+	result := Parse(readTestData(t, "traceroute_linux"))
+	if result.Format != FormatTraceroute {
+		t.Errorf("Format detected incorrectly: %s", result.Format)
+	}
+	if len(result.Hops) != 5 {
+		t.Fatalf("Expected 5 hops, got %d", len(result.Hops))
+	}
+
+	hop := result.Hops[0]
+	if hop.Hop != 1 || hop.Hostname != "_gateway" || hop.Address != "192.168.1.1" {
+		t.Errorf("Hop parsed incorrectly: %+v", hop)
+	}
+	if !reflect.DeepEqual(hop.RTT, []float64{0.456}) || hop.Loss != 0 {
+		t.Errorf("RTT parsed incorrectly: %+v", hop)
+	}
+
+	hop = result.Hops[1]
+	if hop.Responding() || hop.Loss != 100 || len(hop.RTT) != 0 {
+		t.Errorf("Hop not responding parsed incorrectly: %+v", hop)
+	}
+
+	// Hostname is omitted if it's the same as the address
+	if result.Hops[2].Hostname != "" || result.Hops[2].Address != "10.0.0.1" {
+		t.Errorf("Numeric hop parsed incorrectly: %+v", result.Hops[2])
+	}
+}
+
+func TestParseTracerouteMultipleResponders(t *testing.T) {
+	result := Parse(readTestData(t, "traceroute_multi"))
+	if len(result.Hops) != 4 {
+		t.Fatalf("Expected 4 hops, got %d", len(result.Hops))
+	}
+
+	hop := result.Hops[1]
+	if hop.Address != "2001:db8:2::1" {
+		t.Errorf("First responder not recorded: %+v", hop)
+	}
+	if !reflect.DeepEqual(hop.RTT, []float64{3.001, 3.120}) {
+		t.Errorf("RTT of all responders not recorded: %+v", hop.RTT)
+	}
+	if hop.Loss < 33 || hop.Loss > 34 {
+		t.Errorf("Loss calculated incorrectly: %f", hop.Loss)
+	}
+
+	// Annotations are skipped
+	if len(result.Hops[3].RTT) != 3 {
+		t.Errorf("RTT with annotations parsed incorrectly: %+v", result.Hops[3].RTT)
+	}
+}
+
+func TestParseTracerouteASN(t *testing.T) {
+	result := Parse(readTestData(t, "traceroute_asn"))
+	if result.Hops[0].ASN != 0 || result.Hops[1].ASN != 64496 || result.Hops[2].ASN != 13335 {
+		t.Errorf("ASN parsed incorrectly: %+v", result.Hops)
+	}
+}
+
+func TestParseMTR(t *testing.T) {
+	result := Parse(readTestData(t, "mtr_report"))
+	if result.Format != FormatMTR {
+		t.Errorf("Format detected incorrectly: %s", result.Format)
+	}
+	if len(result.Hops) != 4 {
+		t.Fatalf("Expected 4 hops, got %d", len(result.Hops))
+	}
+
+	hop := result.Hops[2]
+	if hop.Hop != 3 || hop.ASN != 64496 || hop.Hostname != "ae1.core1.example.net" || hop.Address != "203.0.113.1" {
+		t.Errorf("Hop parsed incorrectly: %+v", hop)
+	}
+	if hop.MTR == nil || hop.MTR.Sent != 1 || hop.MTR.Avg != 8.9 {
+		t.Errorf("MTR stats parsed incorrectly: %+v", hop.MTR)
+	}
+
+	hop = result.Hops[1]
+	if hop.Responding() || hop.Loss != 100 || len(hop.RTT) != 0 {
+		t.Errorf("Hop not responding parsed incorrectly: %+v", hop)
+	}
+}
+
+func TestParseMTRLoss(t *testing.T) {
+	result := Parse(readTestData(t, "mtr_report_no_asn"))
+	if result.Hops[1].Loss != 33.3 || result.Hops[1].ASN != 0 {
+		t.Errorf("Hop parsed incorrectly: %+v", result.Hops[1])
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, output := range []string{
+		"",
+		"traceroute: unknown host",
+		"Error executing traceroute: exit status 1",
+		`<script>alert("evil!")</script>`,
+	} {
+		result := Parse(output)
+		if len(result.Hops) != 0 {
+			t.Errorf("Parsed hops from invalid input %q", output)
+		}
+	}
+}
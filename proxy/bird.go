@@ -3,16 +3,17 @@ package main
 import (
 	"bytes"
 	"io"
-	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
 )
 
 const MAX_LINE_SIZE = 1024
 
-// Read a line from bird socket, removing preceding status number, output it.
-// Returns if there are more lines.
-func birdReadln(bird io.Reader, w io.Writer) bool {
+// Read a raw line from bird socket, without the trailing newline character.
+// Lines longer than MAX_LINE_SIZE are cut off.
+func birdReadRawln(bird io.Reader) ([]byte, error) {
 	// Read from socket byte by byte, until reaching newline character
 	c := make([]byte, MAX_LINE_SIZE)
 	pos := 0
@@ -23,27 +24,42 @@ func birdReadln(bird io.Reader, w io.Writer) bool {
 		}
 		_, err := bird.Read(c[pos : pos+1])
 		if err != nil {
-			w.Write([]byte(err.Error()))
-			return false
+			return nil, err
 		}
 		if c[pos] == byte('\n') {
 			break
 		}
 		pos++
 	}
+	return c[:pos], nil
+}
+
+// Check if a line read from bird socket starts with a 4 digit status number
+func birdHasReplyCode(c []byte) bool {
+	return len(c) > 4 && isNumeric(c[0]) && isNumeric(c[1]) && isNumeric(c[2]) && isNumeric(c[3])
+}
+
+// Check if a status number marks the end of a reply:
+// 0xxx for success, 8xxx for runtime errors and 9xxx for parse errors
+func birdIsFinalReplyCode(c []byte) bool {
+	return c[0] == byte('0') || c[0] == byte('8') || c[0] == byte('9')
+}
 
-	c = c[:pos+1]
-	c[pos] = '\n'
+// Output a line read from bird socket, removing preceding status number.
+// Returns if there are more lines.
+func birdOutputln(line []byte, w io.Writer) bool {
+	c := append(line, '\n')
+	pos := len(line)
 	// print(string(c[:]))
 
 	// Remove preceding status number, different situations
-	if pos > 4 && isNumeric(c[0]) && isNumeric(c[1]) && isNumeric(c[2]) && isNumeric(c[3]) {
+	if birdHasReplyCode(line) {
 		// There is a status number at beginning, remove first 5 bytes
 		if w != nil && pos > 6 {
 			pos = 5
 			w.Write(c[pos:])
 		}
-		return c[0] != byte('0') && c[0] != byte('8') && c[0] != byte('9')
+		return !birdIsFinalReplyCode(c)
 	} else {
 		if w != nil {
 			w.Write(c[1:])
@@ -52,62 +68,183 @@ func birdReadln(bird io.Reader, w io.Writer) bool {
 	}
 }
 
+// Read a line from bird socket, removing preceding status number, output it.
+// Returns if there are more lines.
+func birdReadln(bird io.Reader, w io.Writer) bool {
+	line, err := birdReadRawln(bird)
+	if err != nil {
+		if w != nil {
+			w.Write([]byte(err.Error()))
+		}
+		return false
+	}
+	return birdOutputln(line, w)
+}
+
+// Read the remaining lines of a reply from bird socket, removing preceding status numbers, output them.
+// Returns error if the reply is cut off before its end.
+func birdCopyReply(bird io.Reader, w io.Writer) error {
+	for {
+		line, err := birdReadRawln(bird)
+		if err != nil {
+			w.Write([]byte(err.Error()))
+			return err
+		}
+		if !birdOutputln(line, w) {
+			return nil
+		}
+	}
+}
+
 // Write a command to a bird socket
 func birdWriteln(bird io.Writer, s string) {
 	bird.Write([]byte(s + "\n"))
 }
 
-// List of allowed bird commands when restriction is enabled
-var allowedBirdCommands = []string{
-	"show protocols",
-	"show route",
+// Output is flushed once this much is pending, or after flushInterval
+const (
+	flushSize     = 16 * 1024
+	flushInterval = 100 * time.Millisecond
+)
+
+// Writer that flushes HTTP response shortly after writes, so output is
+// streamed to the client while the command is still running, without a
+// flush for every line. Close must be called before the handler returns.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+
+	lock    sync.Mutex
+	pending int
+	timer   *time.Timer
+	closed  bool
 }
 
-// Check if a bird command is allowed based on the allowed commands list
-// Accepts exact match or command followed by space and parameters
-func isBirdCommandAllowed(query string) bool {
-	for _, cmd := range allowedBirdCommands {
-		if query == cmd || strings.HasPrefix(query, cmd+" ") {
-			return true
-		}
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+
+	n, err := fw.w.Write(p)
+	if fw.f == nil || fw.closed {
+		return n, err
+	}
+	fw.pending += n
+	if fw.pending >= flushSize {
+		fw.flushLocked()
+	} else if fw.timer == nil {
+		fw.timer = time.AfterFunc(flushInterval, fw.flushPending)
 	}
-	return false
+	return n, err
+}
+
+func (fw *flushWriter) flushLocked() {
+	if fw.timer != nil {
+		fw.timer.Stop()
+		fw.timer = nil
+	}
+	fw.pending = 0
+	fw.f.Flush()
+}
+
+func (fw *flushWriter) flushPending() {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	if !fw.closed && fw.pending > 0 {
+		fw.flushLocked()
+	}
+}
+
+// Flush the remaining output, the writer must not be used afterwards
+func (fw *flushWriter) Close() error {
+	fw.lock.Lock()
+	defer fw.lock.Unlock()
+	if fw.f != nil && !fw.closed && fw.pending > 0 {
+		fw.flushLocked()
+	}
+	fw.closed = true
+	return nil
+}
+
+// Wrap a HTTP response writer to flush shortly after writes, if supported
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	fw := &flushWriter{w: w}
+	if f, ok := w.(http.Flusher); ok {
+		fw.f = f
+	}
+	return fw
 }
 
 // Handles BIRDv4 queries
 func birdHandler(httpW http.ResponseWriter, httpR *http.Request) {
 	query := string(httpR.URL.Query().Get("q"))
+	jsonFormat := httpR.URL.Query().Get("format") == "json"
+
+	// Report errors in the requested format
+	writeError := func(status int, message string) {
+		if jsonFormat {
+			birdWriteJSONError(httpW, status, query, strings.TrimSpace(message))
+		} else {
+			httpW.WriteHeader(status)
+			httpW.Write([]byte(message))
+		}
+	}
+
 	if query == "" {
 		invalidHandler(httpW, httpR)
 	} else {
-		// Check if command restriction is enabled
-		if setting.birdRestrictCmds {
-			if !isBirdCommandAllowed(query) {
-				httpW.WriteHeader(http.StatusForbidden)
-				httpW.Write([]byte("Forbidden: only 'show protocols' and 'show route' commands are allowed\n"))
-				return
-			}
-		}
-		// Initialize BIRDv4 socket
-		bird, err := net.Dial("unix", setting.birdSocket)
+		tokens, err := birdTokenize(query)
 		if err != nil {
-			httpW.WriteHeader(http.StatusInternalServerError)
-			httpW.Write([]byte(err.Error()))
+			writeError(http.StatusBadRequest, "Invalid command: "+err.Error()+"\n")
+			return
+		}
+		if len(tokens) == 0 {
+			invalidHandler(httpW, httpR)
 			return
 		}
-		defer bird.Close()
-
-		birdReadln(bird, nil)
-		birdWriteln(bird, "restrict")
-		var restrictedConfirmation bytes.Buffer
-		birdReadln(bird, &restrictedConfirmation)
-		if !strings.Contains(restrictedConfirmation.String(), "Access restricted") {
-			httpW.WriteHeader(http.StatusInternalServerError)
-			httpW.Write([]byte("could not verify that bird access was restricted"))
+
+		// Check allowlist if command restriction is enabled, denylist is always checked
+		if (setting.birdRestrictCmds && !isBirdCommandTokensAllowed(tokens)) || isBirdCommandTokensDenied(tokens) {
+			writeError(http.StatusForbidden, "Forbidden: this command is not allowed on this node\n")
+			return
+		}
+
+		// Send the normalized command, so bird gets exactly what has been checked
+		query = strings.Join(tokens, " ")
+
+		// Get a restricted BIRDv4 socket and send the query
+		bird, firstLine, err := birdQuery(httpR.Context(), query)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
 			return
 		}
-		birdWriteln(bird, query)
-		for birdReadln(bird, httpW) {
+
+		var replyErr error
+		if jsonFormat {
+			var lines []birdReplyLine
+			lines, replyErr = birdReadReply(io.MultiReader(bytes.NewReader(append(firstLine, '\n')), bird.conn))
+			response := birdBuildJSONResponse(query, lines)
+			status := birdJSONStatus(response)
+			if replyErr != nil {
+				status = http.StatusInternalServerError
+				if response.Error == nil {
+					response.Error = &birdReplyError{Message: replyErr.Error()}
+				}
+			}
+			birdWriteJSON(httpW, status, response)
+		} else {
+			// Stream output line by line, as large replies may take a while
+			out := newFlushWriter(httpW)
+			if birdOutputln(firstLine, out) {
+				replyErr = birdCopyReply(bird.conn, out)
+			}
+			out.Close()
+		}
+
+		if replyErr != nil {
+			metricBirdSocketErrors.WithLabelValues("reply").Inc()
 		}
+
+		// Only reuse the connection if the whole reply has been read
+		birdRelease(bird, replyErr == nil)
 	}
 }
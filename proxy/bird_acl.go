@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Commands allowed by default when restriction is enabled
+var defaultBirdAllowedCmds = []string{
+	"show protocols ...",
+	"show route ...",
+}
+
+// A pattern of bird command, matched token by token against a query.
+// "*" matches exactly one token, "..." matches zero or more tokens,
+// any other token must match exactly.
+type birdCommandPattern []string
+
+const (
+	birdPatternAnyToken  = "*"
+	birdPatternAnyTokens = "..."
+)
+
+// Split a bird command into tokens. Tokens are separated by whitespaces,
+// and quoted strings (e.g. protocol names) are kept as a single token
+// including the quotes. Control characters are rejected, as a newline
+// would allow sending multiple commands in one query.
+func birdTokenize(query string) ([]string, error) {
+	var tokens []string
+	var token strings.Builder
+	var quote rune
+
+	for _, c := range query {
+		if unicode.IsControl(c) && c != '\t' {
+			return nil, errors.New("command contains control characters")
+		}
+
+		switch {
+		case quote != 0:
+			token.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			token.WriteRune(c)
+			quote = c
+		case unicode.IsSpace(c):
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(c)
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("command contains unterminated quote")
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens, nil
+}
+
+func parseBirdCommandPattern(pattern string) (birdCommandPattern, error) {
+	tokens, err := birdTokenize(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+	return birdCommandPattern(tokens), nil
+}
+
+// Parse a list of patterns, skipping invalid ones
+func parseBirdCommandPatterns(patterns []string) []birdCommandPattern {
+	result := []birdCommandPattern{}
+	for _, pattern := range patterns {
+		parsed, err := parseBirdCommandPattern(pattern)
+		if err != nil {
+			fmt.Printf("Failed to parse command pattern %q: %s\n", pattern, err.Error())
+			continue
+		}
+		result = append(result, parsed)
+	}
+	return result
+}
+
+// Check if the tokens of a command match the pattern
+func (p birdCommandPattern) matches(tokens []string, caseSensitive bool) bool {
+	if len(p) == 0 {
+		return len(tokens) == 0
+	}
+
+	switch p[0] {
+	case birdPatternAnyTokens:
+		for i := 0; i <= len(tokens); i++ {
+			if p[1:].matches(tokens[i:], caseSensitive) {
+				return true
+			}
+		}
+		return false
+	case birdPatternAnyToken:
+		return len(tokens) > 0 && p[1:].matches(tokens[1:], caseSensitive)
+	default:
+		if len(tokens) == 0 {
+			return false
+		}
+		if caseSensitive && p[0] != tokens[0] {
+			return false
+		}
+		if !caseSensitive && !strings.EqualFold(p[0], tokens[0]) {
+			return false
+		}
+		return p[1:].matches(tokens[1:], caseSensitive)
+	}
+}
+
+func birdAllowedPatterns() []birdCommandPattern {
+	if setting.birdAllowedCmds == nil {
+		return parseBirdCommandPatterns(defaultBirdAllowedCmds)
+	}
+	return setting.birdAllowedCmds
+}
+
+// Check if a tokenized command is on the allowlist.
+// Allowlist is matched case sensitively, to be on the safe side.
+func isBirdCommandTokensAllowed(tokens []string) bool {
+	for _, pattern := range birdAllowedPatterns() {
+		if pattern.matches(tokens, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a tokenized command is on the denylist.
+// Bird keywords are case insensitive, so is the denylist.
+func isBirdCommandTokensDenied(tokens []string) bool {
+	for _, pattern := range setting.birdDeniedCmds {
+		if pattern.matches(tokens, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check if a bird command is allowed based on the allowlist and denylist
+func isBirdCommandAllowed(query string) bool {
+	tokens, err := birdTokenize(query)
+	if err != nil {
+		return false
+	}
+	return isBirdCommandTokensAllowed(tokens) && !isBirdCommandTokensDenied(tokens)
+}
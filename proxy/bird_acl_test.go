@@ -0,0 +1,157 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestBirdTokenize(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"show protocols", []string{"show", "protocols"}},
+		{"  show   route\tfor 1.1.1.1  ", []string{"show", "route", "for", "1.1.1.1"}},
+		{"show protocols all 'bgp peer'", []string{"show", "protocols", "all", "'bgp peer'"}},
+		{`show route protocol "a  b"`, []string{"show", "route", "protocol", `"a  b"`}},
+		{"show route where bgp_path.last = 64512", []string{"show", "route", "where", "bgp_path.last", "=", "64512"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		tokens, err := birdTokenize(tt.query)
+		if err != nil {
+			t.Errorf("Failed to tokenize %q: %s", tt.query, err)
+		}
+		if !reflect.DeepEqual(tokens, tt.expected) {
+			t.Errorf("birdTokenize(%q) = %q, expected %q", tt.query, tokens, tt.expected)
+		}
+	}
+}
+
+func TestBirdTokenizeInvalid(t *testing.T) {
+	for _, query := range []string{
+		"show route\nconfigure",
+		"show route\rconfigure",
+		"show route\x00",
+		"show protocols all 'unterminated",
+	} {
+		if _, err := birdTokenize(query); err == nil {
+			t.Errorf("Should fail to tokenize %q", query)
+		}
+	}
+}
+
+func TestBirdCommandPatternMatches(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		query    string
+		expected bool
+	}{
+		{"show status", "show status", true},
+		{"show status", "show status all", false},
+		{"show status", "show", false},
+		{"show ospf *", "show ospf state", true},
+		{"show ospf *", "show ospf", false},
+		{"show ospf *", "show ospf state all", false},
+		{"show route ...", "show route", true},
+		{"show route ...", "show route for 1.1.1.1 all", true},
+		{"show route ... export ...", "show route export bgp1", true},
+		{"show route ... export ...", "show route all export bgp1", true},
+		{"show route ... export ...", "show route for 1.1.1.1", false},
+		{"show protocols all *", "show protocols all 'bgp peer'", true},
+	}
+
+	for _, tt := range tests {
+		pattern, err := parseBirdCommandPattern(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, _ := birdTokenize(tt.query)
+		if pattern.matches(tokens, true) != tt.expected {
+			t.Errorf("Pattern %q matching %q should be %v", tt.pattern, tt.query, tt.expected)
+		}
+	}
+}
+
+func TestBirdCommandPatternCaseInsensitive(t *testing.T) {
+	pattern, _ := parseBirdCommandPattern("show route ... export ...")
+	tokens, _ := birdTokenize("SHOW Route EXPORT bgp1")
+
+	assert.Equal(t, pattern.matches(tokens, true), false)
+	assert.Equal(t, pattern.matches(tokens, false), true)
+}
+
+func TestParseBirdCommandPatternsSkipsInvalid(t *testing.T) {
+	patterns := parseBirdCommandPatterns([]string{"show status", "", "show 'broken"})
+	assert.Equal(t, len(patterns), 1)
+}
+
+func TestIsBirdCommandAllowedCustomLists(t *testing.T) {
+	setting.birdAllowedCmds = parseBirdCommandPatterns([]string{"show status", "show bfd sessions", "show route ..."})
+	setting.birdDeniedCmds = parseBirdCommandPatterns([]string{"show route ... export ..."})
+	defer func() {
+		setting.birdAllowedCmds = nil
+		setting.birdDeniedCmds = nil
+	}()
+
+	assert.Equal(t, isBirdCommandAllowed("show status"), true)
+	assert.Equal(t, isBirdCommandAllowed("show   bfd  sessions"), true)
+	assert.Equal(t, isBirdCommandAllowed("show route for 1.1.1.1"), true)
+	assert.Equal(t, isBirdCommandAllowed("show route export bgp1"), false)
+	assert.Equal(t, isBirdCommandAllowed("show route  EXPORT bgp1"), false)
+	assert.Equal(t, isBirdCommandAllowed("show protocols"), false)
+	assert.Equal(t, isBirdCommandAllowed("show status\nconfigure"), false)
+}
+
+func TestBirdHandlerDeniedWithoutRestriction(t *testing.T) {
+	setting.birdRestrictCmds = false
+	setting.birdDeniedCmds = parseBirdCommandPatterns([]string{"configure ..."})
+	defer func() { setting.birdDeniedCmds = nil }()
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("configure soft"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusForbidden)
+}
+
+func TestBirdHandlerRejectsControlCharacters(t *testing.T) {
+	setting.birdSocket = "/any/path" // Won't be used since we return 400 before connecting
+	setting.birdRestrictCmds = false
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("show status\ndown"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, strings.Contains(w.Body.String(), "control characters"), true)
+}
+
+func TestBirdHandlerSendsNormalizedCommand(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show route for 1.1.1.1",
+		response:      "Mock Response",
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+	setting.birdRestrictCmds = true
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("  show  route\tfor 1.1.1.1 "), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "Mock Response\n")
+}
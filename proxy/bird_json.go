@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
+
+// A line of bird reply, with its status number kept
+type birdReplyLine struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+}
+
+type birdReplyError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type birdJSONResponse struct {
+	Command   string                `json:"command"`
+	Lines     []birdReplyLine       `json:"lines"`
+	Protocols []birdparser.Protocol `json:"protocols,omitempty"`
+	Routes    []birdparser.Route    `json:"routes,omitempty"`
+	Error     *birdReplyError       `json:"error,omitempty"`
+}
+
+// Reply codes from BIRD's doc/reply_codes
+const (
+	birdCodeProtocolList      = 1002
+	birdCodeProtocolDetails   = 1006
+	birdCodeRouteList         = 1007
+	birdCodeRouteDetails      = 1008
+	birdCodeRouteExtendedAttr = 1012
+	birdCodeRuntimeError      = 8000
+	birdCodeRouteNotFound     = 8001
+	birdCodeNoProtocolsMatch  = 8003
+	birdCodeAccessDenied      = 8007
+	birdCodeParseError        = 9000
+)
+
+// Read a line from bird socket, keeping the status number.
+// Continuation lines without a status number inherit the code of the previous line.
+// Returns the line, and if there are more lines.
+func birdReadReplyLine(bird io.Reader, lastCode int) (birdReplyLine, bool, error) {
+	line, err := birdReadRawln(bird)
+	if err != nil {
+		return birdReplyLine{}, false, err
+	}
+
+	if birdHasReplyCode(line) {
+		code, _ := strconv.Atoi(string(line[0:4]))
+		return birdReplyLine{Code: code, Text: string(line[5:])}, !birdIsFinalReplyCode(line), nil
+	}
+
+	if len(line) == 0 {
+		return birdReplyLine{Code: lastCode}, true, nil
+	}
+	return birdReplyLine{Code: lastCode, Text: string(line[1:])}, true, nil
+}
+
+// Read a full reply from bird socket
+func birdReadReply(bird io.Reader) ([]birdReplyLine, error) {
+	var lines []birdReplyLine
+	code := 0
+	for {
+		line, more, err := birdReadReplyLine(bird, code)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+		code = line.Code
+		if !more {
+			return lines, nil
+		}
+	}
+}
+
+// Extract protocols from lines of protocol list and protocol details
+func birdParseProtocols(lines []birdReplyLine) []birdparser.Protocol {
+	var protocolLines []string
+	for _, line := range lines {
+		switch line.Code {
+		case birdCodeProtocolList, birdCodeProtocolDetails:
+			protocolLines = append(protocolLines, line.Text)
+		}
+	}
+	if len(protocolLines) == 0 {
+		return nil
+	}
+	protocols := birdparser.ParseProtocols(strings.Join(protocolLines, "\n"))
+	if len(protocols) == 0 {
+		return nil
+	}
+	return protocols
+}
+
+// Extract route entries from lines of route list, route details and extended attributes
+func birdParseRoutes(lines []birdReplyLine) []birdparser.Route {
+	var routeLines []string
+	for _, line := range lines {
+		switch line.Code {
+		case birdCodeRouteList, birdCodeRouteDetails, birdCodeRouteExtendedAttr:
+			routeLines = append(routeLines, line.Text)
+		}
+	}
+	if len(routeLines) == 0 {
+		return nil
+	}
+	return birdparser.ParseRoutes(strings.Join(routeLines, "\n"))
+}
+
+// Convert the reply of a bird command to typed records
+func birdBuildJSONResponse(command string, lines []birdReplyLine) birdJSONResponse {
+	response := birdJSONResponse{
+		Command: command,
+		Lines:   lines,
+	}
+	if response.Lines == nil {
+		response.Lines = []birdReplyLine{}
+	}
+
+	for _, line := range lines {
+		if line.Code >= birdCodeRuntimeError && response.Error == nil {
+			response.Error = &birdReplyError{
+				Code:    line.Code,
+				Message: line.Text,
+			}
+		}
+	}
+
+	response.Protocols = birdParseProtocols(lines)
+	response.Routes = birdParseRoutes(lines)
+	return response
+}
+
+// HTTP status of a response, so errors reported by bird aren't mistaken for
+// success: 400 for parse errors, 403 for denied access, 404 if nothing
+// matched, and 500 for other runtime errors
+func birdJSONStatus(response birdJSONResponse) int {
+	if response.Error == nil {
+		return http.StatusOK
+	}
+	switch code := response.Error.Code; {
+	case code >= birdCodeParseError:
+		return http.StatusBadRequest
+	case code == birdCodeAccessDenied:
+		return http.StatusForbidden
+	case code == birdCodeRouteNotFound || code == birdCodeNoProtocolsMatch:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// Write a JSON response for the bird endpoint
+func birdWriteJSON(httpW http.ResponseWriter, status int, response birdJSONResponse) {
+	httpW.Header().Set("Content-Type", "application/json")
+	httpW.WriteHeader(status)
+	json.NewEncoder(httpW).Encode(response)
+}
+
+// Write an error not coming from bird itself (e.g. socket failure) in JSON format
+func birdWriteJSONError(httpW http.ResponseWriter, status int, command string, message string) {
+	birdWriteJSON(httpW, status, birdJSONResponse{
+		Command: command,
+		Lines:   []birdReplyLine{},
+		Error: &birdReplyError{
+			Message: message,
+		},
+	})
+}
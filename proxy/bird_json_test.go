@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
+
+const birdProtocolsReply = `2002-Name       Proto      Table      State  Since         Info
+1002-static1    Static     master4    up     2021-08-27
+ device1    Device     ---        up     2021-08-27
+ ibgp_sjc2  BGP        ---        up     2023-04-29    Established
+0000 
+`
+
+const birdRouteReply = `1007-Table master4:
+ 172.20.0.53/32       unicast [ibgp_sjc2 2023-04-29 from fd86:bad:11b7:22::1] * (100/38) [AS4242423914i]
+ 	via 169.254.108.122 on igp-sjc2
+1008-	Type: BGP univ
+1012-	BGP.origin: IGP
+ 	BGP.as_path: 4242423914
+ 	BGP.local_pref: 100
+1007-                     unicast [miaotony_2688 2023-04-29 from fe80::2688] (100) [AS4242423914i]
+ 	via 172.23.6.6 on dn42las-miaoton
+1008-	Type: BGP univ
+1012-	BGP.origin: IGP
+ 	BGP.as_path: 4242422688 4242423914
+0000 
+`
+
+func TestBirdReadReplyLine(t *testing.T) {
+	line, more, err := birdReadReplyLine(strings.NewReader("1002-static1 Static\n"), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, more, true)
+	assert.Equal(t, line, birdReplyLine{Code: 1002, Text: "static1 Static"})
+}
+
+func TestBirdReadReplyLineContinuation(t *testing.T) {
+	line, more, err := birdReadReplyLine(strings.NewReader(" device1 Device\n"), 1002)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, more, true)
+	assert.Equal(t, line, birdReplyLine{Code: 1002, Text: "device1 Device"})
+}
+
+func TestBirdReadReplyLineFinal(t *testing.T) {
+	for _, input := range []string{"0000 \n", "8001 Network not found\n", "9001 syntax error\n"} {
+		_, more, err := birdReadReplyLine(strings.NewReader(input), 0)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, more, false)
+	}
+}
+
+func TestBirdReadReplyEOF(t *testing.T) {
+	lines, err := birdReadReply(strings.NewReader("1002-static1 Static\n"))
+	if err == nil {
+		t.Error("Should report EOF")
+	}
+	assert.Equal(t, len(lines), 1)
+}
+
+func TestBirdBuildJSONResponseProtocols(t *testing.T) {
+	lines, err := birdReadReply(strings.NewReader(birdProtocolsReply))
+	assert.Equal(t, err, nil)
+
+	response := birdBuildJSONResponse("show protocols", lines)
+	assert.Equal(t, response.Command, "show protocols")
+	assert.Equal(t, len(response.Lines), 5)
+	assert.Equal(t, response.Lines[0].Code, 2002)
+	assert.Equal(t, len(response.Protocols), 3)
+	assert.Equal(t, response.Protocols[2], birdparser.Protocol{
+		Name:  "ibgp_sjc2",
+		Proto: "BGP",
+		Table: "---",
+		State: "up",
+		Since: "2023-04-29",
+		Info:  "Established",
+	})
+	if response.Error != nil {
+		t.Error("Unexpected error in response")
+	}
+}
+
+func TestBirdBuildJSONResponseRoutes(t *testing.T) {
+	lines, err := birdReadReply(strings.NewReader(birdRouteReply))
+	assert.Equal(t, err, nil)
+
+	response := birdBuildJSONResponse("show route for 172.20.0.53 all", lines)
+	assert.Equal(t, len(response.Routes), 2)
+
+	first := response.Routes[0]
+	assert.Equal(t, first.Table, "master4")
+	assert.Equal(t, first.Prefix, "172.20.0.53/32")
+	assert.Equal(t, first.Type, "unicast")
+	assert.Equal(t, first.Protocol, "ibgp_sjc2")
+	assert.Equal(t, first.Since, "2023-04-29")
+	assert.Equal(t, first.From, "fd86:bad:11b7:22::1")
+	assert.Equal(t, first.Preferred, true)
+	assert.Equal(t, first.Origin, "AS4242423914i")
+	assert.Equal(t, first.Nexthops, []birdparser.Nexthop{{Gateway: "169.254.108.122", Interface: "igp-sjc2"}})
+	assert.Equal(t, first.BGP.ASPath, []uint32{4242423914})
+	assert.Equal(t, *first.BGP.LocalPref, uint32(100))
+
+	second := response.Routes[1]
+	assert.Equal(t, second.Prefix, "172.20.0.53/32")
+	assert.Equal(t, second.Protocol, "miaotony_2688")
+	assert.Equal(t, second.Preferred, false)
+	assert.Equal(t, second.BGP.ASPath, []uint32{4242422688, 4242423914})
+}
+
+func TestBirdBuildJSONResponseError(t *testing.T) {
+	lines, err := birdReadReply(strings.NewReader("8001 Network not found\n"))
+	assert.Equal(t, err, nil)
+
+	response := birdBuildJSONResponse("show route for 1.2.3.4", lines)
+	if response.Error == nil {
+		t.Fatal("Error not reported")
+	}
+	assert.Equal(t, response.Error.Code, 8001)
+	assert.Equal(t, response.Error.Message, "Network not found")
+	assert.Equal(t, birdJSONStatus(response), http.StatusNotFound)
+}
+
+func TestBirdJSONStatus(t *testing.T) {
+	status := func(code int) int {
+		return birdJSONStatus(birdJSONResponse{Error: &birdReplyError{Code: code}})
+	}
+	assert.Equal(t, birdJSONStatus(birdJSONResponse{}), http.StatusOK)
+	assert.Equal(t, status(8001), http.StatusNotFound)
+	assert.Equal(t, status(8003), http.StatusNotFound)
+	assert.Equal(t, status(8007), http.StatusForbidden)
+	assert.Equal(t, status(8002), http.StatusInternalServerError)
+	assert.Equal(t, status(9001), http.StatusBadRequest)
+}
+
+func TestBirdBuildJSONResponseProtocolDetails(t *testing.T) {
+	reply := `2002-Name       Proto      Table      State  Since         Info
+1002-ibgp_sjc2  BGP        ---        up     2023-04-29    Established
+1006-  BGP state:          Established
+   Neighbor address: fd86:bad:11b7:22::1
+   Neighbor AS:      4242423914
+0000 
+`
+	lines, err := birdReadReply(strings.NewReader(reply))
+	assert.Equal(t, err, nil)
+
+	response := birdBuildJSONResponse("show protocols all ibgp_sjc2", lines)
+	assert.Equal(t, len(response.Protocols), 1)
+	if response.Protocols[0].BGP == nil {
+		t.Fatal("BGP details not parsed")
+	}
+	assert.Equal(t, response.Protocols[0].BGP.State, "Established")
+}
+
+func TestBirdHandlerJSONBirdError(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show route for 1.2.3.4",
+		rawResponse:   "8001 Network not found\n",
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+	setting.birdRestrictCmds = true
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?format=json&q="+url.QueryEscape("show route for 1.2.3.4"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	var response birdJSONResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil || response.Error.Code != 8001 {
+		t.Error("Bird error not reported in JSON")
+	}
+}
+
+func TestBirdHandlerJSON(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show protocols",
+		rawResponse:   birdProtocolsReply,
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+	setting.birdRestrictCmds = true
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?format=json&q="+url.QueryEscape("show protocols"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+
+	var response birdJSONResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(response.Protocols), 3)
+}
+
+func TestBirdHandlerJSONForbidden(t *testing.T) {
+	setting.birdSocket = "/any/path"
+	setting.birdRestrictCmds = true
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?format=json&q="+url.QueryEscape("configure"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	var response birdJSONResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error == nil || !strings.Contains(response.Error.Message, "Forbidden") {
+		t.Error("Forbidden error not reported in JSON")
+	}
+}
+
+func TestBirdHandlerJSONBadSocket(t *testing.T) {
+	setting.birdSocket = "/nonexistent.sock"
+	setting.birdRestrictCmds = false
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?format=json&q="+url.QueryEscape("show status"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+)
+
+// Metrics of bird protocols from the last poll. A new registry is built on
+// each poll, so protocols removed from bird disappear from the metrics.
+var birdMetrics atomic.Pointer[prometheus.Registry]
+
+// Run a command on bird and return its output, with status numbers removed
+func birdRunCommand(ctx context.Context, query string) (string, error) {
+	bird, firstLine, err := birdQuery(ctx, query)
+	if err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+	var replyErr error
+	if birdOutputln(firstLine, &output) {
+		replyErr = birdCopyReply(bird.conn, &output)
+	}
+	if replyErr != nil {
+		metricBirdSocketErrors.WithLabelValues("reply").Inc()
+	}
+	birdRelease(bird, replyErr == nil)
+	return output.String(), replyErr
+}
+
+// Value of birdlg_bird_bgp_state, 0 if the state is unknown
+func birdBGPStateValue(state string) float64 {
+	for i, known := range birdparser.BGPStates {
+		if state == known {
+			return float64(i + 1)
+		}
+	}
+	return 0
+}
+
+// Build metrics from the protocols shown by "show protocols all"
+func birdProtocolMetrics(r *prometheus.Registry, protocols []birdparser.Protocol, now time.Time) {
+	factory := promauto.With(r)
+	gaugeVec := func(name string, help string, labelNames ...string) *prometheus.GaugeVec {
+		return factory.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labelNames)
+	}
+
+	protocolUp := gaugeVec("birdlg_bird_protocol_up",
+		"Whether the protocol is up.", "name", "proto")
+	protocolUptime := gaugeVec("birdlg_bird_protocol_uptime_seconds",
+		"Time since the protocol came up, only for protocols that are up.", "name", "proto")
+	routesImported := gaugeVec("birdlg_bird_protocol_routes_imported",
+		"Routes imported by the channel.", "name", "proto", "channel")
+	routesFiltered := gaugeVec("birdlg_bird_protocol_routes_filtered",
+		"Routes filtered by the channel, only counted if import of filtered routes is enabled.", "name", "proto", "channel")
+	routesExported := gaugeVec("birdlg_bird_protocol_routes_exported",
+		"Routes exported by the channel.", "name", "proto", "channel")
+	routesPreferred := gaugeVec("birdlg_bird_protocol_routes_preferred",
+		"Routes of the channel preferred in the table.", "name", "proto", "channel")
+	bgpState := gaugeVec("birdlg_bird_bgp_state",
+		"State of the BGP session: 1 Idle, 2 Connect, 3 Active, 4 OpenSent, 5 OpenConfirm, 6 Established, 7 Close, 0 unknown.",
+		"name", "neighbor_address", "neighbor_as")
+
+	for _, protocol := range protocols {
+		up := 0.0
+		if protocol.State == "up" {
+			up = 1
+			if since, ok := birdparser.ParseSince(protocol.Since, now, time.Local); ok {
+				protocolUptime.WithLabelValues(protocol.Name, protocol.Proto).Set(now.Sub(since).Seconds())
+			}
+		}
+		protocolUp.WithLabelValues(protocol.Name, protocol.Proto).Set(up)
+
+		for _, channel := range protocol.Channels {
+			if channel.Routes == nil {
+				continue
+			}
+			labels := []string{protocol.Name, protocol.Proto, channel.Name}
+			routesImported.WithLabelValues(labels...).Set(float64(channel.Routes.Imported))
+			routesFiltered.WithLabelValues(labels...).Set(float64(channel.Routes.Filtered))
+			routesExported.WithLabelValues(labels...).Set(float64(channel.Routes.Exported))
+			routesPreferred.WithLabelValues(labels...).Set(float64(channel.Routes.Preferred))
+		}
+
+		if protocol.BGP != nil {
+			neighborAS := ""
+			if protocol.BGP.NeighborAS != 0 {
+				neighborAS = strconv.FormatUint(uint64(protocol.BGP.NeighborAS), 10)
+			}
+			bgpState.WithLabelValues(protocol.Name, protocol.BGP.NeighborAddress, neighborAS).Set(birdBGPStateValue(protocol.BGP.State))
+		}
+	}
+}
+
+// Query bird for protocol states and build a new registry of metrics
+func birdMetricsCollect(ctx context.Context) *prometheus.Registry {
+	start := time.Now()
+	r := prometheus.NewRegistry()
+	factory := promauto.With(r)
+	up := factory.NewGauge(prometheus.GaugeOpts{
+		Name: "birdlg_bird_up",
+		Help: "Whether the last query of bird protocols succeeded.",
+	})
+	factory.NewGauge(prometheus.GaugeOpts{
+		Name: "birdlg_bird_last_poll_timestamp_seconds",
+		Help: "Unix time of the last query of bird protocols.",
+	}).Set(float64(start.UnixNano()) / 1e9)
+	duration := factory.NewGauge(prometheus.GaugeOpts{
+		Name: "birdlg_bird_poll_duration_seconds",
+		Help: "Time taken by the last query of bird protocols.",
+	})
+
+	output, err := birdRunCommand(ctx, "show protocols all")
+	duration.Set(time.Since(start).Seconds())
+	if err != nil {
+		return r
+	}
+	up.Set(1)
+	birdProtocolMetrics(r, birdparser.ParseProtocols(output), start)
+	return r
+}
+
+// Poll bird for protocol states every interval, until ctx is cancelled
+func birdMetricsStart(ctx context.Context, interval time.Duration) {
+	poll := func() {
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		birdMetrics.Store(birdMetricsCollect(pollCtx))
+	}
+
+	go func() {
+		poll()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				poll()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Serve metrics of bird protocols from the last poll
+func birdMetricsHandler(httpW http.ResponseWriter, httpR *http.Request) {
+	r := birdMetrics.Load()
+	if r == nil {
+		http.Error(httpW, "Metrics of bird are not collected yet, please try again later.", http.StatusServiceUnavailable)
+		return
+	}
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(httpW, httpR)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xddxdd/bird-lg-go/lib/birdparser"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
+)
+
+const birdMetricsMockProtocols = `Name       Proto      Table      State  Since         Info
+kernel1    Kernel     master4    up     2023-04-29 10:00:00
+  Channel ipv4
+    State:          UP
+    Table:          master4
+    Routes:         0 imported, 512 exported, 0 preferred
+dn42_alpha BGP        ---        up     2023-05-01 08:15:42  Established
+  BGP state:          Established
+    Neighbor address: 172.20.0.1
+    Neighbor AS:      4242421080
+  Channel ipv4
+    State:          UP
+    Routes:         680 imported, 3 filtered, 512 exported, 420 preferred
+dn42_beta  BGP        ---        start  2023-05-01 08:15:42  Active        Socket: Connection refused
+  BGP state:          Active
+    Neighbor address: 172.20.0.2
+    Neighbor AS:      4242422000`
+
+func writeBirdMetrics(t *testing.T, r *prometheus.Registry) string {
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(r, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics/bird", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	return w.Body.String()
+}
+
+func TestBirdBGPStateValue(t *testing.T) {
+	assert.Equal(t, birdBGPStateValue("Idle"), 1.0)
+	assert.Equal(t, birdBGPStateValue("Established"), 6.0)
+	assert.Equal(t, birdBGPStateValue("Down"), 0.0)
+}
+
+func TestBirdProtocolMetrics(t *testing.T) {
+	r := prometheus.NewRegistry()
+	now := time.Date(2023, 5, 1, 9, 15, 42, 0, time.Local)
+	birdProtocolMetrics(r, birdparser.ParseProtocols(birdMetricsMockProtocols), now)
+	body := writeBirdMetrics(t, r)
+
+	for _, expected := range []string{
+		`birdlg_bird_protocol_up{name="dn42_alpha",proto="BGP"} 1`,
+		`birdlg_bird_protocol_up{name="dn42_beta",proto="BGP"} 0`,
+		`birdlg_bird_protocol_uptime_seconds{name="dn42_alpha",proto="BGP"} 3600`,
+		`birdlg_bird_protocol_routes_imported{channel="ipv4",name="dn42_alpha",proto="BGP"} 680`,
+		`birdlg_bird_protocol_routes_filtered{channel="ipv4",name="dn42_alpha",proto="BGP"} 3`,
+		`birdlg_bird_protocol_routes_exported{channel="ipv4",name="kernel1",proto="Kernel"} 512`,
+		`birdlg_bird_protocol_routes_preferred{channel="ipv4",name="dn42_alpha",proto="BGP"} 420`,
+		`birdlg_bird_bgp_state{name="dn42_alpha",neighbor_address="172.20.0.1",neighbor_as="4242421080"} 6`,
+		`birdlg_bird_bgp_state{name="dn42_beta",neighbor_address="172.20.0.2",neighbor_as="4242422000"} 3`,
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Errorf("Missing %s in:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, `birdlg_bird_protocol_uptime_seconds{name="dn42_beta"`) {
+		t.Error("Uptime should only be reported for protocols that are up")
+	}
+}
+
+func TestBirdMetricsCollect(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show protocols all",
+		response:      birdMetricsMockProtocols,
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+
+	body := writeBirdMetrics(t, birdMetricsCollect(context.Background()))
+	if !strings.Contains(body, "birdlg_bird_up 1\n") {
+		t.Errorf("bird should be up:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_bird_protocol_up{name="kernel1",proto="Kernel"} 1`) {
+		t.Errorf("Protocols not reported:\n%s", body)
+	}
+}
+
+func TestBirdMetricsCollectWithBadSocket(t *testing.T) {
+	setting.birdSocket = "/nonexistent.sock"
+
+	body := writeBirdMetrics(t, birdMetricsCollect(context.Background()))
+	if !strings.Contains(body, "birdlg_bird_up 0\n") {
+		t.Errorf("bird should be down:\n%s", body)
+	}
+	if strings.Contains(body, "birdlg_bird_protocol_up{") {
+		t.Errorf("Protocols should not be reported:\n%s", body)
+	}
+}
+
+func TestBirdMetricsHandler(t *testing.T) {
+	birdMetrics.Store(nil)
+	defer birdMetrics.Store(nil)
+
+	w := httptest.NewRecorder()
+	birdMetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics/bird", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	r := prometheus.NewRegistry()
+	promauto.With(r).NewGauge(prometheus.GaugeOpts{
+		Name: "birdlg_bird_up",
+		Help: "Whether the last query of bird protocols succeeded.",
+	}).Set(1)
+	birdMetrics.Store(r)
+
+	w = httptest.NewRecorder()
+	birdMetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics/bird", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "birdlg_bird_up 1\n") {
+		t.Errorf("Unexpected response:\n%s", w.Body.String())
+	}
+}
+
+func TestAccessHandlerBirdMetricsSigned(t *testing.T) {
+	setting.allowedNets = nil
+	setting.verifier = reqsign.NewVerifier([]byte("secret"), 0)
+	setting.metrics = true
+	setting.birdMetrics = true
+	defer func() {
+		setting.verifier = nil
+		setting.metrics = false
+		setting.birdMetrics = false
+	}()
+
+	// Metrics of BIRD protocols require a signature, unlike process metrics
+	r := httptest.NewRequest(http.MethodGet, "/metrics/bird", nil)
+	w := httptest.NewRecorder()
+	accessHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	reqsign.Sign(r, []byte("secret"))
+	w = httptest.NewRecorder()
+	accessHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
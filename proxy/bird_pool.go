@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+var errBirdNotRestricted = errors.New("could not verify that bird access was restricted")
+
+// A connection to bird socket, on which "restrict" has already been confirmed
+type birdConn struct {
+	conn     net.Conn
+	socket   string
+	lastUsed time.Time
+	reused   bool
+	// Holds a slot of bird_max_connections while in use
+	hasSlot bool
+
+	// Stops closing the connection on cancellation of the current query
+	stopCancel func() bool
+}
+
+// Pool of idle bird connections, to avoid connecting and restricting on every query
+type birdPool struct {
+	idle        chan *birdConn
+	idleTimeout time.Duration
+}
+
+var birdConnPool *birdPool
+
+func initBirdPool(size int, idleTimeout time.Duration) {
+	if size > 0 {
+		birdConnPool = &birdPool{
+			idle:        make(chan *birdConn, size),
+			idleTimeout: idleTimeout,
+		}
+	} else {
+		birdConnPool = nil
+	}
+}
+
+// Slots of connections in use, nil if unlimited
+var birdConnSemaphore chan struct{}
+
+func initBirdConnLimit(maxConns int) {
+	if maxConns > 0 {
+		birdConnSemaphore = make(chan struct{}, maxConns)
+	} else {
+		birdConnSemaphore = nil
+	}
+}
+
+// Wait for a slot of the connection limit, until ctx is cancelled
+func birdAcquireSlot(ctx context.Context) error {
+	if birdConnSemaphore == nil {
+		return nil
+	}
+	select {
+	case birdConnSemaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release the slot of a connection no longer in use
+func (c *birdConn) releaseSlot() {
+	if c.hasSlot {
+		<-birdConnSemaphore
+		c.hasSlot = false
+	}
+}
+
+// Max time for bird to greet a new connection and confirm the restriction
+const birdDialTimeout = 10 * time.Second
+
+// Connect to bird socket, and restrict the access of the connection.
+// The connection is closed if bird hangs or ctx is cancelled meanwhile.
+func birdDial(ctx context.Context, socket string) (*birdConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		metricBirdSocketErrors.WithLabelValues("connect").Inc()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(birdDialTimeout))
+	stopCancel := context.AfterFunc(ctx, func() { conn.Close() })
+	birdReadln(conn, nil)
+	birdWriteln(conn, "restrict")
+	var restrictedConfirmation bytes.Buffer
+	birdReadln(conn, &restrictedConfirmation)
+	if !stopCancel() {
+		return nil, ctx.Err()
+	}
+	if !strings.Contains(restrictedConfirmation.String(), "Access restricted") {
+		conn.Close()
+		metricBirdSocketErrors.WithLabelValues("restrict").Inc()
+		return nil, errBirdNotRestricted
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &birdConn{
+		conn:     conn,
+		socket:   socket,
+		lastUsed: time.Now(),
+	}, nil
+}
+
+func (c *birdConn) Close() {
+	c.conn.Close()
+}
+
+// Check if an idle connection is still usable. Bird won't send anything
+// on an idle connection, so any data or EOF means the connection is broken,
+// most likely because bird has restarted.
+func (c *birdConn) alive() bool {
+	c.conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	var buf [1]byte
+	_, err := c.conn.Read(buf[:])
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Get an idle connection from the pool, or create a new one if none is available
+func (p *birdPool) Get(ctx context.Context) (*birdConn, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if c.socket != setting.birdSocket || time.Since(c.lastUsed) > p.idleTimeout || !c.alive() {
+				c.Close()
+				continue
+			}
+			c.reused = true
+			return c, nil
+		default:
+			return birdDial(ctx, setting.birdSocket)
+		}
+	}
+}
+
+// Return a connection to the pool, or close it if the pool is full
+func (p *birdPool) Put(c *birdConn) {
+	c.lastUsed = time.Now()
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+// Get a restricted bird connection, from the pool if enabled. Waits for a
+// free slot if bird_max_connections connections are in use.
+func birdAcquire(ctx context.Context) (*birdConn, error) {
+	if err := birdAcquireSlot(ctx); err != nil {
+		return nil, err
+	}
+
+	var c *birdConn
+	var err error
+	if birdConnPool == nil {
+		c, err = birdDial(ctx, setting.birdSocket)
+	} else {
+		c, err = birdConnPool.Get(ctx)
+	}
+	if err != nil {
+		if birdConnSemaphore != nil {
+			<-birdConnSemaphore
+		}
+		return nil, err
+	}
+	c.hasSlot = birdConnSemaphore != nil
+	return c, nil
+}
+
+// Release a bird connection. Connections are only put back into the pool
+// if they're healthy, i.e. the last reply has been fully read.
+func birdRelease(c *birdConn, healthy bool) {
+	if c.stopCancel != nil && !c.stopCancel() {
+		// Connection has been closed due to cancellation
+		healthy = false
+	}
+	c.stopCancel = nil
+	c.releaseSlot()
+
+	if birdConnPool == nil || !healthy {
+		c.Close()
+		return
+	}
+	birdConnPool.Put(c)
+}
+
+// Send a query to bird and read the first line of reply.
+// If a reused connection turns out to be broken, retry once on a new connection.
+// The connection is closed when ctx is cancelled, to abort long running queries.
+func birdQuery(ctx context.Context, query string) (*birdConn, []byte, error) {
+	for {
+		c, err := birdAcquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		c.stopCancel = context.AfterFunc(ctx, c.Close)
+
+		birdWriteln(c.conn, query)
+		firstLine, err := birdReadRawln(c.conn)
+		if err == nil {
+			return c, firstLine, nil
+		}
+
+		c.stopCancel()
+		c.releaseSlot()
+		c.Close()
+		metricBirdSocketErrors.WithLabelValues("query").Inc()
+		if !c.reused || ctx.Err() != nil {
+			return nil, nil, err
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+// Mock bird server that keeps connections open and answers multiple queries,
+// like the real bird does
+type BirdPoolServer struct {
+	BirdServer
+	dials int32
+	conns []net.Conn
+	lock  sync.Mutex
+}
+
+func (s *BirdPoolServer) Run() {
+	for {
+		conn, err := s.server.Accept()
+		if err != nil {
+			break
+		}
+		atomic.AddInt32(&s.dials, 1)
+		s.lock.Lock()
+		s.conns = append(s.conns, conn)
+		s.lock.Unlock()
+		go s.serve(conn)
+	}
+}
+
+func (s *BirdPoolServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if s.injectError == "silent" {
+		// Never greet, like a hung bird
+		reader.ReadBytes(0)
+		return
+	}
+	conn.Write([]byte("0001 BIRD 2.0.8 ready.\n"))
+	for {
+		query, err := reader.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		switch strings.TrimSpace(string(query)) {
+		case "restrict":
+			if s.injectError == "restriction" {
+				conn.Write([]byte("8007 Access denied\n"))
+			} else {
+				conn.Write([]byte("0016 Access restricted\n"))
+			}
+		default:
+			if s.injectError == "hang" {
+				continue
+			}
+			conn.Write([]byte("0000 " + s.response + "\n"))
+		}
+	}
+}
+
+// Close all connections on server side, as if bird has restarted
+func (s *BirdPoolServer) DropConnections() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *BirdPoolServer) Dials() int {
+	return int(atomic.LoadInt32(&s.dials))
+}
+
+func startBirdPoolServer(t *testing.T, injectError string) *BirdPoolServer {
+	server := &BirdPoolServer{
+		BirdServer: BirdServer{
+			t:           t,
+			response:    "Mock Response",
+			injectError: injectError,
+		},
+	}
+	server.Listen()
+	go server.Run()
+
+	setting.birdSocket = server.socket
+	setting.birdRestrictCmds = false
+	initBirdPool(2, time.Minute)
+	t.Cleanup(func() {
+		server.Close()
+		birdConnPool = nil
+		birdConnSemaphore = nil
+	})
+	return server
+}
+
+func birdPoolRequest(t *testing.T, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape(query), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+	return w
+}
+
+func TestBirdPoolReusesConnection(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+
+	for i := 0; i < 3; i++ {
+		w := birdPoolRequest(t, "show status")
+		assert.Equal(t, w.Code, http.StatusOK)
+		assert.Equal(t, w.Body.String(), "Mock Response\n")
+	}
+
+	assert.Equal(t, server.Dials(), 1)
+}
+
+func TestBirdPoolDisabled(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+	initBirdPool(0, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		w := birdPoolRequest(t, "show status")
+		assert.Equal(t, w.Code, http.StatusOK)
+	}
+
+	assert.Equal(t, server.Dials(), 3)
+}
+
+func TestBirdPoolReconnectsAfterBirdRestart(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+
+	w := birdPoolRequest(t, "show status")
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	server.DropConnections()
+
+	w = birdPoolRequest(t, "show status")
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "Mock Response\n")
+	assert.Equal(t, server.Dials(), 2)
+}
+
+func TestBirdPoolIdleTimeout(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+	initBirdPool(2, 0)
+
+	birdPoolRequest(t, "show status")
+	time.Sleep(time.Millisecond)
+	birdPoolRequest(t, "show status")
+
+	assert.Equal(t, server.Dials(), 2)
+}
+
+func TestBirdPoolSocketChange(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+	birdPoolRequest(t, "show status")
+
+	pool := birdConnPool
+	otherServer := startBirdPoolServer(t, "")
+	birdConnPool = pool
+	w := birdPoolRequest(t, "show status")
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, server.Dials(), 1)
+	assert.Equal(t, otherServer.Dials(), 1)
+}
+
+func TestBirdPoolBounded(t *testing.T) {
+	startBirdPoolServer(t, "")
+
+	var conns []*birdConn
+	for i := 0; i < 4; i++ {
+		c, err := birdAcquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+	}
+	for _, c := range conns {
+		birdRelease(c, true)
+	}
+
+	assert.Equal(t, len(birdConnPool.idle), 2)
+}
+
+func TestBirdConnLimitWaits(t *testing.T) {
+	startBirdPoolServer(t, "")
+	initBirdConnLimit(1)
+
+	c, err := birdAcquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *birdConn)
+	go func() {
+		c, err := birdAcquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- c
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Connection acquired while limit reached")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	birdRelease(c, true)
+	select {
+	case c = <-acquired:
+		birdRelease(c, true)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connection not acquired after release")
+	}
+	assert.Equal(t, len(birdConnSemaphore), 0)
+}
+
+func TestBirdConnLimitCancel(t *testing.T) {
+	startBirdPoolServer(t, "")
+	initBirdConnLimit(1)
+
+	c, err := birdAcquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer birdRelease(c, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = birdAcquire(ctx)
+	assert.Equal(t, err, context.DeadlineExceeded)
+}
+
+func TestBirdDialCancel(t *testing.T) {
+	startBirdPoolServer(t, "silent")
+	initBirdConnLimit(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := birdAcquire(ctx)
+
+	assert.Equal(t, err, context.DeadlineExceeded)
+	if time.Since(start) > 5*time.Second {
+		t.Error("Dial not aborted after cancellation")
+	}
+	assert.Equal(t, len(birdConnSemaphore), 0)
+}
+
+func TestBirdConnLimitDialFailure(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+	initBirdConnLimit(1)
+	server.Close()
+
+	_, err := birdAcquire(context.Background())
+	if err == nil {
+		t.Fatal("Expected dial failure")
+	}
+	assert.Equal(t, len(birdConnSemaphore), 0)
+}
+
+func TestBirdPoolUnhealthyNotReused(t *testing.T) {
+	server := startBirdPoolServer(t, "")
+
+	c, err := birdAcquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	birdRelease(c, false)
+
+	assert.Equal(t, len(birdConnPool.idle), 0)
+	birdPoolRequest(t, "show status")
+	assert.Equal(t, server.Dials(), 2)
+}
+
+func TestBirdPoolRestrictionFailure(t *testing.T) {
+	startBirdPoolServer(t, "restriction")
+
+	w := birdPoolRequest(t, "show status")
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, len(birdConnPool.idle), 0)
+}
+
+func TestBirdPoolClientDisconnect(t *testing.T) {
+	startBirdPoolServer(t, "hang")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("show status"), nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("Bird query not aborted after client disconnect")
+	}
+	assert.Equal(t, len(birdConnPool.idle), 0)
+}
+
+func TestBirdPoolReleaseAfterCancel(t *testing.T) {
+	startBirdPoolServer(t, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _, err := birdQuery(ctx, "show status")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reply is complete, but the client is gone before the connection is released
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	birdRelease(c, true)
+
+	assert.Equal(t, len(birdConnPool.idle), 0)
+}
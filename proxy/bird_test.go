@@ -10,7 +10,9 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
 )
@@ -22,6 +24,7 @@ type BirdServer struct {
 	server        net.Listener
 	socket        string
 	injectError   string
+	rawResponse   string
 }
 
 func (s *BirdServer) initSocket() {
@@ -77,6 +80,12 @@ func (s *BirdServer) Run() {
 			s.t.Errorf("Query %s doesn't match expectation %s", string(query), s.expectedQuery)
 		}
 
+		if s.rawResponse != "" {
+			conn.Write([]byte(s.rawResponse))
+			conn.Close()
+			continue
+		}
+
 		responseList := strings.Split(s.response, "\n")
 		for i := range responseList {
 			if i == len(responseList)-1 {
@@ -356,3 +365,59 @@ func TestBirdHandlerWithRestrictionDisabledEdgeCase(t *testing.T) {
 	assert.Equal(t, w.Code, http.StatusOK)
 	assert.Equal(t, w.Body.String(), "Mock Response\n")
 }
+
+func TestBirdHandlerFlushesOutput(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show route",
+		response:      "First Line\nSecond Line\nThird Line",
+		injectError:   "",
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+	setting.birdRestrictCmds = false
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape(server.expectedQuery), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Flushed, true)
+	assert.Equal(t, w.Body.String(), server.response+"\n")
+}
+
+// Response writer counting flushes, safe to flush from the timer
+type flushCountingWriter struct {
+	*httptest.ResponseRecorder
+	flushes int32
+}
+
+func (w *flushCountingWriter) Flush() {
+	atomic.AddInt32(&w.flushes, 1)
+}
+
+func TestFlushWriterThrottled(t *testing.T) {
+	w := &flushCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+	out := newFlushWriter(w)
+	for i := 0; i < 100; i++ {
+		out.Write([]byte("line\n"))
+	}
+	assert.Equal(t, atomic.LoadInt32(&w.flushes), int32(0))
+
+	// Pending output is flushed after the interval
+	time.Sleep(3 * flushInterval)
+	assert.Equal(t, atomic.LoadInt32(&w.flushes), int32(1))
+
+	// Large output is flushed right away
+	out.Write(make([]byte, flushSize))
+	assert.Equal(t, atomic.LoadInt32(&w.flushes), int32(2))
+
+	out.Write([]byte("line\n"))
+	out.Close()
+	assert.Equal(t, atomic.LoadInt32(&w.flushes), int32(3))
+	assert.Equal(t, w.Body.Len(), 500+flushSize+5)
+}
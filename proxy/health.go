@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Max time to wait for bird to answer a health check
+const healthBirdTimeout = 5 * time.Second
+
+// How long the result of checking bird is reused. Health checks don't
+// require signed requests, so anyone allowed to reach the proxy can't run
+// more than one query on bird in this time.
+const healthBirdCacheTTL = 10 * time.Second
+
+type healthBirdStatus struct {
+	OK       bool   `json:"ok"`
+	Version  string `json:"version,omitempty"`
+	RouterID string `json:"router_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type healthToolStatus struct {
+	OK     bool   `json:"ok"`
+	Binary string `json:"binary,omitempty"`
+}
+
+type healthResponse struct {
+	// "ok" if bird is usable, "error" otherwise
+	Status     string           `json:"status"`
+	Bird       healthBirdStatus `json:"bird"`
+	Traceroute healthToolStatus `json:"traceroute"`
+	Ping       healthToolStatus `json:"ping"`
+}
+
+// Check that bird socket is reachable and "restrict" works, by running
+// "show status" on a restricted connection
+func healthCheckBird(ctx context.Context) healthBirdStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthBirdTimeout)
+	defer cancel()
+
+	output, err := birdRunCommand(ctx, "show status")
+	if err != nil {
+		return healthBirdStatus{Error: err.Error()}
+	}
+
+	// Output starts with "BIRD 2.0.8", followed by "Router ID is 172.20.0.1"
+	status := healthBirdStatus{}
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if version, ok := strings.CutPrefix(line, "BIRD "); ok && status.Version == "" {
+			status.Version = version
+		} else if routerID, ok := strings.CutPrefix(line, "Router ID is "); ok {
+			status.RouterID = routerID
+		}
+	}
+	if status.Version == "" {
+		status.Error = strings.TrimSpace(output)
+		return status
+	}
+	status.OK = true
+	return status
+}
+
+// Last result of checking bird, shared by all health checks
+type healthBirdCache struct {
+	lock      sync.Mutex
+	status    healthBirdStatus
+	checkedAt time.Time
+}
+
+var healthBirdLast = &healthBirdCache{}
+
+// Check bird unless it was checked in the last healthBirdCacheTTL. Concurrent
+// health checks wait for the same check.
+func (c *healthBirdCache) check() healthBirdStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < healthBirdCacheTTL {
+		return c.status
+	}
+	// Not cancelled with the request, as the result is shared
+	c.status = healthCheckBird(context.Background())
+	c.checkedAt = time.Now()
+	return c.status
+}
+
+// Report if bird, traceroute and ping are usable. Responds with 503 if
+// bird is not, so load balancers can take the node out of service.
+func healthHandler(httpW http.ResponseWriter, httpR *http.Request) {
+	response := healthResponse{
+		Status:     "ok",
+		Bird:       healthBirdLast.check(),
+		Traceroute: healthToolStatus{OK: setting.tr_bin != "", Binary: setting.tr_bin},
+		Ping:       healthToolStatus{OK: setting.pingBin != "", Binary: setting.pingBin},
+	}
+
+	status := http.StatusOK
+	if !response.Bird.OK {
+		response.Status = "error"
+		status = http.StatusServiceUnavailable
+	}
+
+	httpW.Header().Set("Content-Type", "application/json")
+	httpW.WriteHeader(status)
+	json.NewEncoder(httpW).Encode(response)
+}
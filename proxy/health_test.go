@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
+)
+
+func healthRequest(t *testing.T) (int, healthResponse) {
+	healthBirdLast = &healthBirdCache{}
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+	healthHandler(w, r)
+
+	var response healthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, response
+}
+
+func TestHealthHandler(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show status",
+		response:      "BIRD 2.0.8\nRouter ID is 172.20.0.1\nCurrent server time is 2023-05-01 08:15:42.123\nDaemon is up and running",
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+	setting.tr_bin = "mtr"
+	setting.pingBin = ""
+	defer func() { setting.tr_bin = "" }()
+
+	code, response := healthRequest(t)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, response.Status, "ok")
+	assert.Equal(t, response.Bird, healthBirdStatus{OK: true, Version: "2.0.8", RouterID: "172.20.0.1"})
+	assert.Equal(t, response.Traceroute, healthToolStatus{OK: true, Binary: "mtr"})
+	assert.Equal(t, response.Ping, healthToolStatus{OK: false})
+}
+
+func TestHealthHandlerWithBadSocket(t *testing.T) {
+	setting.birdSocket = "/nonexistent.sock"
+
+	code, response := healthRequest(t)
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, response.Status, "error")
+	assert.Equal(t, response.Bird.OK, false)
+	if response.Bird.Error == "" {
+		t.Error("Error of bird should be reported")
+	}
+}
+
+func TestHealthHandlerWithoutRestriction(t *testing.T) {
+	server := BirdServer{
+		t:             t,
+		expectedQuery: "show status",
+		response:      "BIRD 2.0.8",
+		injectError:   "restriction",
+	}
+
+	server.Listen()
+	go server.Run()
+	defer server.Close()
+
+	setting.birdSocket = server.socket
+
+	code, response := healthRequest(t)
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	assert.Equal(t, response.Bird.Error, errBirdNotRestricted.Error())
+}
+
+func TestAccessHandlerHealthUnsigned(t *testing.T) {
+	setting.allowedNets = nil
+	setting.verifier = reqsign.NewVerifier([]byte("secret"), 0)
+	defer func() { setting.verifier = nil }()
+
+	// Load balancers can't sign requests
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+	accessHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
+
+func TestHealthHandlerCached(t *testing.T) {
+	setting.birdSocket = "/nonexistent.sock"
+	healthBirdLast = &healthBirdCache{
+		status:    healthBirdStatus{OK: true, Version: "2.0.8"},
+		checkedAt: time.Now(),
+	}
+	defer func() { healthBirdLast = &healthBirdCache{} }()
+
+	// Recent results are reused without querying bird
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// Bird is checked again once the result is too old
+	healthBirdLast.checkedAt = time.Now().Add(-healthBirdCacheTTL)
+	w = httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+}
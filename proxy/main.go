@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
 )
 
 // Check if a byte is character for number
@@ -50,36 +54,92 @@ func hasAccess(remoteAddr string) bool {
 	return false
 }
 
-// Access handler, check to see if client IP in allowed nets, continue if it is, send to invalidHandler if not
+// Access handler, check to see if client IP in allowed nets, continue if it is, send to invalidHandler if not.
+// If a shared secret is set, requests must also be signed by the frontend,
+// except for process metrics and health checks, as Prometheus and load
+// balancers can't sign their requests.
 func accessHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(httpW http.ResponseWriter, httpR *http.Request) {
-		if hasAccess(httpR.RemoteAddr) {
-			next.ServeHTTP(httpW, httpR)
-		} else {
+		if !hasAccess(httpR.RemoteAddr) {
 			invalidHandler(httpW, httpR)
+			return
 		}
+		if setting.verifier != nil && !isMetricsPath(httpR.URL.Path) && httpR.URL.Path != "/healthz" {
+			if err := setting.verifier.Verify(httpR); err != nil {
+				http.Error(httpW, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(httpW, httpR)
 	})
 }
 
 type settingType struct {
 	birdSocket        string
 	birdRestrictCmds  bool
+	birdAllowedCmds   []birdCommandPattern
+	birdDeniedCmds    []birdCommandPattern
 	listen            []string
 	allowedNets       []*net.IPNet
 	tr_bin            string
 	tr_flags          []string
 	tr_raw            bool
 	tr_max_concurrent int
+	pingBin           string
+	pingFlags         []string
 	vrf               string
+
+	birdPoolSize        int
+	birdPoolIdleTimeout time.Duration
+	birdMaxConns        int
+
+	tlsConfig *tls.Config
+
+	// Verifies request signatures if a shared secret is set
+	verifier *reqsign.Verifier
+
+	metrics             bool
+	birdMetrics         bool
+	birdMetricsInterval time.Duration
+
+	// Rate limits of each endpoint, not limited if absent
+	rateLimits        map[string]*ratelimit.Limiter
+	trustProxyHeaders bool
 }
 
 var setting settingType
 
+// Listen on a unix socket or TCP address. TCP listeners serve HTTPS
+// if TLS is configured.
+func listen(addr string) (net.Listener, error) {
+	if strings.HasPrefix(addr, "/") {
+		// Delete existing socket file, ignore errors (will fail later anyway)
+		os.Remove(addr)
+		return net.Listen("unix", addr)
+	}
+
+	if !strings.Contains(addr, ":") {
+		addr = ":" + addr
+	}
+	lc := net.ListenConfig{Control: vrfControl(setting.vrf)}
+	l, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if setting.tlsConfig != nil {
+		l = tls.NewListener(l, setting.tlsConfig)
+	}
+	return l, nil
+}
+
 // Wrapper of tracer
 func main() {
 	parseSettings()
 	initTracerouteSemaphore(setting.tr_max_concurrent)
+	initBirdPool(setting.birdPoolSize, setting.birdPoolIdleTimeout)
+	initBirdConnLimit(setting.birdMaxConns)
 	tracerouteAutodetect()
+	pingAutodetect()
 
 	mux := http.NewServeMux()
 
@@ -89,31 +149,27 @@ func main() {
 	mux.HandleFunc("/bird6", birdHandler)
 	mux.HandleFunc("/traceroute", tracerouteHandler)
 	mux.HandleFunc("/traceroute6", tracerouteHandler)
+	mux.HandleFunc("/ping", pingHandler)
+	mux.HandleFunc("/ping6", pingHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	if setting.metrics {
+		mux.Handle("/metrics", metricsRegistryHandler())
+	}
+	if setting.birdMetrics {
+		mux.HandleFunc("/metrics/bird", birdMetricsHandler)
+		birdMetricsStart(context.Background(), setting.birdMetricsInterval)
+	}
 
 	for _, listenAddr := range setting.listen {
 		go func(addr string) {
 			fmt.Printf("Listening on %s...\n", addr)
 
-			var l net.Listener
-			var err error
-
-			if strings.HasPrefix(addr, "/") {
-				// Delete existing socket file, ignore errors (will fail later anyway)
-				os.Remove(addr)
-				l, err = net.Listen("unix", addr)
-			} else {
-				if !strings.Contains(addr, ":") {
-					addr = ":" + addr
-				}
-				lc := net.ListenConfig{Control: vrfControl(setting.vrf)}
-				l, err = lc.Listen(context.Background(), "tcp", addr)
-			}
-
+			l, err := listen(addr)
 			if err != nil {
 				panic(err)
 			}
 
-			http.Serve(l, handlers.LoggingHandler(os.Stdout, accessHandler(mux)))
+			http.Serve(l, handlers.LoggingHandler(os.Stdout, metricsHandler(accessHandler(rateLimitHandler(mux)))))
 		}(listenAddr)
 	}
 
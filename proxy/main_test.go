@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
 )
 
 func TestHasAccessNotConfigured(t *testing.T) {
@@ -97,3 +98,48 @@ func TestAccessHandlerDeny(t *testing.T) {
 	wrappedHandler.ServeHTTP(w, r)
 	assert.Equal(t, w.Code, http.StatusInternalServerError)
 }
+
+func TestAccessHandlerSignature(t *testing.T) {
+	setting.allowedNets = []*net.IPNet{}
+	setting.verifier = reqsign.NewVerifier([]byte("secret"), 0)
+	defer func() { setting.verifier = nil }()
+
+	wrappedHandler := accessHandler(http.NotFoundHandler())
+
+	// Unsigned request
+	r := httptest.NewRequest(http.MethodGet, "/bird?q=show+status", nil)
+	w := httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	// Signed with the wrong secret
+	reqsign.Sign(r, []byte("wrong"))
+	w = httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	reqsign.Sign(r, []byte("secret"))
+	w = httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	// Replayed request
+	w = httptest.NewRecorder()
+	wrappedHandler.ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+}
+
+func TestAccessHandlerSignatureAndAllowedIPs(t *testing.T) {
+	_, netip, _ := net.ParseCIDR("4.3.2.1/32")
+	setting.allowedNets = []*net.IPNet{netip}
+	setting.verifier = reqsign.NewVerifier([]byte("secret"), 0)
+	defer func() { setting.verifier = nil }()
+
+	// Both checks must pass if configured
+	r := httptest.NewRequest(http.MethodGet, "/bird?q=show+status", nil)
+	r.RemoteAddr = "1.2.3.4:4321"
+	reqsign.Sign(r, []byte("secret"))
+	w := httptest.NewRecorder()
+	accessHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsRegistry = prometheus.NewRegistry()
+
+// Upper bounds of histogram buckets in seconds, for latencies from
+// milliseconds to a full traceroute
+var metricsDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	metricRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_proxy_requests_total",
+		Help: "Number of requests handled, by endpoint, command and status code.",
+	}, []string{"endpoint", "command", "code"})
+	metricRequestDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "birdlg_proxy_request_duration_seconds",
+		Help:    "Time taken to handle requests, by endpoint and command.",
+		Buckets: metricsDurationBuckets,
+	}, []string{"endpoint", "command"})
+	metricBirdSocketErrors = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_proxy_bird_socket_errors_total",
+		Help: "Errors communicating with the bird socket, by operation (connect, restrict, query or reply).",
+	}, []string{"operation"})
+	metricRateLimited = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "birdlg_proxy_rate_limited_total",
+		Help: "Requests rejected by rate limits, by endpoint.",
+	}, []string{"endpoint"})
+	metricTracerouteRejected = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "birdlg_proxy_traceroute_rejected_total",
+		Help: "Traceroute and ping requests rejected because all concurrency slots were taken.",
+	})
+)
+
+func init() {
+	factory := promauto.With(metricsRegistry)
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "birdlg_proxy_bird_connections_in_use",
+		Help: "Connections to the bird socket currently in use, if bird_max_connections is set.",
+	}, func() float64 {
+		return float64(len(birdConnSemaphore))
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "birdlg_proxy_bird_connections",
+		Help: "Max connections to the bird socket in use at the same time, 0 if unlimited.",
+	}, func() float64 {
+		return float64(cap(birdConnSemaphore))
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "birdlg_proxy_traceroute_slots_in_use",
+		Help: "Concurrency slots of traceroute and ping currently in use.",
+	}, func() float64 {
+		return float64(len(tracerouteSemaphore))
+	})
+	factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "birdlg_proxy_traceroute_slots",
+		Help: "Total concurrency slots of traceroute and ping, 0 if unlimited.",
+	}, func() float64 {
+		return float64(cap(tracerouteSemaphore))
+	})
+}
+
+// Handler of process metrics on /metrics
+func metricsRegistryHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// Endpoint label of a request path, unknown paths are grouped together to
+// limit the number of series
+func metricsEndpoint(path string) string {
+	switch path {
+	case "/bird", "/bird6":
+		return "bird"
+	case "/traceroute", "/traceroute6":
+		return "traceroute"
+	case "/ping", "/ping6":
+		return "ping"
+	case "/metrics", "/metrics/bird":
+		return "metrics"
+	case "/healthz":
+		return "healthz"
+	}
+	return "other"
+}
+
+// Command label of a request, the allowed command pattern matched by bird
+// commands like "show route ...", or "other" if none matches. Commands
+// themselves are not used, as any client could create new series with them.
+func metricsCommand(endpoint string, r *http.Request) string {
+	if endpoint != "bird" {
+		return ""
+	}
+	tokens, err := birdTokenize(r.URL.Query().Get("q"))
+	if err != nil {
+		return "other"
+	}
+	if len(tokens) == 0 {
+		return ""
+	}
+	for _, pattern := range birdAllowedPatterns() {
+		if pattern.matches(tokens, false) {
+			return strings.Join(pattern, " ")
+		}
+	}
+	return "other"
+}
+
+// Check if the path serves process metrics, which are exempt from request
+// signing. Metrics of BIRD protocols on /metrics/bird show details of
+// neighbors, and are signed like other requests.
+func isMetricsPath(path string) bool {
+	return setting.metrics && path == "/metrics"
+}
+
+// Metrics handler, records the count and duration of requests
+func metricsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(httpW http.ResponseWriter, httpR *http.Request) {
+		endpoint := metricsEndpoint(httpR.URL.Path)
+		command := metricsCommand(endpoint, httpR)
+
+		// httpsnoop keeps the http.Flusher of the writer, needed for streaming
+		m := httpsnoop.CaptureMetrics(next, httpW, httpR)
+
+		metricRequests.WithLabelValues(endpoint, command, strconv.Itoa(m.Code)).Inc()
+		metricRequestDuration.WithLabelValues(endpoint, command).Observe(m.Duration.Seconds())
+	})
+}
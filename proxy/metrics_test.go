@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	w := httptest.NewRecorder()
+	metricsRegistryHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	return w.Body.String()
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	assert.Equal(t, metricsEndpoint("/bird6"), "bird")
+	assert.Equal(t, metricsEndpoint("/traceroute"), "traceroute")
+	assert.Equal(t, metricsEndpoint("/ping6"), "ping")
+	assert.Equal(t, metricsEndpoint("/metrics"), "metrics")
+	assert.Equal(t, metricsEndpoint("/metrics/bird"), "metrics")
+	assert.Equal(t, metricsEndpoint("/healthz"), "healthz")
+	assert.Equal(t, metricsEndpoint("/whatever"), "other")
+}
+
+func TestMetricsCommand(t *testing.T) {
+	for query, expected := range map[string]string{
+		"show route for 1.1.1.1": "show route ...",
+		"SHOW  Protocols":        "show protocols ...",
+		"show":                   "other",
+		"show status":            "other",
+		"show aaaa":              "other",
+		"show 'unterminated":     "other",
+		"":                       "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape(query), nil)
+		assert.Equal(t, metricsCommand("bird", r), expected)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/traceroute?q=1.1.1.1", nil)
+	assert.Equal(t, metricsCommand("traceroute", r), "")
+}
+
+func TestMetricsCommandAllowlist(t *testing.T) {
+	setting.birdAllowedCmds = parseBirdCommandPatterns([]string{"show status", "show route for * all"})
+	defer func() { setting.birdAllowedCmds = nil }()
+
+	for query, expected := range map[string]string{
+		"show status":                "show status",
+		"show route for 1.1.1.1 all": "show route for * all",
+		"show route for 1.1.1.1":     "other",
+		"show protocols":             "other",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape(query), nil)
+		assert.Equal(t, metricsCommand("bird", r), expected)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	handler := metricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.(http.Flusher).Flush()
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("show route for 192.0.2.1"), nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, w.Flushed, true)
+	body := scrapeMetrics(t)
+	if !strings.Contains(body, `birdlg_proxy_requests_total{code="418",command="show route ...",endpoint="bird"} 1`) {
+		t.Errorf("Request not counted:\n%s", body)
+	}
+	if !strings.Contains(body, `birdlg_proxy_request_duration_seconds_count{command="show route ...",endpoint="bird"} 1`) {
+		t.Errorf("Request duration not recorded:\n%s", body)
+	}
+}
+
+func TestMetricsBirdSocketErrors(t *testing.T) {
+	setting.birdSocket = "/nonexistent.sock"
+	before := testutil.ToFloat64(metricBirdSocketErrors.WithLabelValues("connect"))
+
+	r := httptest.NewRequest(http.MethodGet, "/bird?q="+url.QueryEscape("show status"), nil)
+	w := httptest.NewRecorder()
+	birdHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, testutil.ToFloat64(metricBirdSocketErrors.WithLabelValues("connect")), before+1)
+}
+
+func TestMetricsTracerouteSlots(t *testing.T) {
+	initTracerouteSemaphore(2)
+	setting.tr_max_concurrent = 2
+	defer func() {
+		setting.tr_max_concurrent = 0
+		tracerouteSemaphore = nil
+	}()
+	before := testutil.ToFloat64(metricTracerouteRejected)
+
+	release1, _ := tracerouteAcquire()
+	release2, _ := tracerouteAcquire()
+	if _, ok := tracerouteAcquire(); ok {
+		t.Error("Third traceroute should be rejected")
+	}
+
+	body := scrapeMetrics(t)
+	if !strings.Contains(body, "birdlg_proxy_traceroute_slots_in_use 2\n") || !strings.Contains(body, "birdlg_proxy_traceroute_slots 2\n") {
+		t.Errorf("Unexpected traceroute slots:\n%s", body)
+	}
+	assert.Equal(t, testutil.ToFloat64(metricTracerouteRejected), before+1)
+
+	release1()
+	release2()
+	body = scrapeMetrics(t)
+	if !strings.Contains(body, "birdlg_proxy_traceroute_slots_in_use 0\n") {
+		t.Errorf("Unexpected traceroute slots:\n%s", body)
+	}
+}
+
+func TestAccessHandlerMetricsUnsigned(t *testing.T) {
+	setting.allowedNets = nil
+	setting.verifier = reqsign.NewVerifier([]byte("secret"), 0)
+	setting.metrics = true
+	defer func() {
+		setting.verifier = nil
+		setting.metrics = false
+	}()
+
+	// Prometheus can't sign requests
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	accessHandler(metricsRegistryHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// Signature is still required if metrics are disabled
+	setting.metrics = false
+	w = httptest.NewRecorder()
+	accessHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	assert.Equal(t, w.Code, http.StatusForbidden)
+}
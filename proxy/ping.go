@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Run the configured ping, either the built-in one or an external binary
+func pingRun(ctx context.Context, target string, handleLine func(line string)) error {
+	if setting.pingBin == tracerouteBuiltinBin {
+		return pingBuiltinExecuteStream(ctx, setting.pingFlags, target, handleLine)
+	}
+
+	args := setting.pingFlags
+	if setting.vrf != "" {
+		// Bind to the VRF device, supported by ping from iputils
+		args = append([]string{"-I", setting.vrf}, args...)
+	}
+	return tracerouteExecuteStream(ctx, setting.pingBin, args, target, handleLine)
+}
+
+func pingDetect(cmd string, args []string) bool {
+	target := "127.0.0.1"
+	success := false
+	if result, err := tracerouteTryExecute(context.Background(), cmd, args, target); err == nil {
+		setting.pingBin = cmd
+		setting.pingFlags = args
+		success = true
+		fmt.Printf("Ping autodetect success: %s\n", tracerouteArgsToString(cmd, args, target))
+	} else {
+		fmt.Printf("Ping autodetect fail, continuing: %s (%s)\n%s", tracerouteArgsToString(cmd, args, target), err.Error(), result)
+	}
+
+	return success
+}
+
+func pingAutodetect() {
+	if setting.pingBin == tracerouteBuiltinBin {
+		if err := pingBuiltinCheck(setting.pingFlags); err != nil {
+			setting.pingBin = ""
+			setting.pingFlags = nil
+			fmt.Printf("Built-in ping failed to start, ping will be disabled: %s\n", err.Error())
+		} else {
+			fmt.Printf("Using built-in ping\n")
+		}
+		return
+	}
+
+	if setting.pingBin != "" && len(setting.pingFlags) > 0 {
+		return
+	}
+
+	cmd := setting.pingBin
+	if cmd == "" {
+		cmd = "ping"
+	}
+
+	// Ping from iputils, FreeBSD and Busybox, the interval flag isn't
+	// supported everywhere
+	if pingDetect(cmd, []string{"-c4", "-i0.2", "-W1"}) {
+		return
+	}
+	if pingDetect(cmd, []string{"-c4", "-W1"}) {
+		return
+	}
+	if pingDetect(cmd, []string{"-c4"}) {
+		return
+	}
+
+	// Unsupported
+	setting.pingBin = ""
+	setting.pingFlags = nil
+	println("Ping autodetect failed! Ping will be disabled")
+}
+
+// Options of the built-in ping, parsed from ping_flags
+type pingBuiltinOptions struct {
+	count    int
+	interval time.Duration
+	timeout  time.Duration
+	family   int
+}
+
+// Parse ping_flags for the built-in ping. A subset of flags of ping from
+// iputils is supported:
+//
+//	-4, -6    use IPv4 or IPv6 only
+//	-c N      number of echo requests (default 4)
+//	-i SEC    interval between echo requests (default 1, at least 0.2)
+//	-W SEC    time to wait for a response (default 1)
+func parsePingBuiltinFlags(args []string) (pingBuiltinOptions, error) {
+	opts := pingBuiltinOptions{
+		count:    4,
+		interval: time.Second,
+		timeout:  time.Second,
+	}
+
+	err := parseBuiltinFlags(args, map[byte]func(){
+		'4': func() { opts.family = 4 },
+		'6': func() { opts.family = 6 },
+		'n': func() {},
+	}, map[byte]func(value string) error{
+		'c': parseBuiltinPositiveInt(&opts.count),
+		'i': parseBuiltinDuration(&opts.interval),
+		'W': parseBuiltinDuration(&opts.timeout),
+	})
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.interval < 200*time.Millisecond {
+		return opts, fmt.Errorf("interval must be at least 0.2 seconds")
+	}
+	return opts, nil
+}
+
+// Run ping with the given prober, and pass the output in the format of ping
+// from iputils to handleLine line by line
+func pingBuiltinPing(ctx context.Context, prober tracerouteProber, opts pingBuiltinOptions, target string, dst net.IP, handleLine func(line string)) error {
+	headerSize := 20
+	if dst.To4() == nil {
+		headerSize = 40
+	}
+	handleLine(fmt.Sprintf("PING %s (%s) %d(%d) bytes of data.\n",
+		target, dst.String(), tracerouteBuiltinPayloadSize, headerSize+8+tracerouteBuiltinPayloadSize))
+
+	start := time.Now()
+	var rtts []float64
+	transmitted := 0
+	for seq := 1; seq <= opts.count; seq++ {
+		probeStart := time.Now()
+		result, err := prober.probe(ctx, 64)
+		if err != nil {
+			return err
+		}
+		transmitted++
+
+		switch {
+		case result.timeout:
+			handleLine(fmt.Sprintf("Request timeout for icmp_seq=%d\n", seq))
+		case result.final && result.annotation == "":
+			rtt := float64(result.rtt.Microseconds()) / 1000
+			rtts = append(rtts, rtt)
+			handleLine(fmt.Sprintf("%d bytes from %s: icmp_seq=%d time=%.3f ms\n",
+				8+tracerouteBuiltinPayloadSize, result.addr.String(), seq, rtt))
+		case result.final:
+			handleLine(fmt.Sprintf("From %s icmp_seq=%d Destination unreachable (%s)\n", result.addr.String(), seq, result.annotation))
+		default:
+			handleLine(fmt.Sprintf("From %s icmp_seq=%d Time to live exceeded\n", result.addr.String(), seq))
+		}
+
+		if seq < opts.count {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(probeStart.Add(opts.interval))):
+			}
+		}
+	}
+
+	handleLine(fmt.Sprintf("\n--- %s ping statistics ---\n", target))
+	handleLine(fmt.Sprintf("%d packets transmitted, %d received, %d%% packet loss, time %dms\n",
+		transmitted, len(rtts), (transmitted-len(rtts))*100/transmitted, time.Since(start).Milliseconds()))
+	if len(rtts) > 0 {
+		best, worst, sum, sumSquares := rtts[0], rtts[0], 0.0, 0.0
+		for _, rtt := range rtts {
+			best = math.Min(best, rtt)
+			worst = math.Max(worst, rtt)
+			sum += rtt
+			sumSquares += rtt * rtt
+		}
+		avg := sum / float64(len(rtts))
+		mdev := math.Sqrt(math.Max(sumSquares/float64(len(rtts))-avg*avg, 0))
+		handleLine(fmt.Sprintf("rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", best, avg, worst, mdev))
+	}
+	return nil
+}
+
+// Run the built-in ping, and pass its output to handleLine line by line as
+// soon as it's available. Stops when ctx is cancelled.
+func pingBuiltinExecuteStream(ctx context.Context, args []string, target string, handleLine func(line string)) error {
+	opts, err := parsePingBuiltinFlags(args)
+	if err != nil {
+		return err
+	}
+
+	dst, err := tracerouteBuiltinResolve(ctx, target, opts.family)
+	if err != nil {
+		return err
+	}
+
+	prober, err := newTracerouteProber(dst, tracerouteBuiltinOptions{icmp: true, timeout: opts.timeout})
+	if err != nil {
+		return err
+	}
+	defer prober.Close()
+
+	return pingBuiltinPing(ctx, prober, opts, target, dst, handleLine)
+}
+
+// Check if the built-in ping can run with the given flags
+func pingBuiltinCheck(args []string) error {
+	opts, err := parsePingBuiltinFlags(args)
+	if err != nil {
+		return err
+	}
+
+	dst := net.ParseIP("127.0.0.1")
+	if opts.family == 6 {
+		dst = net.ParseIP("::1")
+	}
+	prober, err := newTracerouteProber(dst, tracerouteBuiltinOptions{icmp: true, timeout: opts.timeout})
+	if err != nil {
+		return err
+	}
+	return prober.Close()
+}
+
+func pingHandler(httpW http.ResponseWriter, httpR *http.Request) {
+	query := string(httpR.URL.Query().Get("q"))
+	query = strings.TrimSpace(query)
+
+	// Ping shares the concurrency limit with traceroute
+	release, ok := tracerouteAcquire()
+	if !ok {
+		httpW.WriteHeader(http.StatusServiceUnavailable)
+		httpW.Write([]byte("Too many concurrent ping requests. Please try again later.\n"))
+		return
+	}
+	defer release()
+
+	if query == "" {
+		invalidHandler(httpW, httpR)
+		return
+	}
+	if strings.HasPrefix(query, "-") {
+		httpW.WriteHeader(http.StatusBadRequest)
+		httpW.Write([]byte("Invalid target.\n"))
+		return
+	}
+	if setting.pingBin == "" {
+		httpW.WriteHeader(http.StatusInternalServerError)
+		httpW.Write([]byte("ping not supported on this node.\n"))
+		return
+	}
+
+	// Status code is sent with the first output, so errors before any output still get a 500
+	out := newFlushWriter(httpW)
+	defer out.Close()
+	written := false
+	err := pingRun(httpR.Context(), query, func(line string) {
+		written = true
+		out.Write([]byte(line))
+	})
+
+	if err != nil && !written {
+		httpW.WriteHeader(http.StatusInternalServerError)
+		out.Write([]byte(fmt.Sprintf("Error executing ping: %s\n", err.Error())))
+	} else if err != nil {
+		out.Write([]byte(fmt.Sprintf("\nError executing ping: %s\n", err.Error())))
+	}
+}
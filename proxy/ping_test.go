@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestParsePingBuiltinFlags(t *testing.T) {
+	opts, err := parsePingBuiltinFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, opts.count, 4)
+	assert.Equal(t, opts.interval, time.Second)
+	assert.Equal(t, opts.timeout, time.Second)
+
+	opts, err = parsePingBuiltinFlags([]string{"-6n", "-c", "10", "-i0.5", "-W", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, opts.family, 6)
+	assert.Equal(t, opts.count, 10)
+	assert.Equal(t, opts.interval, 500*time.Millisecond)
+	assert.Equal(t, opts.timeout, 2*time.Second)
+}
+
+func TestParsePingBuiltinFlagsInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-c"},
+		{"-c", "0"},
+		{"-i", "0.01"},
+		{"-f"},
+	} {
+		if _, err := parsePingBuiltinFlags(args); err == nil {
+			t.Errorf("Should fail to parse %q", args)
+		}
+	}
+}
+
+func TestPingBuiltinPing(t *testing.T) {
+	prober := &mockTracerouteProber{results: map[int][]tracerouteProbeResult{
+		64: {
+			{addr: net.ParseIP("1.1.1.1"), rtt: 10 * time.Millisecond, final: true},
+			{timeout: true},
+			{addr: net.ParseIP("1.1.1.1"), rtt: 20 * time.Millisecond, final: true},
+			{addr: net.ParseIP("10.0.0.1"), rtt: time.Millisecond, final: true, annotation: "!H"},
+		},
+	}}
+	opts, _ := parsePingBuiltinFlags([]string{"-c4", "-i0.2"})
+
+	var lines []string
+	err := pingBuiltinPing(context.Background(), prober, opts, "one.one.one.one", net.ParseIP("1.1.1.1"), func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(lines), 8)
+	assert.Equal(t, lines[0], "PING one.one.one.one (1.1.1.1) 32(60) bytes of data.\n")
+	assert.Equal(t, lines[1], "40 bytes from 1.1.1.1: icmp_seq=1 time=10.000 ms\n")
+	assert.Equal(t, lines[2], "Request timeout for icmp_seq=2\n")
+	assert.Equal(t, lines[4], "From 10.0.0.1 icmp_seq=4 Destination unreachable (!H)\n")
+	assert.Equal(t, lines[5], "\n--- one.one.one.one ping statistics ---\n")
+	if !strings.HasPrefix(lines[6], "4 packets transmitted, 2 received, 50% packet loss") {
+		t.Errorf("Unexpected statistics %q", lines[6])
+	}
+	assert.Equal(t, lines[7], "rtt min/avg/max/mdev = 10.000/15.000/20.000/5.000 ms\n")
+}
+
+func TestPingBuiltinPingCancelled(t *testing.T) {
+	prober := &mockTracerouteProber{results: map[int][]tracerouteProbeResult{}}
+	opts, _ := parsePingBuiltinFlags([]string{"-c100"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := pingBuiltinPing(ctx, prober, opts, "1.1.1.1", net.ParseIP("1.1.1.1"), func(line string) {})
+	if err == nil {
+		t.Error("Should trigger error, not triggered")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Ping not stopped after cancellation")
+	}
+}
+
+func TestPingAutodetectFail(t *testing.T) {
+	pathBackup := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	defer os.Setenv("PATH", pathBackup)
+
+	setting.pingBin = ""
+	setting.pingFlags = nil
+	pingAutodetect()
+
+	assert.Equal(t, setting.pingBin, "")
+}
+
+func TestPingAutodetectExisting(t *testing.T) {
+	setting.pingBin = "mock"
+	setting.pingFlags = []string{"mock"}
+	pingAutodetect()
+
+	assert.Equal(t, setting.pingBin, "mock")
+	assert.Equal(t, setting.pingFlags, []string{"mock"})
+}
+
+func TestPingHandlerWithoutQuery(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+}
+
+func TestPingHandlerRejectsDashTarget(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.pingBin = "sh"
+	setting.pingFlags = []string{"-c", "echo Unreachable"}
+
+	r := httptest.NewRequest(http.MethodGet, "/ping?q="+url.QueryEscape("-c"), nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+}
+
+func TestPingHandlerNotSupported(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.pingBin = ""
+
+	r := httptest.NewRequest(http.MethodGet, "/ping?q="+url.QueryEscape("1.1.1.1"), nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, w.Body.String(), "ping not supported on this node.\n")
+}
+
+func TestPingHandler(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.pingBin = "sh"
+	setting.pingFlags = []string{"-c", `echo "Ping $0"`}
+	setting.vrf = ""
+
+	r := httptest.NewRequest(http.MethodGet, "/ping?q="+url.QueryEscape("1.1.1.1"), nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Flushed, true)
+	assert.Equal(t, w.Body.String(), "Ping 1.1.1.1\n")
+}
+
+func TestPingHandlerExecuteError(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.pingBin = "sh"
+	setting.pingFlags = []string{"-c", "exit 1"}
+
+	r := httptest.NewRequest(http.MethodGet, "/ping?q="+url.QueryEscape("1.1.1.1"), nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	if !strings.HasPrefix(w.Body.String(), "Error executing ping") {
+		t.Errorf("Unexpected output %q", w.Body.String())
+	}
+}
+
+func TestPingHandlerSharesConcurrencyLimit(t *testing.T) {
+	initTracerouteSemaphore(1)
+	setting.tr_max_concurrent = 1
+	defer func() {
+		setting.tr_max_concurrent = 0
+		tracerouteSemaphore = nil
+	}()
+
+	// Occupy the only slot, as if a traceroute is running
+	release, ok := tracerouteAcquire()
+	if !ok {
+		t.Fatal("Failed to acquire semaphore")
+	}
+
+	setting.pingBin = "sh"
+	setting.pingFlags = []string{"-c", "echo Done"}
+	r := httptest.NewRequest(http.MethodGet, "/ping?q="+url.QueryEscape("1.1.1.1"), nil)
+	w := httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	release()
+	w = httptest.NewRecorder()
+	pingHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+)
+
+// Categories of requests with their own rate limits, same as the endpoint
+// label of metrics
+var rateLimitCategories = []string{"bird", "traceroute", "ping"}
+
+// IP of the client for rate limiting. If trust_proxy_headers is set, the IP
+// reported by a reverse proxy is used instead. It's not used for allowed_ips,
+// as the headers can be forged by clients.
+func clientIP(r *http.Request) string {
+	if setting.trustProxyHeaders {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return ratelimit.ClientIP(strings.TrimSpace(first))
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return ratelimit.ClientIP(strings.TrimSpace(realIP))
+		}
+	}
+	return ratelimit.ClientIP(r.RemoteAddr)
+}
+
+// Rate limit handler, responds with 429 if the client is over the limit of
+// the endpoint
+func rateLimitHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(httpW http.ResponseWriter, httpR *http.Request) {
+		category := metricsEndpoint(httpR.URL.Path)
+		if limiter := setting.rateLimits[category]; limiter != nil {
+			if ok, wait := limiter.Allow(clientIP(httpR)); !ok {
+				metricRateLimited.WithLabelValues(category).Inc()
+				retryAfter := ratelimit.RetryAfter(wait)
+				httpW.Header().Set("Retry-After", retryAfter)
+				httpW.WriteHeader(http.StatusTooManyRequests)
+				httpW.Write([]byte("Too many requests, please try again in " + retryAfter + " seconds.\n"))
+				return
+			}
+		}
+		next.ServeHTTP(httpW, httpR)
+	})
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/bird", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 192.0.2.1")
+	r.Header.Set("X-Real-IP", "198.51.100.2")
+
+	// Headers can be forged unless set by a trusted reverse proxy
+	setting.trustProxyHeaders = false
+	assert.Equal(t, clientIP(r), "192.0.2.1")
+
+	setting.trustProxyHeaders = true
+	defer func() { setting.trustProxyHeaders = false }()
+	assert.Equal(t, clientIP(r), "198.51.100.1")
+
+	r.Header.Del("X-Forwarded-For")
+	assert.Equal(t, clientIP(r), "198.51.100.2")
+
+	r.Header.Del("X-Real-IP")
+	assert.Equal(t, clientIP(r), "192.0.2.1")
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	setting.rateLimits = map[string]*ratelimit.Limiter{
+		"traceroute": ratelimit.NewLimiter(ratelimit.Rate{Count: 1, Per: time.Minute}),
+	}
+	defer func() { setting.rateLimits = nil }()
+	before := testutil.ToFloat64(metricRateLimited.WithLabelValues("traceroute"))
+
+	handler := rateLimitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(target string, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, request("/traceroute?q=1.1.1.1", "192.0.2.1:1234").Code, http.StatusOK)
+	w := request("/traceroute6?q=::1", "192.0.2.1:5678")
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, w.Header().Get("Retry-After"), "60")
+	assert.Equal(t, testutil.ToFloat64(metricRateLimited.WithLabelValues("traceroute")), before+1)
+
+	// Other clients and endpoints are not affected
+	assert.Equal(t, request("/traceroute?q=1.1.1.1", "192.0.2.2:1234").Code, http.StatusOK)
+	assert.Equal(t, request("/bird?q=show+status", "192.0.2.1:1234").Code, http.StatusOK)
+}
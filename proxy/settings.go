@@ -4,22 +4,42 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/google/shlex"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/xddxdd/bird-lg-go/lib/ratelimit"
+	"github.com/xddxdd/bird-lg-go/lib/reqsign"
 )
 
 type viperSettingType struct {
-	BirdSocket              string   `mapstructure:"bird_socket"`
-	BirdRestrictCmds        bool     `mapstructure:"bird_restrict_cmds"`
-	Listen                  []string `mapstructure:"listen"`
-	AllowedNets             string   `mapstructure:"allowed_ips"`
-	TracerouteBin           string   `mapstructure:"traceroute_bin"`
-	TracerouteFlags         string   `mapstructure:"traceroute_flags"`
-	TracerouteRaw           bool     `mapstructure:"traceroute_raw"`
-	TracerouteMaxConcurrent int      `mapstructure:"traceroute_max_concurrent"`
-	Vrf                     string   `mapstructure:"vrf"`
+	BirdSocket              string            `mapstructure:"bird_socket"`
+	BirdRestrictCmds        bool              `mapstructure:"bird_restrict_cmds"`
+	BirdAllowedCmds         []string          `mapstructure:"bird_allowed_cmds"`
+	BirdDeniedCmds          []string          `mapstructure:"bird_denied_cmds"`
+	Listen                  []string          `mapstructure:"listen"`
+	AllowedNets             string            `mapstructure:"allowed_ips"`
+	TracerouteBin           string            `mapstructure:"traceroute_bin"`
+	TracerouteFlags         string            `mapstructure:"traceroute_flags"`
+	TracerouteRaw           bool              `mapstructure:"traceroute_raw"`
+	TracerouteMaxConcurrent int               `mapstructure:"traceroute_max_concurrent"`
+	PingBin                 string            `mapstructure:"ping_bin"`
+	PingFlags               string            `mapstructure:"ping_flags"`
+	Vrf                     string            `mapstructure:"vrf"`
+	BirdPoolSize            int               `mapstructure:"bird_pool_size"`
+	BirdPoolIdleTimeout     int               `mapstructure:"bird_pool_idle_timeout"`
+	BirdMaxConnections      int               `mapstructure:"bird_max_connections"`
+	TLSCert                 string            `mapstructure:"tls_cert"`
+	TLSKey                  string            `mapstructure:"tls_key"`
+	TLSClientCA             string            `mapstructure:"tls_client_ca"`
+	Secret                  string            `mapstructure:"secret"`
+	SecretMaxSkew           int               `mapstructure:"secret_max_skew"`
+	Metrics                 bool              `mapstructure:"metrics"`
+	BirdMetrics             bool              `mapstructure:"bird_metrics"`
+	BirdMetricsInterval     int               `mapstructure:"bird_metrics_interval"`
+	RateLimit               map[string]string `mapstructure:"rate_limit"`
+	TrustProxyHeaders       bool              `mapstructure:"trust_proxy_headers"`
 }
 
 // Parse settings with viper, and convert to legacy setting format
@@ -64,12 +84,64 @@ func parseSettings() {
 	pflag.Int("traceroute-max-concurrent", 10, "max concurrent traceroute requests allowed")
 	viper.BindPFlag("traceroute_max_concurrent", pflag.Lookup("traceroute-max-concurrent"))
 
-	pflag.Bool("bird-restrict-cmds", true, "restrict bird commands to 'show protocols' and 'show route' only")
+	pflag.String("ping-bin", "", "ping binary file, or \"builtin\" to use the built-in ping")
+	viper.BindPFlag("ping_bin", pflag.Lookup("ping-bin"))
+
+	pflag.String("ping-flags", "", "ping flags, supports multiple flags separated with space.")
+	viper.BindPFlag("ping_flags", pflag.Lookup("ping-flags"))
+
+	pflag.Bool("bird-restrict-cmds", true, "restrict bird commands to the ones matching bird-allowed-cmds")
 	viper.BindPFlag("bird_restrict_cmds", pflag.Lookup("bird-restrict-cmds"))
 
+	pflag.StringSlice("bird-allowed-cmds", defaultBirdAllowedCmds, "patterns of bird commands allowed when bird-restrict-cmds is enabled, separated by commas")
+	viper.BindPFlag("bird_allowed_cmds", pflag.Lookup("bird-allowed-cmds"))
+
+	pflag.StringSlice("bird-denied-cmds", []string{}, "patterns of bird commands always denied, separated by commas")
+	viper.BindPFlag("bird_denied_cmds", pflag.Lookup("bird-denied-cmds"))
+
 	pflag.String("vrf", "", "VRF device to bind TCP sockets to (Linux only)")
 	viper.BindPFlag("vrf", pflag.Lookup("vrf"))
 
+	pflag.Int("bird-pool-size", 4, "max idle connections to bird socket kept for reuse, 0 to connect on every request")
+	viper.BindPFlag("bird_pool_size", pflag.Lookup("bird-pool-size"))
+
+	pflag.Int("bird-pool-idle-timeout", 60, "close idle connections to bird socket after this many seconds")
+	viper.BindPFlag("bird_pool_idle_timeout", pflag.Lookup("bird-pool-idle-timeout"))
+
+	pflag.Int("bird-max-connections", 16, "max connections to bird socket in use at the same time, queries wait for a free one; 0 for unlimited")
+	viper.BindPFlag("bird_max_connections", pflag.Lookup("bird-max-connections"))
+
+	pflag.String("tls-cert", "", "certificate file to serve HTTPS on TCP listeners, plain HTTP if not set")
+	viper.BindPFlag("tls_cert", pflag.Lookup("tls-cert"))
+
+	pflag.String("tls-key", "", "private key file of tls-cert")
+	viper.BindPFlag("tls_key", pflag.Lookup("tls-key"))
+
+	pflag.String("tls-client-ca", "", "CA certificate file to verify client certificates, clients without a valid certificate are rejected")
+	viper.BindPFlag("tls_client_ca", pflag.Lookup("tls-client-ca"))
+
+	pflag.String("secret", "", "shared secret to verify signatures of requests from the frontend, unsigned requests are rejected")
+	viper.BindPFlag("secret", pflag.Lookup("secret"))
+
+	pflag.Int("secret-max-skew", 30, "max difference in seconds between the clocks of frontend and proxy for signed requests")
+	viper.BindPFlag("secret_max_skew", pflag.Lookup("secret-max-skew"))
+
+	pflag.Bool("metrics", false, "expose Prometheus metrics on /metrics, which is not subject to request signing")
+	viper.BindPFlag("metrics", pflag.Lookup("metrics"))
+
+	pflag.Bool("bird-metrics", false, "expose states and route counts of bird protocols as Prometheus metrics on /metrics/bird")
+	viper.BindPFlag("bird_metrics", pflag.Lookup("bird-metrics"))
+
+	pflag.Int("bird-metrics-interval", 30, "query bird for metrics on /metrics/bird every this many seconds")
+	viper.BindPFlag("bird_metrics_interval", pflag.Lookup("bird-metrics-interval"))
+
+	pflag.StringToString("rate-limit", nil,
+		"max requests of each client IP for each endpoint (bird, traceroute, ping), e.g. bird=60/1m,traceroute=10/1m; not limited if not set")
+	viper.BindPFlag("rate_limit", pflag.Lookup("rate-limit"))
+
+	pflag.Bool("trust-proxy-headers", false, "use the client IP from X-Forwarded-For or X-Real-IP sent by a reverse proxy for rate limits; allowed_ips still applies to the reverse proxy")
+	viper.BindPFlag("trust_proxy_headers", pflag.Lookup("trust-proxy-headers"))
+
 	pflag.Parse()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -83,6 +155,8 @@ func parseSettings() {
 
 	setting.birdSocket = viperSettings.BirdSocket
 	setting.birdRestrictCmds = viperSettings.BirdRestrictCmds
+	setting.birdAllowedCmds = parseBirdCommandPatterns(viperSettings.BirdAllowedCmds)
+	setting.birdDeniedCmds = parseBirdCommandPatterns(viperSettings.BirdDeniedCmds)
 	setting.listen = viperSettings.Listen
 
 	if viperSettings.AllowedNets != "" {
@@ -119,9 +193,40 @@ func parseSettings() {
 		panic(err)
 	}
 
+	setting.pingBin = viperSettings.PingBin
+	setting.pingFlags, err = shlex.Split(viperSettings.PingFlags)
+	if err != nil {
+		panic(err)
+	}
+
 	setting.tr_raw = viperSettings.TracerouteRaw
 	setting.tr_max_concurrent = viperSettings.TracerouteMaxConcurrent
 	setting.vrf = viperSettings.Vrf
+	setting.birdPoolSize = viperSettings.BirdPoolSize
+	setting.birdPoolIdleTimeout = time.Duration(viperSettings.BirdPoolIdleTimeout) * time.Second
+	setting.birdMaxConns = viperSettings.BirdMaxConnections
+
+	setting.tlsConfig, err = loadTLSConfig(viperSettings.TLSCert, viperSettings.TLSKey, viperSettings.TLSClientCA)
+	if err != nil {
+		panic(err)
+	}
+
+	if viperSettings.Secret != "" {
+		setting.verifier = reqsign.NewVerifier([]byte(viperSettings.Secret), time.Duration(viperSettings.SecretMaxSkew)*time.Second)
+	}
+
+	setting.metrics = viperSettings.Metrics
+	setting.birdMetrics = viperSettings.BirdMetrics
+	if viperSettings.BirdMetricsInterval <= 0 {
+		panic("bird_metrics_interval must be positive")
+	}
+	setting.birdMetricsInterval = time.Duration(viperSettings.BirdMetricsInterval) * time.Second
+
+	setting.rateLimits, err = ratelimit.ParseLimits(viperSettings.RateLimit, rateLimitCategories)
+	if err != nil {
+		panic(err)
+	}
+	setting.trustProxyHeaders = viperSettings.TrustProxyHeaders
 
 	fmt.Printf("%#v\n", setting)
 }
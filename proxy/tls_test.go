@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+// Certificates generated for tests, all files are in PEM format
+type testCerts struct {
+	caCert     string
+	serverCert string
+	serverKey  string
+	clientCert string
+	clientKey  string
+	// Client certificate signed by another CA
+	otherClientCert string
+	otherClientKey  string
+}
+
+func writeTestPEM(t *testing.T, path string, blockType string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Generate a certificate signed by parent, or a self signed CA if parent is nil
+func generateTestCert(t *testing.T, dir string, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestPEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writeTestPEM(t, filepath.Join(dir, name+".key"), "EC PRIVATE KEY", keyDER)
+	return cert, key
+}
+
+func generateTestCerts(t *testing.T) testCerts {
+	dir := t.TempDir()
+	caTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	clientTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			KeyUsage:    x509.KeyUsageDigitalSignature,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+
+	ca, caKey := generateTestCert(t, dir, "ca", caTemplate(), nil, nil)
+	generateTestCert(t, dir, "server", &x509.Certificate{
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, ca, caKey)
+	generateTestCert(t, dir, "client", clientTemplate(), ca, caKey)
+
+	otherCA, otherCAKey := generateTestCert(t, dir, "other-ca", caTemplate(), nil, nil)
+	generateTestCert(t, dir, "other-client", clientTemplate(), otherCA, otherCAKey)
+
+	return testCerts{
+		caCert:          filepath.Join(dir, "ca.crt"),
+		serverCert:      filepath.Join(dir, "server.crt"),
+		serverKey:       filepath.Join(dir, "server.key"),
+		clientCert:      filepath.Join(dir, "client.crt"),
+		clientKey:       filepath.Join(dir, "client.key"),
+		otherClientCert: filepath.Join(dir, "other-client.crt"),
+		otherClientKey:  filepath.Join(dir, "other-client.key"),
+	}
+}
+
+// Start the proxy listener on a random port with the TLS config
+func startTLSListener(t *testing.T, config *tls.Config) string {
+	setting.tlsConfig = config
+	setting.vrf = ""
+	defer func() { setting.tlsConfig = nil }()
+
+	l, err := listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Mock Response"))
+	}))
+	return l.Addr().String()
+}
+
+// Send a request to the listener with the client certificate, if set
+func tlsTestRequest(t *testing.T, addr string, certs testCerts, clientCert string, clientKey string) (string, error) {
+	pem, err := os.ReadFile(certs.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(pem)
+	config := &tls.Config{RootCAs: pool}
+
+	if clientCert != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	client := http.Client{
+		Transport: &http.Transport{TLSClientConfig: config},
+		Timeout:   5 * time.Second,
+	}
+	response, err := client.Get("https://" + addr + "/")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	return string(body), err
+}
+
+func TestLoadTLSConfigDisabled(t *testing.T) {
+	config, err := loadTLSConfig("", "", "")
+	if err != nil {
+		t.Error(err)
+	}
+	if config != nil {
+		t.Error("TLS should be disabled")
+	}
+}
+
+func TestLoadTLSConfigInvalid(t *testing.T) {
+	certs := generateTestCerts(t)
+
+	for _, files := range [][3]string{
+		{"", "", certs.caCert},
+		{certs.serverCert, "", ""},
+		{"", certs.serverKey, ""},
+		{certs.serverCert, certs.clientKey, ""},
+		{certs.serverCert, certs.serverKey, certs.serverKey},
+		{certs.serverCert, certs.serverKey, "/nonexistent"},
+	} {
+		if _, err := loadTLSConfig(files[0], files[1], files[2]); err == nil {
+			t.Errorf("Should fail to load %q", files)
+		}
+	}
+}
+
+func TestTLSListenerWithoutClientCA(t *testing.T) {
+	certs := generateTestCerts(t)
+	config, err := loadTLSConfig(certs.serverCert, certs.serverKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSListener(t, config)
+
+	body, err := tlsTestRequest(t, addr, certs, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, body, "Mock Response")
+}
+
+func TestTLSListenerClientCertificate(t *testing.T) {
+	certs := generateTestCerts(t)
+	config, err := loadTLSConfig(certs.serverCert, certs.serverKey, certs.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTLSListener(t, config)
+
+	body, err := tlsTestRequest(t, addr, certs, certs.clientCert, certs.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, body, "Mock Response")
+
+	// No client certificate
+	if _, err := tlsTestRequest(t, addr, certs, "", ""); err == nil {
+		t.Error("Request without client certificate should fail")
+	}
+
+	// Client certificate signed by another CA
+	if _, err := tlsTestRequest(t, addr, certs, certs.otherClientCert, certs.otherClientKey); err == nil {
+		t.Error("Request with untrusted client certificate should fail")
+	}
+}
+
+func TestTLSListenerUnixSocketPlain(t *testing.T) {
+	certs := generateTestCerts(t)
+	config, err := loadTLSConfig(certs.serverCert, certs.serverKey, certs.caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setting.tlsConfig = config
+	defer func() { setting.tlsConfig = nil }()
+
+	l, err := listen(filepath.Join(t.TempDir(), "proxy.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, ok := l.(*net.UnixListener); !ok {
+		t.Error("Unix socket listener should not use TLS")
+	}
+}
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os/exec"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
+
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
 )
 
 var tracerouteSemaphore chan struct{}
@@ -17,14 +24,32 @@ func initTracerouteSemaphore(maxConcurrent int) {
 	}
 }
 
+// Acquire a slot of the concurrency limit, shared by traceroute and ping.
+// Returns false if all slots are taken.
+func tracerouteAcquire() (release func(), ok bool) {
+	if setting.tr_max_concurrent <= 0 {
+		return func() {}, true
+	}
+	select {
+	case tracerouteSemaphore <- struct{}{}:
+		return func() { <-tracerouteSemaphore }, true
+	default:
+		metricTracerouteRejected.Inc()
+		return nil, false
+	}
+}
+
 func tracerouteArgsToString(cmd string, args []string, target string) string {
 	var cmdCombined = append([]string{cmd}, args...)
 	cmdCombined = append(cmdCombined, target)
 	return strings.Join(cmdCombined, " ")
 }
 
-func tracerouteTryExecute(cmd string, args []string, target string) ([]byte, error) {
-	instance := exec.Command(cmd, append(args, target)...)
+// Run traceroute, the process is killed when ctx is cancelled
+func tracerouteTryExecute(ctx context.Context, cmd string, args []string, target string) ([]byte, error) {
+	instance := exec.CommandContext(ctx, cmd, append(args, target)...)
+	// Don't wait for grandchildren still holding the output pipe after the kill
+	instance.WaitDelay = time.Second
 	output, err := instance.CombinedOutput()
 	if err == nil {
 		return output, nil
@@ -33,10 +58,96 @@ func tracerouteTryExecute(cmd string, args []string, target string) ([]byte, err
 	return output, err
 }
 
+// Run traceroute, and pass its output to handleLine line by line as soon as
+// it's available. The process is killed when ctx is cancelled.
+func tracerouteExecuteStream(ctx context.Context, cmd string, args []string, target string, handleLine func(line string)) error {
+	instance := exec.CommandContext(ctx, cmd, append(args, target)...)
+	instance.WaitDelay = time.Second
+
+	// Use a pipe not backed by a file, so grandchildren holding the output
+	// open won't block us after the process is killed
+	reader, writer := io.Pipe()
+	instance.Stdout = writer
+	instance.Stderr = writer
+	if err := instance.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := instance.Wait()
+		writer.Close()
+		done <- err
+	}()
+
+	bufReader := bufio.NewReader(reader)
+	for {
+		line, err := bufReader.ReadString('\n')
+		if len(line) > 0 {
+			handleLine(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return <-done
+}
+
+// Run the configured traceroute, either the built-in one or an external binary
+func tracerouteRun(ctx context.Context, target string, handleLine func(line string)) error {
+	if setting.tr_bin == tracerouteBuiltinBin {
+		return tracerouteBuiltinExecuteStream(ctx, setting.tr_flags, target, handleLine)
+	}
+	return tracerouteExecuteStream(ctx, setting.tr_bin, setting.tr_flags, target, handleLine)
+}
+
+// Hops not responding in traceroute and mtr output, e.g. " 2  *" or " 3 * * *"
+var tracerouteSkippedHopRe = regexp.MustCompile(`^\s*\d*\s*(\*\s*)+$`)
+
+// Post-processes traceroute output line by line. Hops not responding are
+// removed and counted, and whitespaces around the whole output are trimmed.
+type tracerouteFilter struct {
+	write   func(s string)
+	started bool
+	pending string
+	skipped int
+}
+
+func (f *tracerouteFilter) line(line string) {
+	if strings.HasSuffix(line, "\n") && tracerouteSkippedHopRe.MatchString(strings.TrimSuffix(line, "\n")) {
+		f.skipped++
+		return
+	}
+
+	// Hold back trailing whitespaces, until we know more output follows
+	content := strings.TrimRightFunc(line, unicode.IsSpace)
+	if !f.started {
+		content = strings.TrimLeftFunc(content, unicode.IsSpace)
+	}
+	if content == "" {
+		if f.started {
+			f.pending += line
+		}
+		return
+	}
+
+	f.write(f.pending + content)
+	f.started = true
+	f.pending = line[len(strings.TrimRightFunc(line, unicode.IsSpace)):]
+}
+
+// Output the number of hops not responding
+func (f *tracerouteFilter) finish() {
+	if f.skipped > 0 {
+		f.write("\n\n" + strconv.Itoa(f.skipped) + " hops not responding.")
+	}
+}
+
 func tracerouteDetect(cmd string, args []string) bool {
 	target := "127.0.0.1"
 	success := false
-	if result, err := tracerouteTryExecute(cmd, args, target); err == nil {
+	if result, err := tracerouteTryExecute(context.Background(), cmd, args, target); err == nil {
 		setting.tr_bin = cmd
 		setting.tr_flags = args
 		success = true
@@ -49,6 +160,17 @@ func tracerouteDetect(cmd string, args []string) bool {
 }
 
 func tracerouteAutodetect() {
+	if setting.tr_bin == tracerouteBuiltinBin {
+		if err := tracerouteBuiltinCheck(setting.tr_flags); err != nil {
+			setting.tr_bin = ""
+			setting.tr_flags = nil
+			fmt.Printf("Built-in traceroute failed to start, traceroute will be disabled: %s\n", err.Error())
+		} else {
+			fmt.Printf("Using built-in traceroute\n")
+		}
+		return
+	}
+
 	if setting.tr_bin != "" && setting.tr_flags != nil {
 		return
 	}
@@ -89,58 +211,78 @@ func tracerouteAutodetect() {
 }
 
 func tracerouteHandler(httpW http.ResponseWriter, httpR *http.Request) {
-	// Check concurrency limit
-	if setting.tr_max_concurrent > 0 {
-		select {
-		case tracerouteSemaphore <- struct{}{}:
-			// Successfully acquired semaphore slot
-			defer func() { <-tracerouteSemaphore }()
-		default:
-			// Semaphore is full, reject request
-			httpW.WriteHeader(http.StatusServiceUnavailable)
-			httpW.Write([]byte("Too many concurrent traceroute requests. Please try again later.\n"))
-			return
+	query := string(httpR.URL.Query().Get("q"))
+	query = strings.TrimSpace(query)
+	jsonFormat := httpR.URL.Query().Get("format") == "json"
+
+	// Report errors in the requested format
+	writeError := func(status int, message string) {
+		if jsonFormat {
+			tracerouteWriteJSON(httpW, status, tracerouteJSONResponse{
+				Target: query,
+				Hops:   []tracerouteparser.Hop{},
+				Error:  strings.TrimSpace(message),
+			})
+		} else {
+			httpW.WriteHeader(status)
+			httpW.Write([]byte(message))
 		}
 	}
 
-	query := string(httpR.URL.Query().Get("q"))
-	query = strings.TrimSpace(query)
+	// Check concurrency limit
+	release, ok := tracerouteAcquire()
+	if !ok {
+		writeError(http.StatusServiceUnavailable, "Too many concurrent traceroute requests. Please try again later.\n")
+		return
+	}
+	defer release()
 
 	if query == "" {
 		invalidHandler(httpW, httpR)
 	} else if strings.HasPrefix(query, "-") {
-		httpW.WriteHeader(http.StatusBadRequest)
-		httpW.Write([]byte("Invalid target.\n"))
+		writeError(http.StatusBadRequest, "Invalid target.\n")
 	} else {
-		var result []byte
-		skippedCounter := 0
-
 		if setting.tr_bin == "" {
-			httpW.WriteHeader(http.StatusInternalServerError)
-			httpW.Write([]byte("traceroute not supported on this node.\n"))
+			writeError(http.StatusInternalServerError, "traceroute not supported on this node.\n")
 			return
 		}
 
-		result, err := tracerouteTryExecute(setting.tr_bin, setting.tr_flags, query)
-		if err != nil {
-			httpW.WriteHeader(http.StatusInternalServerError)
-			httpW.Write([]byte(fmt.Sprintf("Error executing traceroute: %s\n\n", err.Error())))
+		if jsonFormat {
+			tracerouteJSONHandler(httpW, httpR, query)
+			return
 		}
 
-		if result != nil {
+		// Status code is sent with the first output, so errors before any output still get a 500
+		out := newFlushWriter(httpW)
+		defer out.Close()
+		written := false
+		write := func(s string) {
+			if s != "" {
+				written = true
+				out.Write([]byte(s))
+			}
+		}
+
+		filter := tracerouteFilter{write: write}
+		err := tracerouteRun(httpR.Context(), query, func(line string) {
 			if setting.tr_raw {
-				httpW.Write(result)
+				write(line)
 			} else {
-				resultString := string(result)
-				resultString = regexp.MustCompile(`(?m)^\s*(\d*)\s*\*\n`).ReplaceAllStringFunc(resultString, func(w string) string {
-					skippedCounter++
-					return ""
-				})
-				httpW.Write([]byte(strings.TrimSpace(resultString)))
-				if skippedCounter > 0 {
-					httpW.Write([]byte("\n\n" + strconv.Itoa(skippedCounter) + " hops not responding."))
-				}
+				filter.line(line)
 			}
+		})
+
+		errorReported := false
+		if err != nil && !written {
+			httpW.WriteHeader(http.StatusInternalServerError)
+			write(fmt.Sprintf("Error executing traceroute: %s\n\n", err.Error()))
+			errorReported = true
+		}
+		if !setting.tr_raw {
+			filter.finish()
+		}
+		if err != nil && !errorReported {
+			write(fmt.Sprintf("\n\nError executing traceroute: %s\n", err.Error()))
 		}
 	}
 }
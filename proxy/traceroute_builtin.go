@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Value of traceroute_bin to use the traceroute implementation built into the proxy
+const tracerouteBuiltinBin = "builtin"
+
+const (
+	tracerouteBuiltinPayloadSize = 32
+	tracerouteBuiltinDefaultPort = 33434
+)
+
+// ICMP types and codes used by the built-in traceroute
+const (
+	icmpv4EchoReply       = 0
+	icmpv4Unreachable     = 3
+	icmpv4EchoRequest     = 8
+	icmpv4TimeExceeded    = 11
+	icmpv4PortUnreachable = 3
+
+	icmpv6Unreachable     = 1
+	icmpv6TimeExceeded    = 3
+	icmpv6EchoRequest     = 128
+	icmpv6EchoReply       = 129
+	icmpv6PortUnreachable = 4
+
+	ipProtocolICMP   = 1
+	ipProtocolUDP    = 17
+	ipProtocolICMPv6 = 58
+)
+
+// Options of the built-in traceroute, parsed from traceroute_flags.
+// Defaults are the same as the flags autodetected for external traceroute.
+type tracerouteBuiltinOptions struct {
+	icmp     bool
+	queries  int
+	timeout  time.Duration
+	firstTTL int
+	maxTTL   int
+	port     int
+	numeric  bool
+	family   int
+}
+
+// Parse traceroute_flags for the built-in traceroute. A subset of flags of
+// Linux traceroute is supported:
+//
+//	-I        use ICMP echo probes
+//	-U        use UDP probes (default)
+//	-n        don't resolve hostnames of hops
+//	-4, -6    use IPv4 or IPv6 only
+//	-q N      probes per hop (default 1)
+//	-w SEC    time to wait for a response (default 1)
+//	-f N      TTL to start from (default 1)
+//	-m N      max TTL (default 30)
+//	-p PORT   destination port of UDP probes (default 33434)
+func parseTracerouteBuiltinFlags(args []string) (tracerouteBuiltinOptions, error) {
+	opts := tracerouteBuiltinOptions{
+		queries:  1,
+		timeout:  time.Second,
+		firstTTL: 1,
+		maxTTL:   30,
+		port:     tracerouteBuiltinDefaultPort,
+	}
+
+	err := parseBuiltinFlags(args, map[byte]func(){
+		'I': func() { opts.icmp = true },
+		'U': func() { opts.icmp = false },
+		'n': func() { opts.numeric = true },
+		'4': func() { opts.family = 4 },
+		'6': func() { opts.family = 6 },
+	}, map[byte]func(value string) error{
+		'q': parseBuiltinPositiveInt(&opts.queries),
+		'w': parseBuiltinDuration(&opts.timeout),
+		'f': parseBuiltinPositiveInt(&opts.firstTTL),
+		'm': parseBuiltinPositiveInt(&opts.maxTTL),
+		'p': parseBuiltinPositiveInt(&opts.port),
+	})
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.maxTTL > 255 {
+		return opts, errors.New("max TTL must be at most 255")
+	}
+	if opts.firstTTL > opts.maxTTL {
+		return opts, errors.New("first TTL must not be larger than max TTL")
+	}
+	if opts.port > 65535 {
+		return opts, errors.New("port must be at most 65535")
+	}
+	return opts, nil
+}
+
+// Parse short flags in the style of traceroute and ping. Flags without a
+// value can be combined, e.g. "-nI", and values can follow the flag directly
+// or in the next argument, e.g. "-q1" or "-q 1".
+func parseBuiltinFlags(args []string, switches map[byte]func(), values map[byte]func(value string) error) error {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || len(arg) < 2 {
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+
+		for j := 1; j < len(arg); j++ {
+			flag := arg[j]
+			if handler, ok := switches[flag]; ok {
+				handler()
+				continue
+			}
+
+			handler, ok := values[flag]
+			if !ok {
+				return fmt.Errorf("unsupported flag -%c", flag)
+			}
+			value := arg[j+1:]
+			if value == "" {
+				if i+1 >= len(args) {
+					return fmt.Errorf("flag -%c requires a value", flag)
+				}
+				i++
+				value = args[i]
+			}
+			if err := handler(value); err != nil {
+				return fmt.Errorf("flag -%c: %s", flag, err.Error())
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// Handler of flags with a positive integer value, e.g. "-q 1"
+func parseBuiltinPositiveInt(target *int) func(value string) error {
+	return func(value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil || number <= 0 {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		*target = number
+		return nil
+	}
+}
+
+// Handler of flags with a value in seconds, e.g. "-w 0.5"
+func parseBuiltinDuration(target *time.Duration) func(value string) error {
+	return func(value string) error {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 {
+			return fmt.Errorf("invalid value %q", value)
+		}
+		*target = time.Duration(seconds * float64(time.Second))
+		return nil
+	}
+}
+
+// Result of a single probe
+type tracerouteProbeResult struct {
+	timeout bool
+	addr    net.IP
+	rtt     time.Duration
+	// The probe reached the destination, or the destination is unreachable,
+	// so traceroute should stop after this hop
+	final bool
+	// Annotation for unreachable responses, e.g. !H, !N
+	annotation string
+}
+
+// Sends probes with a given TTL, and waits for the response.
+// Implemented with raw sockets, which are platform dependent.
+type tracerouteProber interface {
+	probe(ctx context.Context, ttl int) (tracerouteProbeResult, error)
+	Close() error
+}
+
+// Resolve traceroute target, preferring the address family in the options
+func tracerouteBuiltinResolve(ctx context.Context, target string, family int) (net.IP, error) {
+	var ips []net.IP
+	if ip := net.ParseIP(target); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		isIPv4 := ip.To4() != nil
+		if family == 0 || (family == 4 && isIPv4) || (family == 6 && !isIPv4) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: no address of the requested family", target)
+}
+
+// Format the address of a hop as "hostname (address)", or just the address
+// if hostname lookup is disabled or fails
+func tracerouteBuiltinHostname(ctx context.Context, addr net.IP, opts tracerouteBuiltinOptions, cache map[string]string) string {
+	if opts.numeric {
+		return addr.String()
+	}
+	if name, ok := cache[addr.String()]; ok {
+		return name
+	}
+
+	name := addr.String()
+	lookupCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	if names, err := net.DefaultResolver.LookupAddr(lookupCtx, addr.String()); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".") + " (" + addr.String() + ")"
+	}
+	cache[addr.String()] = name
+	return name
+}
+
+// Run traceroute with the given prober, and pass the output in the format of
+// Linux traceroute to handleLine line by line
+func tracerouteBuiltinTrace(ctx context.Context, prober tracerouteProber, opts tracerouteBuiltinOptions, target string, dst net.IP, handleLine func(line string)) error {
+	headerSize := 20
+	if dst.To4() == nil {
+		headerSize = 40
+	}
+	handleLine(fmt.Sprintf("traceroute to %s (%s), %d hops max, %d byte packets\n",
+		target, dst.String(), opts.maxTTL, headerSize+8+tracerouteBuiltinPayloadSize))
+
+	hostnames := make(map[string]string)
+	for ttl := opts.firstTTL; ttl <= opts.maxTTL; ttl++ {
+		var line strings.Builder
+		fmt.Fprintf(&line, "%2d ", ttl)
+
+		var lastAddr net.IP
+		final := false
+		for i := 0; i < opts.queries; i++ {
+			result, err := prober.probe(ctx, ttl)
+			if err != nil {
+				return err
+			}
+
+			if result.timeout {
+				line.WriteString(" *")
+				continue
+			}
+			if !result.addr.Equal(lastAddr) {
+				line.WriteString(" " + tracerouteBuiltinHostname(ctx, result.addr, opts, hostnames))
+				lastAddr = result.addr
+			}
+			fmt.Fprintf(&line, "  %.3f ms", float64(result.rtt.Microseconds())/1000)
+			if result.annotation != "" {
+				line.WriteString(" " + result.annotation)
+			}
+			final = final || result.final
+		}
+
+		handleLine(line.String() + "\n")
+		if final {
+			break
+		}
+	}
+	return nil
+}
+
+// Run the built-in traceroute, and pass its output to handleLine line by
+// line as soon as it's available. Stops when ctx is cancelled.
+func tracerouteBuiltinExecuteStream(ctx context.Context, args []string, target string, handleLine func(line string)) error {
+	opts, err := parseTracerouteBuiltinFlags(args)
+	if err != nil {
+		return err
+	}
+
+	dst, err := tracerouteBuiltinResolve(ctx, target, opts.family)
+	if err != nil {
+		return err
+	}
+
+	prober, err := newTracerouteProber(dst, opts)
+	if err != nil {
+		return err
+	}
+	defer prober.Close()
+
+	return tracerouteBuiltinTrace(ctx, prober, opts, target, dst, handleLine)
+}
+
+// Check if the built-in traceroute can run with the given flags, e.g. the
+// proxy has permission to open raw sockets
+func tracerouteBuiltinCheck(args []string) error {
+	opts, err := parseTracerouteBuiltinFlags(args)
+	if err != nil {
+		return err
+	}
+
+	dst := net.ParseIP("127.0.0.1")
+	if opts.family == 6 {
+		dst = net.ParseIP("::1")
+	}
+	prober, err := newTracerouteProber(dst, opts)
+	if err != nil {
+		return err
+	}
+	return prober.Close()
+}
+
+// Internet checksum of ICMPv4 messages
+func tracerouteChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
+
+// Build an ICMP echo request. Checksum of ICMPv6 is left empty, as it's
+// filled in by the kernel.
+func tracerouteEchoRequest(ipv6 bool, id uint16, seq uint16) []byte {
+	msg := make([]byte, 8+tracerouteBuiltinPayloadSize)
+	msg[0] = icmpv4EchoRequest
+	if ipv6 {
+		msg[0] = icmpv6EchoRequest
+	}
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	for i := 8; i < len(msg); i++ {
+		msg[i] = byte(i)
+	}
+	if !ipv6 {
+		binary.BigEndian.PutUint16(msg[2:], tracerouteChecksum(msg))
+	}
+	return msg
+}
+
+// An ICMP message received by the built-in traceroute
+type tracerouteICMPMessage struct {
+	typ  int
+	code int
+	// Fields of the echo request or reply. For errors, these are of the
+	// original packet quoted in the message.
+	id  uint16
+	seq uint16
+	// Fields of the original packet quoted in error messages
+	innerProto int
+	innerDst   net.IP
+	srcPort    uint16
+	dstPort    uint16
+}
+
+// Parse an ICMP message, without the IP header
+func parseTracerouteICMPMessage(msg []byte, ipv6 bool) (tracerouteICMPMessage, error) {
+	if len(msg) < 8 {
+		return tracerouteICMPMessage{}, errors.New("ICMP message too short")
+	}
+	result := tracerouteICMPMessage{
+		typ:  int(msg[0]),
+		code: int(msg[1]),
+		id:   binary.BigEndian.Uint16(msg[4:]),
+		seq:  binary.BigEndian.Uint16(msg[6:]),
+	}
+
+	isError := result.typ == icmpv4Unreachable || result.typ == icmpv4TimeExceeded
+	if ipv6 {
+		isError = result.typ == icmpv6Unreachable || result.typ == icmpv6TimeExceeded
+	}
+	if !isError {
+		return result, nil
+	}
+	result.id, result.seq = 0, 0
+
+	// Error messages quote the IP header and the first 8 bytes of the original packet
+	inner := msg[8:]
+	var transport []byte
+	if ipv6 {
+		if len(inner) < 48 {
+			return result, errors.New("quoted IPv6 packet too short")
+		}
+		result.innerProto = int(inner[6])
+		result.innerDst = net.IP(inner[24:40])
+		transport = inner[40:48]
+	} else {
+		if len(inner) < 20 {
+			return result, errors.New("quoted IPv4 packet too short")
+		}
+		headerLen := int(inner[0]&0x0f) * 4
+		if headerLen < 20 || len(inner) < headerLen+8 {
+			return result, errors.New("quoted IPv4 packet too short")
+		}
+		result.innerProto = int(inner[9])
+		result.innerDst = net.IP(inner[16:20])
+		transport = inner[headerLen : headerLen+8]
+	}
+
+	switch result.innerProto {
+	case ipProtocolUDP:
+		result.srcPort = binary.BigEndian.Uint16(transport[0:])
+		result.dstPort = binary.BigEndian.Uint16(transport[2:])
+	case ipProtocolICMP, ipProtocolICMPv6:
+		result.id = binary.BigEndian.Uint16(transport[4:])
+		result.seq = binary.BigEndian.Uint16(transport[6:])
+	}
+	return result, nil
+}
+
+// Annotation of destination unreachable codes, same as Linux traceroute
+func tracerouteUnreachableAnnotation(code int, ipv6 bool) string {
+	if ipv6 {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!X"
+		case 3:
+			return "!H"
+		case icmpv6PortUnreachable:
+			return ""
+		}
+	} else {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!H"
+		case 2:
+			return "!P"
+		case icmpv4PortUnreachable:
+			return ""
+		case 4:
+			return "!F"
+		case 5:
+			return "!S"
+		case 9, 10, 13:
+			return "!X"
+		}
+	}
+	return "!" + strconv.Itoa(code)
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Counter to give each traceroute a different ICMP echo ID, since all raw
+// ICMP sockets receive all ICMP messages
+var tracerouteEchoIDCounter atomic.Uint32
+
+// Traceroute prober with raw sockets. ICMP responses are received from a raw
+// ICMP socket, which also sends probes in ICMP mode. In UDP mode, probes are
+// sent from a UDP socket, and matched by source and destination port.
+type tracerouteLinuxProber struct {
+	dst       net.IP
+	ipv6      bool
+	opts      tracerouteBuiltinOptions
+	icmp      *net.IPConn
+	udp       *net.UDPConn
+	localPort uint16
+	id        uint16
+	seq       uint16
+	buf       []byte
+}
+
+func newTracerouteProber(dst net.IP, opts tracerouteBuiltinOptions) (tracerouteProber, error) {
+	p := &tracerouteLinuxProber{
+		dst:  dst,
+		ipv6: dst.To4() == nil,
+		opts: opts,
+		id:   uint16(os.Getpid()) + uint16(tracerouteEchoIDCounter.Add(1)),
+		buf:  make([]byte, 1500),
+	}
+
+	lc := net.ListenConfig{Control: vrfControl(setting.vrf)}
+	icmpNetwork, icmpAddr, udpNetwork := "ip4:icmp", "0.0.0.0", "udp4"
+	if p.ipv6 {
+		icmpNetwork, icmpAddr, udpNetwork = "ip6:ipv6-icmp", "::", "udp6"
+	}
+
+	conn, err := lc.ListenPacket(context.Background(), icmpNetwork, icmpAddr)
+	if err != nil {
+		return nil, err
+	}
+	p.icmp = conn.(*net.IPConn)
+
+	if !opts.icmp {
+		conn, err := lc.ListenPacket(context.Background(), udpNetwork, ":0")
+		if err != nil {
+			p.icmp.Close()
+			return nil, err
+		}
+		p.udp = conn.(*net.UDPConn)
+		p.localPort = uint16(p.udp.LocalAddr().(*net.UDPAddr).Port)
+	}
+
+	return p, nil
+}
+
+func (p *tracerouteLinuxProber) Close() error {
+	if p.udp != nil {
+		p.udp.Close()
+	}
+	return p.icmp.Close()
+}
+
+// Set TTL (hop limit for IPv6) of outgoing packets of a socket
+func (p *tracerouteLinuxProber) setTTL(conn syscall.Conn, ttl int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sysErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if p.ipv6 {
+			sysErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, ttl)
+		} else {
+			sysErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, ttl)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sysErr
+}
+
+// Send a probe
+func (p *tracerouteLinuxProber) send(ttl int) error {
+	if p.opts.icmp {
+		if err := p.setTTL(p.icmp, ttl); err != nil {
+			return err
+		}
+		_, err := p.icmp.WriteTo(tracerouteEchoRequest(p.ipv6, p.id, p.seq), &net.IPAddr{IP: p.dst})
+		return err
+	}
+
+	if err := p.setTTL(p.udp, ttl); err != nil {
+		return err
+	}
+	payload := make([]byte, tracerouteBuiltinPayloadSize)
+	_, err := p.udp.WriteTo(payload, &net.UDPAddr{IP: p.dst, Port: p.probePort()})
+	return err
+}
+
+// Destination port of the current UDP probe, incremented for each probe
+// to match the responses, same as Linux traceroute
+func (p *tracerouteLinuxProber) probePort() int {
+	return (p.opts.port-1+int(p.seq)-1)%65535 + 1
+}
+
+// Check if an ICMP message is a response to the current probe, and convert it
+// to probe result
+func (p *tracerouteLinuxProber) match(msg tracerouteICMPMessage) (tracerouteProbeResult, bool) {
+	echoReply, unreachable, timeExceeded := icmpv4EchoReply, icmpv4Unreachable, icmpv4TimeExceeded
+	if p.ipv6 {
+		echoReply, unreachable, timeExceeded = icmpv6EchoReply, icmpv6Unreachable, icmpv6TimeExceeded
+	}
+
+	if msg.typ == echoReply {
+		if p.opts.icmp && msg.id == p.id && msg.seq == p.seq {
+			return tracerouteProbeResult{final: true}, true
+		}
+		return tracerouteProbeResult{}, false
+	}
+	if msg.typ != unreachable && msg.typ != timeExceeded {
+		return tracerouteProbeResult{}, false
+	}
+
+	// Check the quoted original packet
+	if !msg.innerDst.Equal(p.dst) {
+		return tracerouteProbeResult{}, false
+	}
+	if p.opts.icmp {
+		if (msg.innerProto != ipProtocolICMP && msg.innerProto != ipProtocolICMPv6) || msg.id != p.id || msg.seq != p.seq {
+			return tracerouteProbeResult{}, false
+		}
+	} else {
+		if msg.innerProto != ipProtocolUDP || msg.srcPort != p.localPort || int(msg.dstPort) != p.probePort() {
+			return tracerouteProbeResult{}, false
+		}
+	}
+
+	if msg.typ == timeExceeded {
+		return tracerouteProbeResult{}, true
+	}
+	// Port unreachable from the destination means the UDP probe has arrived
+	return tracerouteProbeResult{
+		final:      true,
+		annotation: tracerouteUnreachableAnnotation(msg.code, p.ipv6),
+	}, true
+}
+
+func (p *tracerouteLinuxProber) probe(ctx context.Context, ttl int) (tracerouteProbeResult, error) {
+	p.seq++
+	start := time.Now()
+	if err := p.send(ttl); err != nil {
+		return tracerouteProbeResult{}, err
+	}
+
+	// Interrupt reading when ctx is cancelled
+	p.icmp.SetReadDeadline(start.Add(p.opts.timeout))
+	stop := context.AfterFunc(ctx, func() {
+		p.icmp.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		n, from, err := p.icmp.ReadFrom(p.buf)
+		if ctx.Err() != nil {
+			return tracerouteProbeResult{}, ctx.Err()
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return tracerouteProbeResult{timeout: true}, nil
+			}
+			return tracerouteProbeResult{}, err
+		}
+
+		msg, err := parseTracerouteICMPMessage(p.buf[:n], p.ipv6)
+		if err != nil {
+			continue
+		}
+		if result, ok := p.match(msg); ok {
+			result.addr = from.(*net.IPAddr).IP
+			result.rtt = time.Since(start)
+			return result, nil
+		}
+	}
+}
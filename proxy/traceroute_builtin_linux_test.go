@@ -0,0 +1,119 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+// Raw sockets require root or CAP_NET_RAW, skip the tests otherwise
+func skipWithoutRawSocket(t *testing.T, args []string) {
+	if err := tracerouteBuiltinCheck(args); err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("Raw sockets not allowed: %s", err)
+		}
+		t.Fatal(err)
+	}
+}
+
+func TestTracerouteBuiltinLoopback(t *testing.T) {
+	for _, tt := range []struct {
+		args   []string
+		target string
+	}{
+		{[]string{"-n"}, "127.0.0.1"},
+		{[]string{"-n", "-I"}, "127.0.0.1"},
+		{[]string{"-n", "-6"}, "::1"},
+		{[]string{"-n", "-6", "-I"}, "::1"},
+	} {
+		t.Run(strings.Join(tt.args, " ")+" "+tt.target, func(t *testing.T) {
+			skipWithoutRawSocket(t, tt.args)
+
+			var lines []string
+			err := tracerouteBuiltinExecuteStream(context.Background(), append(tt.args, "-q2"), tt.target, func(line string) {
+				lines = append(lines, line)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Loopback is reached in the first hop
+			assert.Equal(t, len(lines), 2)
+			if !strings.HasPrefix(lines[1], " 1  "+tt.target+"  ") || strings.Count(lines[1], " ms") != 2 {
+				t.Errorf("Unexpected hop output %q", lines[1])
+			}
+		})
+	}
+}
+
+func TestTracerouteBuiltinConcurrent(t *testing.T) {
+	skipWithoutRawSocket(t, []string{"-I"})
+
+	// Responses to other traceroutes sharing the raw socket must be ignored
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			errs <- tracerouteBuiltinExecuteStream(context.Background(), []string{"-n", "-I", "-q5"}, "127.0.0.1", func(line string) {
+				if strings.Contains(line, "*") {
+					t.Errorf("Probe lost: %q", line)
+				}
+			})
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTracerouteHandlerBuiltin(t *testing.T) {
+	skipWithoutRawSocket(t, nil)
+
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = tracerouteBuiltinBin
+	setting.tr_flags = []string{"-n"}
+	setting.tr_raw = false
+	defer func() {
+		setting.tr_bin = ""
+		setting.tr_flags = nil
+	}()
+
+	r := httptest.NewRequest(http.MethodGet, "/traceroute?q="+url.QueryEscape("127.0.0.1")+"&format=json", nil)
+	w := httptest.NewRecorder()
+	tracerouteHandler(w, r)
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"hops":[{"hop":1,"address":"127.0.0.1"`) {
+		t.Errorf("Unexpected output %q", w.Body.String())
+	}
+}
+
+func TestPingBuiltinLoopback(t *testing.T) {
+	skipWithoutRawSocket(t, []string{"-I"})
+
+	var lines []string
+	err := pingBuiltinExecuteStream(context.Background(), []string{"-c2", "-i0.2"}, "127.0.0.1", func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(lines), 6)
+	if !strings.HasPrefix(lines[1], "40 bytes from 127.0.0.1: icmp_seq=1 ") {
+		t.Errorf("Unexpected reply output %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[4], "2 packets transmitted, 2 received, 0% packet loss") {
+		t.Errorf("Unexpected statistics %q", lines[4])
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Built-in traceroute relies on Linux specific socket options
+func newTracerouteProber(dst net.IP, opts tracerouteBuiltinOptions) (tracerouteProber, error) {
+	return nil, errors.New("built-in traceroute is only supported on Linux")
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
+)
+
+func TestParseTracerouteBuiltinFlagsDefault(t *testing.T) {
+	opts, err := parseTracerouteBuiltinFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, opts.icmp, false)
+	assert.Equal(t, opts.queries, 1)
+	assert.Equal(t, opts.timeout, time.Second)
+	assert.Equal(t, opts.firstTTL, 1)
+	assert.Equal(t, opts.maxTTL, 30)
+	assert.Equal(t, opts.port, tracerouteBuiltinDefaultPort)
+}
+
+func TestParseTracerouteBuiltinFlags(t *testing.T) {
+	opts, err := parseTracerouteBuiltinFlags([]string{"-nI", "-q3", "-w", "0.5", "-m", "20", "-f2", "-p", "5000", "-6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, opts.icmp, true)
+	assert.Equal(t, opts.numeric, true)
+	assert.Equal(t, opts.queries, 3)
+	assert.Equal(t, opts.timeout, 500*time.Millisecond)
+	assert.Equal(t, opts.maxTTL, 20)
+	assert.Equal(t, opts.firstTTL, 2)
+	assert.Equal(t, opts.port, 5000)
+	assert.Equal(t, opts.family, 6)
+}
+
+func TestParseTracerouteBuiltinFlagsInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"-N32"},
+		{"-q"},
+		{"-q", "abc"},
+		{"-w0"},
+		{"-m", "256"},
+		{"-f", "10", "-m", "5"},
+		{"-p", "70000"},
+		{"1.1.1.1"},
+	} {
+		if _, err := parseTracerouteBuiltinFlags(args); err == nil {
+			t.Errorf("Should fail to parse %q", args)
+		}
+	}
+}
+
+// Prober with predefined results for each TTL
+type mockTracerouteProber struct {
+	results map[int][]tracerouteProbeResult
+	probes  int
+}
+
+func (p *mockTracerouteProber) probe(ctx context.Context, ttl int) (tracerouteProbeResult, error) {
+	if ctx.Err() != nil {
+		return tracerouteProbeResult{}, ctx.Err()
+	}
+	p.probes++
+	results := p.results[ttl]
+	if len(results) == 0 {
+		return tracerouteProbeResult{timeout: true}, nil
+	}
+	result := results[0]
+	p.results[ttl] = results[1:]
+	return result, nil
+}
+
+func (p *mockTracerouteProber) Close() error {
+	return nil
+}
+
+func TestTracerouteBuiltinTrace(t *testing.T) {
+	prober := &mockTracerouteProber{results: map[int][]tracerouteProbeResult{
+		1: {
+			{addr: net.ParseIP("192.168.0.1"), rtt: 456 * time.Microsecond},
+			{addr: net.ParseIP("192.168.0.1"), rtt: 400 * time.Microsecond},
+		},
+		3: {
+			{addr: net.ParseIP("10.0.0.1"), rtt: 3 * time.Millisecond},
+			{addr: net.ParseIP("10.0.0.2"), rtt: 4 * time.Millisecond},
+		},
+		4: {
+			{addr: net.ParseIP("1.1.1.1"), rtt: 10 * time.Millisecond, final: true},
+			{addr: net.ParseIP("1.1.1.1"), rtt: 11 * time.Millisecond, final: true},
+		},
+		5: {
+			{addr: net.ParseIP("1.1.1.1"), rtt: 10 * time.Millisecond, final: true},
+		},
+	}}
+	opts, _ := parseTracerouteBuiltinFlags([]string{"-n", "-q2", "-m5"})
+
+	var lines []string
+	err := tracerouteBuiltinTrace(context.Background(), prober, opts, "one.one.one.one", net.ParseIP("1.1.1.1"), func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, lines, []string{
+		"traceroute to one.one.one.one (1.1.1.1), 5 hops max, 60 byte packets\n",
+		" 1  192.168.0.1  0.456 ms  0.400 ms\n",
+		" 2  * *\n",
+		" 3  10.0.0.1  3.000 ms 10.0.0.2  4.000 ms\n",
+		" 4  1.1.1.1  10.000 ms  11.000 ms\n",
+	})
+	assert.Equal(t, prober.probes, 8)
+
+	// Output can be parsed like the output of traceroute
+	result := tracerouteparser.Parse(strings.Join(lines, ""))
+	assert.Equal(t, len(result.Hops), 4)
+	assert.Equal(t, result.Hops[2].RTT, []float64{3, 4})
+}
+
+func TestTracerouteBuiltinTraceUnreachable(t *testing.T) {
+	prober := &mockTracerouteProber{results: map[int][]tracerouteProbeResult{
+		1: {{addr: net.ParseIP("2001:db8::1"), rtt: time.Millisecond, final: true, annotation: "!N"}},
+	}}
+	opts, _ := parseTracerouteBuiltinFlags([]string{"-n"})
+
+	var output strings.Builder
+	err := tracerouteBuiltinTrace(context.Background(), prober, opts, "2001:db8::2", net.ParseIP("2001:db8::2"), func(line string) {
+		output.WriteString(line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, output.String(), "traceroute to 2001:db8::2 (2001:db8::2), 30 hops max, 80 byte packets\n 1  2001:db8::1  1.000 ms !N\n")
+}
+
+func TestTracerouteBuiltinTraceCancelled(t *testing.T) {
+	prober := &mockTracerouteProber{results: map[int][]tracerouteProbeResult{}}
+	opts, _ := parseTracerouteBuiltinFlags([]string{"-n"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := tracerouteBuiltinTrace(ctx, prober, opts, "1.1.1.1", net.ParseIP("1.1.1.1"), func(line string) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context cancelled error, got %v", err)
+	}
+}
+
+func TestTracerouteBuiltinResolve(t *testing.T) {
+	ip, err := tracerouteBuiltinResolve(context.Background(), "2001:db8::1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ip.String(), "2001:db8::1")
+
+	if _, err := tracerouteBuiltinResolve(context.Background(), "2001:db8::1", 4); err == nil {
+		t.Error("Should fail to resolve IPv6 address with -4")
+	}
+}
+
+func TestTracerouteChecksum(t *testing.T) {
+	msg := tracerouteEchoRequest(false, 0x1234, 1)
+	// Checksum of a message including its checksum is 0
+	assert.Equal(t, tracerouteChecksum(msg), uint16(0))
+	assert.Equal(t, msg[0], byte(icmpv4EchoRequest))
+
+	msg = tracerouteEchoRequest(true, 0x1234, 1)
+	assert.Equal(t, msg[0], byte(icmpv6EchoRequest))
+	assert.Equal(t, binary.BigEndian.Uint16(msg[2:]), uint16(0))
+}
+
+func TestParseTracerouteICMPMessageTimeExceededUDP(t *testing.T) {
+	// Time exceeded, quoting an IPv4 UDP packet from port 40000 to 1.1.1.1:33434
+	msg := []byte{
+		icmpv4TimeExceeded, 0, 0, 0, 0, 0, 0, 0,
+		0x45, 0, 0, 60, 0, 0, 0, 0, 1, ipProtocolUDP, 0, 0, 192, 168, 0, 2, 1, 1, 1, 1,
+		0x9c, 0x40, 0x82, 0x9a, 0, 40, 0, 0,
+	}
+	result, err := parseTracerouteICMPMessage(msg, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.typ, icmpv4TimeExceeded)
+	assert.Equal(t, result.innerProto, ipProtocolUDP)
+	assert.Equal(t, result.innerDst.String(), "1.1.1.1")
+	assert.Equal(t, result.srcPort, uint16(40000))
+	assert.Equal(t, result.dstPort, uint16(33434))
+}
+
+func TestParseTracerouteICMPMessageUnreachableICMPv6(t *testing.T) {
+	// Destination unreachable, quoting an ICMPv6 echo request to 2001:db8::2
+	msg := make([]byte, 8+40+8)
+	msg[0] = icmpv6Unreachable
+	msg[1] = 3
+	msg[8+6] = ipProtocolICMPv6
+	copy(msg[8+24:], net.ParseIP("2001:db8::2"))
+	copy(msg[8+40:], tracerouteEchoRequest(true, 0x1234, 5)[:8])
+
+	result, err := parseTracerouteICMPMessage(msg, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, result.code, 3)
+	assert.Equal(t, result.innerDst.String(), "2001:db8::2")
+	assert.Equal(t, result.id, uint16(0x1234))
+	assert.Equal(t, result.seq, uint16(5))
+	assert.Equal(t, tracerouteUnreachableAnnotation(result.code, true), "!H")
+}
+
+func TestParseTracerouteICMPMessageTruncated(t *testing.T) {
+	for _, msg := range [][]byte{
+		{icmpv4TimeExceeded, 0, 0},
+		{icmpv4TimeExceeded, 0, 0, 0, 0, 0, 0, 0, 0x45, 0, 0, 60},
+	} {
+		if _, err := parseTracerouteICMPMessage(msg, false); err == nil {
+			t.Errorf("Should fail to parse %v", msg)
+		}
+	}
+}
+
+func TestTracerouteAutodetectBuiltinInvalidFlags(t *testing.T) {
+	setting.tr_bin = tracerouteBuiltinBin
+	setting.tr_flags = []string{"--invalid"}
+	tracerouteAutodetect()
+
+	assert.Equal(t, setting.tr_bin, "")
+}
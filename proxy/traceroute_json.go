@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/xddxdd/bird-lg-go/lib/tracerouteparser"
+)
+
+type tracerouteJSONResponse struct {
+	Target string                 `json:"target"`
+	Format string                 `json:"format,omitempty"`
+	Hops   []tracerouteparser.Hop `json:"hops"`
+	Raw    string                 `json:"raw"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// Write a JSON response for the traceroute endpoint
+func tracerouteWriteJSON(httpW http.ResponseWriter, status int, response tracerouteJSONResponse) {
+	httpW.Header().Set("Content-Type", "application/json")
+	httpW.WriteHeader(status)
+	json.NewEncoder(httpW).Encode(response)
+}
+
+// Run traceroute, and return its hops parsed from the output
+func tracerouteJSONHandler(httpW http.ResponseWriter, httpR *http.Request, target string) {
+	var raw strings.Builder
+	err := tracerouteRun(httpR.Context(), target, func(line string) {
+		raw.WriteString(line)
+	})
+
+	result := tracerouteparser.Parse(raw.String())
+	response := tracerouteJSONResponse{
+		Target: target,
+		Format: result.Format,
+		Hops:   result.Hops,
+		Raw:    raw.String(),
+	}
+
+	status := http.StatusOK
+	if err != nil {
+		response.Error = "Error executing traceroute: " + err.Error()
+		// Same as text output, only fail the request if there's nothing to show
+		if raw.Len() == 0 {
+			status = http.StatusInternalServerError
+		}
+	}
+	tracerouteWriteJSON(httpW, status, response)
+}
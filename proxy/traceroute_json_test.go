@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+)
+
+const tracerouteMockOutput = `traceroute to 1.1.1.1 (1.1.1.1), 30 hops max, 60 byte packets
+ 1  _gateway (192.168.1.1)  0.456 ms
+ 2  *
+ 3  one.one.one.one (1.1.1.1)  10.112 ms`
+
+func tracerouteJSONRequest(t *testing.T, query string) (*httptest.ResponseRecorder, tracerouteJSONResponse) {
+	r := httptest.NewRequest(http.MethodGet, "/traceroute?format=json&q="+url.QueryEscape(query), nil)
+	w := httptest.NewRecorder()
+	tracerouteHandler(w, r)
+
+	var response tracerouteJSONResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	return w, response
+}
+
+func TestTracerouteHandlerJSON(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = "sh"
+	setting.tr_flags = []string{"-c", "echo '" + tracerouteMockOutput + "'"}
+	setting.tr_raw = false
+
+	w, response := tracerouteJSONRequest(t, "1.1.1.1")
+
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Equal(t, response.Target, "1.1.1.1")
+	assert.Equal(t, response.Format, "traceroute")
+	assert.Equal(t, response.Raw, tracerouteMockOutput+"\n")
+	assert.Equal(t, len(response.Hops), 3)
+	assert.Equal(t, response.Hops[0].Address, "192.168.1.1")
+	assert.Equal(t, response.Hops[1].Loss, float64(100))
+	assert.Equal(t, response.Hops[2].Hostname, "one.one.one.one")
+}
+
+func TestTracerouteHandlerJSONExecuteError(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = "sh"
+	setting.tr_flags = []string{"-c", "false"}
+
+	w, response := tracerouteJSONRequest(t, "1.1.1.1")
+
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, len(response.Hops), 0)
+	assert.Equal(t, response.Error, "Error executing traceroute: exit status 1")
+}
+
+func TestTracerouteHandlerJSONInvalidTarget(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = "sh"
+
+	w, response := tracerouteJSONRequest(t, "-I 1.1.1.1")
+
+	assert.Equal(t, w.Code, http.StatusBadRequest)
+	assert.Equal(t, response.Error, "Invalid target.")
+}
+
+func TestTracerouteHandlerJSONNotSupported(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = ""
+
+	w, response := tracerouteJSONRequest(t, "1.1.1.1")
+
+	assert.Equal(t, w.Code, http.StatusInternalServerError)
+	assert.Equal(t, response.Error, "traceroute not supported on this node.")
+}
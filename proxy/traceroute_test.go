@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/magiconair/properties/assert"
 )
@@ -22,7 +24,7 @@ func TestTracerouteArgsToString(t *testing.T) {
 }
 
 func TestTracerouteTryExecuteSuccess(t *testing.T) {
-	_, err := tracerouteTryExecute("sh", []string{
+	_, err := tracerouteTryExecute(context.Background(), "sh", []string{
 		"-c",
 	}, "true")
 
@@ -32,7 +34,7 @@ func TestTracerouteTryExecuteSuccess(t *testing.T) {
 }
 
 func TestTracerouteTryExecuteFail(t *testing.T) {
-	_, err := tracerouteTryExecute("sh", []string{
+	_, err := tracerouteTryExecute(context.Background(), "sh", []string{
 		"-c",
 	}, "false")
 
@@ -227,3 +229,100 @@ func TestTracerouteHandlerConcurrencyLimit(t *testing.T) {
 			round, statusCodes[http.StatusOK], statusCodes[http.StatusServiceUnavailable])
 	}
 }
+
+func TestTracerouteTryExecuteCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := tracerouteTryExecute(ctx, "sh", []string{
+		"-c",
+	}, "sleep 10")
+
+	if err == nil {
+		t.Error("Should trigger error, not triggered")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Traceroute not killed after cancellation")
+	}
+}
+
+func TestTracerouteHandlerClientDisconnect(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = "sh"
+	setting.tr_flags = []string{"-c", "sleep 10; echo Done"}
+	setting.tr_raw = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/traceroute?q="+url.QueryEscape("1.1.1.1"), nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	tracerouteHandler(w, r)
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("Traceroute not killed after client disconnect")
+	}
+	if strings.Contains(w.Body.String(), "Done") {
+		t.Error("Traceroute should not complete")
+	}
+}
+
+func TestTracerouteExecuteStream(t *testing.T) {
+	start := time.Now()
+	var lines []string
+	var firstLineTime time.Duration
+
+	err := tracerouteExecuteStream(context.Background(), "sh", []string{"-c"}, "echo first; sleep 1; echo second", func(line string) {
+		if len(lines) == 0 {
+			firstLineTime = time.Since(start)
+		}
+		lines = append(lines, line)
+	})
+
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, lines, []string{"first\n", "second\n"})
+	if firstLineTime >= time.Second {
+		t.Error("First line not received before the process finishes")
+	}
+}
+
+func TestTracerouteFilter(t *testing.T) {
+	var output strings.Builder
+	filter := tracerouteFilter{write: func(s string) { output.WriteString(s) }}
+
+	for _, line := range []string{
+		"\n",
+		"traceroute to 1.1.1.1, 30 hops max\n",
+		" 1  192.168.0.1  0.5 ms  \n",
+		" 2  *\n",
+		" 3  * * *\n",
+		"\n",
+		" 4  1.1.1.1  10 ms\n",
+		"\n",
+	} {
+		filter.line(line)
+	}
+	filter.finish()
+
+	assert.Equal(t, output.String(), "traceroute to 1.1.1.1, 30 hops max\n 1  192.168.0.1  0.5 ms  \n\n 4  1.1.1.1  10 ms\n\n2 hops not responding.")
+}
+
+func TestTracerouteHandlerErrorAfterOutput(t *testing.T) {
+	initTracerouteSemaphore(setting.tr_max_concurrent)
+	setting.tr_bin = "sh"
+	setting.tr_flags = []string{"-c", "echo Partial; exit 1"}
+	setting.tr_raw = false
+
+	r := httptest.NewRequest(http.MethodGet, "/traceroute?q="+url.QueryEscape("1.1.1.1"), nil)
+	w := httptest.NewRecorder()
+	tracerouteHandler(w, r)
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Flushed, true)
+	if !strings.HasPrefix(w.Body.String(), "Partial\n\nError executing traceroute") {
+		t.Errorf("Unexpected output %q", w.Body.String())
+	}
+}